@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bitrise-io/go-xcode/appleauth"
+	"github.com/bitrise-steplib/steps-deploy-to-itunesconnect-deliver/appstoreconnect"
+)
+
+// Values of the if_already_uploaded input, selecting what happens when the artifact's build is already in App Store Connect
+const (
+	alreadyUploadedFail             = "fail"
+	alreadyUploadedSkip             = "skip"
+	alreadyUploadedContinueToSubmit = "continue_to_submit"
+)
+
+// checksAlreadyUploaded reports whether the build of the artifact is looked up before the upload.
+// Without an API key the lookup is not possible, it is only attempted (and reported) if the input asks to skip the upload.
+func (cfg Config) checksAlreadyUploaded(authConfig appleauth.Credentials) bool {
+	return authConfig.APIKey != nil || (cfg.IfAlreadyUploaded != "" && cfg.IfAlreadyUploaded != alreadyUploadedFail)
+}
+
+// lookupUploadedBuild returns the build of the app with the platform, version and build number of the artifact, or nil if it is not uploaded yet
+func lookupUploadedBuild(authConfig appleauth.Credentials, target appTarget, platform string, app *artifactInfo) (*appstoreconnect.Build, error) {
+	if authConfig.APIKey == nil {
+		return nil, errors.New("looking up the uploaded builds requires an App Store Connect API key")
+	}
+	if app == nil || app.Version == "" || app.BuildNumber == "" {
+		return nil, errors.New("the version and build number of the artifact are unknown")
+	}
+	buildPlatform, ok := apiUploadPlatforms[platform]
+	if !ok {
+		return nil, fmt.Errorf("looking up the uploaded builds doesn't support the %s platform", platform)
+	}
+
+	client, err := newAppStoreConnectClient(authConfig.APIKey)
+	if err != nil {
+		return nil, err
+	}
+
+	appID, err := lookupAppID(client, target, app.BundleID)
+	if err != nil {
+		return nil, err
+	}
+
+	builds, err := client.ListBuilds(appID, buildPlatform, app.Version, app.BuildNumber)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up the builds of %s (%s): %w", app.Version, app.BuildNumber, err)
+	}
+	if len(builds) == 0 {
+		return nil, nil
+	}
+	return &builds[0], nil
+}
+
+// alreadyUploadedError is the deploy error of an artifact whose build is already uploaded, with the fail mode
+func alreadyUploadedError(app *artifactInfo, build *appstoreconnect.Build) error {
+	return fmt.Errorf("build %s (%s) is already uploaded to App Store Connect (processing state: %s), increment the build number, "+
+		"or set the If already uploaded input to skip or continue_to_submit to re-run the deploy without uploading it again",
+		app.Version, app.BuildNumber, build.Attributes.ProcessingState)
+}
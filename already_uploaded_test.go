@@ -0,0 +1,142 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-xcode/appleauth"
+	"github.com/bitrise-io/go-xcode/devportalservice"
+	"github.com/bitrise-steplib/steps-deploy-to-itunesconnect-deliver/appstoreconnect"
+	"github.com/bitrise-steplib/steps-deploy-to-itunesconnect-deliver/appstoreconnect/appstoreconnecttest"
+)
+
+func TestConfig_checksAlreadyUploaded(t *testing.T) {
+	apiKey := appleauth.Credentials{APIKey: &devportalservice.APIKeyConnection{KeyID: "key"}}
+	appleID := appleauth.Credentials{AppleID: &appleauth.AppleID{Username: "user"}}
+
+	tests := []struct {
+		name       string
+		mode       string
+		authConfig appleauth.Credentials
+		want       bool
+	}{
+		{name: "API key, fail", mode: alreadyUploadedFail, authConfig: apiKey, want: true},
+		{name: "API key, skip", mode: alreadyUploadedSkip, authConfig: apiKey, want: true},
+		{name: "Apple ID, fail", mode: alreadyUploadedFail, authConfig: appleID, want: false},
+		{name: "Apple ID, not set", authConfig: appleID, want: false},
+		{name: "Apple ID, continue_to_submit", mode: alreadyUploadedContinueToSubmit, authConfig: appleID, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{IfAlreadyUploaded: tt.mode}
+			if got := cfg.checksAlreadyUploaded(tt.authConfig); got != tt.want {
+				t.Errorf("checksAlreadyUploaded() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_deployTarget_alreadyUploaded(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		backend  uploadBackend
+		existing bool
+		// otherPlatform adds a macOS build with the version and build number of the artifact
+		otherPlatform bool
+		wantSuccess   bool
+		// wantDeliver is the deliver command line without the authentication arguments, empty if deliver is not run
+		wantDeliver string
+	}{
+		{
+			name:        "fail",
+			mode:        alreadyUploadedFail,
+			backend:     uploadBackendAltool,
+			existing:    true,
+			wantSuccess: false,
+		},
+		{
+			name:        "skip drops the review submission",
+			mode:        alreadyUploadedSkip,
+			backend:     uploadBackendAltool,
+			existing:    true,
+			wantSuccess: true,
+			wantDeliver: "--app_identifier io.bitrise.Example --app_version 1.2.3 --build_number 42 --skip_binary_upload --skip_screenshots --force --platform ios",
+		},
+		{
+			name:        "continue_to_submit",
+			mode:        alreadyUploadedContinueToSubmit,
+			backend:     uploadBackendAPI,
+			existing:    true,
+			wantSuccess: true,
+			wantDeliver: "--app_identifier io.bitrise.Example --app_version 1.2.3 --build_number 42 --skip_binary_upload --skip_screenshots --force --submit_for_review --platform ios",
+		},
+		{
+			name:          "uploaded for another platform",
+			mode:          alreadyUploadedSkip,
+			backend:       uploadBackendAltool,
+			otherPlatform: true,
+			wantSuccess:   true,
+			wantDeliver:   "/app.ipa --skip_screenshots --force --submit_for_review --platform ios",
+		},
+		{
+			name:        "not uploaded yet",
+			mode:        alreadyUploadedSkip,
+			backend:     uploadBackendAltool,
+			wantSuccess: true,
+			wantDeliver: "/app.ipa --skip_screenshots --force --submit_for_review --platform ios",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := appstoreconnecttest.NewServer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer server.Close()
+			appID := server.AddApp("io.bitrise.Example", "Example")
+			if tt.existing {
+				server.AddBuild(appID, appstoreconnect.IOS, "1.2.3", "42", appstoreconnect.ProcessingStateValid)
+			}
+			if tt.otherPlatform {
+				server.AddBuild(appID, appstoreconnect.MacOS, "1.2.3", "42", appstoreconnect.ProcessingStateValid)
+			}
+
+			baseURL, options := appStoreConnectBaseURL, apiUploadOptions
+			appStoreConnectBaseURL = server.URL + "/"
+			apiUploadOptions = appstoreconnect.BuildUploadOptions{RetryWait: time.Millisecond, PollInterval: time.Millisecond}
+			defer func() {
+				appStoreConnectBaseURL, apiUploadOptions = baseURL, options
+			}()
+
+			ipaPth := filepath.Join(t.TempDir(), "app.ipa")
+			writeTestIPA(t, ipaPth)
+			cfg := Config{IfAlreadyUploaded: tt.mode, SkipMetadata: "no", SkipScreenshots: "yes", SkipAppVersionUpdate: "no", SubmitForReview: "yes", Platform: "ios"}
+			target := appTarget{IpaPath: ipaPth, BundleID: "io.bitrise.Example"}
+			authConfig := appleauth.Credentials{APIKey: &devportalservice.APIKeyConnection{KeyID: server.KeyID, IssuerID: server.IssuerID, PrivateKey: string(server.PrivateKey)}}
+			fastlane := fastlaneInstallation{CmdSlice: []string{"fastlane"}}
+
+			runner := newFakeRunner()
+			result := deployTarget(runner, cfg, stepTimeouts{}, target, authConfig, fastlane, tt.backend, nil)
+			if result.Success != tt.wantSuccess {
+				t.Fatalf("deployTarget() = %+v, want success %v", result, tt.wantSuccess)
+			}
+			if result.AlreadyUploaded != (tt.existing && tt.wantSuccess) {
+				t.Errorf("deployTarget() already uploaded = %v, want %v", result.AlreadyUploaded, tt.existing && tt.wantSuccess)
+			}
+			if uploaded := server.UploadedBuilds(); len(uploaded) != 0 {
+				t.Errorf("uploaded builds = %+v, want none", uploaded)
+			}
+
+			commands := runner.commandLines()
+			if tt.wantDeliver == "" && len(commands) != 0 {
+				t.Errorf("deployTarget() commands = %q, want deliver not run", commands)
+			}
+			if tt.wantDeliver != "" && (len(commands) != 1 || !strings.HasSuffix(commands[0], tt.wantDeliver)) {
+				t.Errorf("deployTarget() commands = %q, want deliver %s", commands, tt.wantDeliver)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-xcode/devportalservice"
+	"github.com/bitrise-steplib/steps-deploy-to-itunesconnect-deliver/appstoreconnect"
+)
+
+// apiUploadPlatforms maps the platform input to the platform of the App Store Connect build upload
+var apiUploadPlatforms = map[string]appstoreconnect.Platform{
+	"ios":       appstoreconnect.IOS,
+	"osx":       appstoreconnect.MacOS,
+	"appletvos": appstoreconnect.TVOS,
+}
+
+// appStoreConnectBaseURL is the App Store Connect API the api backend uploads to
+var appStoreConnectBaseURL = appstoreconnect.DefaultBaseURL
+
+// apiUploadOptions are the part upload and polling settings of the api backend, the zero value uses the client defaults
+var apiUploadOptions = appstoreconnect.BuildUploadOptions{}
+
+// newAppStoreConnectClient returns an App Store Connect API client authenticated with the API key
+func newAppStoreConnectClient(apiKey *devportalservice.APIKeyConnection) (*appstoreconnect.Client, error) {
+	if apiKey == nil {
+		return nil, fmt.Errorf("the api upload backend requires an App Store Connect API key")
+	}
+	// part uploads are retried by the client, the default HTTP client doesn't retry them a second time
+	client, err := appstoreconnect.NewClient(nil, apiKey.KeyID, apiKey.IssuerID, []byte(apiKey.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+	if client.BaseURL, err = client.BaseURL.Parse(appStoreConnectBaseURL); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// apiUpload uploads an artifact with the App Store Connect build upload API, without fastlane and Xcode
+type apiUpload struct {
+	client   *appstoreconnect.Client
+	target   appTarget
+	platform string
+	// out receives the upload progress, it is the upload monitor's writer so that the heartbeat reports it
+	out     io.Writer
+	options appstoreconnect.BuildUploadOptions
+}
+
+// upload uploads the artifact as the build of the target app, the app information is read from the artifact
+func (u apiUpload) upload(deadline *phaseDeadline, pth string, app *artifactInfo) error {
+	if app == nil || app.Version == "" || app.BuildNumber == "" {
+		return fmt.Errorf("failed to read the version and build number of %s, they are required for the API upload", filepath.Base(pth))
+	}
+
+	platform, ok := apiUploadPlatforms[u.platform]
+	if !ok {
+		return fmt.Errorf("the API upload doesn't support the %s platform", u.platform)
+	}
+
+	appID, err := lookupAppID(u.client, u.target, app.BundleID)
+	if err != nil {
+		return err
+	}
+
+	options := u.options
+	options.Progress = newAPIUploadProgress(u.out).report
+
+	log.Printf("Uploading %s (version %s, build %s) to app %s", filepath.Base(pth), app.Version, app.BuildNumber, appID)
+	upload, err := u.client.UploadBuild(deadline.context(), appID, app.Version, app.BuildNumber, platform, pth, options)
+	if deadline.Exceeded() {
+		return deadline.err()
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, warning := range upload.Attributes.State.Warnings {
+		log.Warnf("%s: %s", warning.Code, warning.Description)
+	}
+	log.Donef("Uploaded %s, build upload ID: %s", filepath.Base(pth), upload.ID)
+	return nil
+}
+
+// lookupAppID returns the App Store Connect ID of the target app, looked up by bundle ID if the app ID is not set
+func lookupAppID(client *appstoreconnect.Client, target appTarget, artifactBundleID string) (string, error) {
+	if target.AppID != "" {
+		return target.AppID, nil
+	}
+
+	bundleID := target.BundleID
+	if bundleID == "" {
+		bundleID = artifactBundleID
+	}
+	if bundleID == "" {
+		return "", errors.New("no app ID or bundle ID to look up the app")
+	}
+
+	apps, err := client.ListApps(bundleID)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up the app %s: %w", bundleID, err)
+	}
+	// the bundle ID filter also returns apps whose bundle ID only starts with the filter, for example the app's extensions
+	for _, app := range apps {
+		if app.Attributes.BundleID == bundleID {
+			return app.ID, nil
+		}
+	}
+	return "", fmt.Errorf("no app found in App Store Connect with bundle ID %s", bundleID)
+}
+
+// apiUploadProgress prints the upload progress at every 10 percent
+type apiUploadProgress struct {
+	out io.Writer
+
+	mu      sync.Mutex
+	printed int64
+}
+
+func newAPIUploadProgress(out io.Writer) *apiUploadProgress {
+	return &apiUploadProgress{out: out, printed: -1}
+}
+
+func (p *apiUploadProgress) report(uploaded, total int64) {
+	if total <= 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	percent := uploaded * 100 / total
+	if step := percent / 10 * 10; step > p.printed {
+		p.printed = step
+		_, _ = fmt.Fprintf(p.out, "Uploaded %d%% (%s of %s)\n", percent, formatBytes(uploaded), formatBytes(total))
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-xcode/appleauth"
+	"github.com/bitrise-io/go-xcode/devportalservice"
+	"github.com/bitrise-steplib/steps-deploy-to-itunesconnect-deliver/appstoreconnect"
+	"github.com/bitrise-steplib/steps-deploy-to-itunesconnect-deliver/appstoreconnect/appstoreconnecttest"
+)
+
+// writeTestIPA writes an IPA of io.bitrise.Example 1.2.3 (42)
+func writeTestIPA(t *testing.T, pth string) {
+	t.Helper()
+	infoPlist, err := os.ReadFile(filepath.Join("testdata", "Info.binary.plist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	fw, err := w.Create("Payload/Example.app/Info.plist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write(infoPlist); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(pth, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_deployTarget_apiUpload(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          Config
+		existing     bool
+		wantSuccess  bool
+		wantUploaded bool
+		// wantDeliver is the deliver command line without the authentication arguments
+		wantDeliver string
+	}{
+		{
+			name:         "upload only",
+			cfg:          Config{SkipMetadata: "yes", SkipScreenshots: "yes", SkipAppVersionUpdate: "yes", SubmitForReview: "no", Platform: "ios"},
+			wantSuccess:  true,
+			wantUploaded: true,
+		},
+		{
+			name:         "deliver submits the uploaded build",
+			cfg:          Config{SkipMetadata: "yes", SkipScreenshots: "yes", SkipAppVersionUpdate: "no", SubmitForReview: "yes", Platform: "ios"},
+			wantSuccess:  true,
+			wantUploaded: true,
+			wantDeliver:  "--app_identifier io.bitrise.Example --app_version 1.2.3 --build_number 42 --skip_binary_upload --skip_screenshots --skip_metadata --force --submit_for_review --platform ios",
+		},
+		{
+			name:     "build already uploaded",
+			cfg:      Config{SkipMetadata: "no", SkipScreenshots: "yes", SkipAppVersionUpdate: "no", SubmitForReview: "no", Platform: "ios"},
+			existing: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := appstoreconnecttest.NewServer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer server.Close()
+			server.PartSize = 64
+			appID := server.AddApp("io.bitrise.Example", "Example")
+			if tt.existing {
+				server.AddBuild(appID, appstoreconnect.IOS, "1.2.3", "42", appstoreconnect.ProcessingStateValid)
+			}
+
+			baseURL, options := appStoreConnectBaseURL, apiUploadOptions
+			appStoreConnectBaseURL = server.URL + "/"
+			apiUploadOptions = appstoreconnect.BuildUploadOptions{RetryWait: time.Millisecond, PollInterval: time.Millisecond}
+			defer func() {
+				appStoreConnectBaseURL, apiUploadOptions = baseURL, options
+			}()
+
+			ipaPth := filepath.Join(t.TempDir(), "app.ipa")
+			writeTestIPA(t, ipaPth)
+			target := appTarget{IpaPath: ipaPth, BundleID: "io.bitrise.Example"}
+			authConfig := appleauth.Credentials{APIKey: &devportalservice.APIKeyConnection{KeyID: server.KeyID, IssuerID: server.IssuerID, PrivateKey: string(server.PrivateKey)}}
+			fastlane := fastlaneInstallation{CmdSlice: []string{"fastlane"}}
+
+			runner := newFakeRunner()
+			result := deployTarget(runner, tt.cfg, stepTimeouts{}, target, authConfig, fastlane, uploadBackendAPI, nil)
+			if result.Success != tt.wantSuccess {
+				t.Fatalf("deployTarget() = %+v, want success %v", result, tt.wantSuccess)
+			}
+
+			uploaded := server.UploadedBuilds()
+			if tt.wantUploaded {
+				content, err := os.ReadFile(ipaPth)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(uploaded) != 1 || uploaded[0].AppID != appID || uploaded[0].Version != "1.2.3" || uploaded[0].BuildNumber != "42" || !bytes.Equal(uploaded[0].Content, content) {
+					t.Errorf("uploaded builds = %+v, want app.ipa 1.2.3 (42) of app %s", uploaded, appID)
+				}
+			} else if len(uploaded) != 0 {
+				t.Errorf("uploaded builds = %+v, want none", uploaded)
+			}
+
+			commands := runner.commandLines()
+			if tt.wantDeliver == "" && len(commands) != 0 {
+				t.Errorf("deployTarget() commands = %q, want deliver not run", commands)
+			}
+			if tt.wantDeliver != "" && (len(commands) != 1 || !strings.HasSuffix(commands[0], tt.wantDeliver)) {
+				t.Errorf("deployTarget() commands = %q, want deliver %s", commands, tt.wantDeliver)
+			}
+		})
+	}
+}
+
+func Test_lookupAppID(t *testing.T) {
+	server, err := appstoreconnecttest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.AddApp("io.bitrise.Example.dev", "Example Dev")
+	appID := server.AddApp("io.bitrise.Example", "Example")
+
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name             string
+		target           appTarget
+		artifactBundleID string
+		want             string
+		wantErr          bool
+	}{
+		{name: "app ID input", target: appTarget{AppID: "123"}, want: "123"},
+		{name: "exact bundle ID match", target: appTarget{BundleID: "io.bitrise.Example"}, want: appID},
+		{name: "bundle ID of the artifact", artifactBundleID: "io.bitrise.Example", want: appID},
+		{name: "only a longer bundle ID matches the filter", target: appTarget{BundleID: "io.bitrise.Ex"}, wantErr: true},
+		{name: "no bundle ID", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lookupAppID(client, tt.target, tt.artifactBundleID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("lookupAppID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("lookupAppID() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_apiUploadProgress(t *testing.T) {
+	var out strings.Builder
+	progress := newAPIUploadProgress(&out)
+	for _, uploaded := range []int64{0, 50, 100, 150, 600, 700, 2000} {
+		progress.report(uploaded, 2000)
+	}
+
+	want := "Uploaded 0% (0 B of 2.0 KB)\n" +
+		"Uploaded 30% (600 B of 2.0 KB)\n" +
+		"Uploaded 100% (2.0 KB of 2.0 KB)\n"
+	if out.String() != want {
+		t.Errorf("progress output = %q, want %q", out.String(), want)
+	}
+}
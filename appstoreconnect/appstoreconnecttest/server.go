@@ -0,0 +1,735 @@
+// Package appstoreconnecttest provides a fake App Store Connect API server for offline tests.
+// It serves apps, builds, App Store versions, review submissions and build uploads from memory, and verifies
+// the JWT of every request like App Store Connect does.
+package appstoreconnecttest
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-steplib/steps-deploy-to-itunesconnect-deliver/appstoreconnect"
+)
+
+// Server is a fake App Store Connect API server
+type Server struct {
+	*httptest.Server
+
+	// KeyID, IssuerID and PrivateKey (PEM encoded) are the API key accepted by the server,
+	// set IssuerID to empty to accept the key as an individual key
+	KeyID      string
+	IssuerID   string
+	PrivateKey []byte
+
+	// PartSize is the size of the parts build upload files are split into, 5 MiB by default
+	PartSize int64
+	// FailPartUploads is the number of part uploads rejected with an internal server error before accepting them
+	FailPartUploads int
+
+	publicKey *ecdsa.PublicKey
+
+	mu          sync.Mutex
+	nextID      int
+	apps        []appstoreconnect.App
+	builds      map[string][]appstoreconnect.Build
+	preReleases map[string]preRelease
+	versions    map[string][]appstoreconnect.AppStoreVersion
+	submissions []Submission
+	uploads     []*buildUpload
+	requests    []string
+}
+
+// preRelease is the pre-release version of a build
+type preRelease struct {
+	version  string
+	platform appstoreconnect.Platform
+}
+
+// UploadedBuild is a build uploaded to the server through the build upload API
+type UploadedBuild struct {
+	AppID       string
+	Version     string
+	BuildNumber string
+	Platform    appstoreconnect.Platform
+	FileName    string
+	UTI         string
+	Content     []byte
+}
+
+type buildUpload struct {
+	upload appstoreconnect.BuildUpload
+	appID  string
+	file   *appstoreconnect.BuildUploadFile
+	parts  [][]byte
+}
+
+// Submission is a review submission created on the server
+type Submission struct {
+	appstoreconnect.ReviewSubmission
+	AppID              string
+	AppStoreVersionIDs []string
+}
+
+// NewServer starts a fake server with a freshly generated API key, the caller should Close it
+func NewServer() (*Server, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		KeyID:       "FAKEKEY123",
+		IssuerID:    "69a6de70-03db-47e3-e053-5b8c7c11a4d1",
+		PrivateKey:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+		publicKey:   &key.PublicKey,
+		builds:      map[string][]appstoreconnect.Build{},
+		preReleases: map[string]preRelease{},
+		versions:    map[string][]appstoreconnect.AppStoreVersion{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s, nil
+}
+
+// Client returns an App Store Connect client authenticated with the server's API key
+func (s *Server) Client() (*appstoreconnect.Client, error) {
+	client, err := appstoreconnect.NewClient(s.Server.Client(), s.KeyID, s.IssuerID, s.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	baseURL, err := client.BaseURL.Parse(s.URL + "/")
+	if err != nil {
+		return nil, err
+	}
+	client.BaseURL = baseURL
+	return client, nil
+}
+
+// AddApp registers an app and returns its ID
+func (s *Server) AddApp(bundleID, name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	app := appstoreconnect.App{ID: s.newID(), Type: "apps"}
+	app.Attributes.BundleID = bundleID
+	app.Attributes.Name = name
+	app.Attributes.SKU = strings.ReplaceAll(bundleID, ".", "_")
+	s.apps = append(s.apps, app)
+	return app.ID
+}
+
+// AddBuild registers a build of the app for the platform and returns its ID
+func (s *Server) AddBuild(appID string, platform appstoreconnect.Platform, version, buildNumber, processingState string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addBuild(appID, platform, version, buildNumber, processingState)
+}
+
+func (s *Server) addBuild(appID string, platform appstoreconnect.Platform, version, buildNumber, processingState string) string {
+	build := appstoreconnect.Build{ID: s.newID(), Type: "builds"}
+	build.Attributes.Version = buildNumber
+	build.Attributes.ProcessingState = processingState
+	build.Attributes.UploadedDate = time.Now().UTC().Format(time.RFC3339)
+	s.builds[appID] = append(s.builds[appID], build)
+	// the pre-release version is not a separate resource on the fake, it is kept by build ID
+	s.preReleases[build.ID] = preRelease{version: version, platform: platform}
+	return build.ID
+}
+
+// AddAppStoreVersion registers an App Store version of the app and returns its ID
+func (s *Server) AddAppStoreVersion(appID string, platform appstoreconnect.Platform, versionString, state string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	version := appstoreconnect.AppStoreVersion{ID: s.newID(), Type: "appStoreVersions"}
+	version.Attributes.Platform = platform
+	version.Attributes.VersionString = versionString
+	version.Attributes.AppStoreState = state
+	s.versions[appID] = append(s.versions[appID], version)
+	return version.ID
+}
+
+// ReviewSubmissions returns the review submissions created on the server
+func (s *Server) ReviewSubmissions() []Submission {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Submission(nil), s.submissions...)
+}
+
+// UploadedBuilds returns the builds whose upload completed
+func (s *Server) UploadedBuilds() []UploadedBuild {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var builds []UploadedBuild
+	for _, upload := range s.uploads {
+		if upload.upload.Attributes.State.State == appstoreconnect.BuildUploadStateAwaitingUpload {
+			continue
+		}
+		builds = append(builds, UploadedBuild{
+			AppID:       upload.appID,
+			Version:     upload.upload.Attributes.CFBundleShortVersionString,
+			BuildNumber: upload.upload.Attributes.CFBundleVersion,
+			Platform:    upload.upload.Attributes.Platform,
+			FileName:    upload.file.Attributes.FileName,
+			UTI:         upload.file.Attributes.UTI,
+			Content:     bytes.Join(upload.parts, nil),
+		})
+	}
+	return builds
+}
+
+// Requests returns the "METHOD path" of the requests received by the server, including rejected ones
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.requests...)
+}
+
+func (s *Server) newID() string {
+	s.nextID++
+	return fmt.Sprintf("%d", 1000000000+s.nextID)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	// part upload URLs are pre-signed, they are not authorized with the API token
+	if r.Method == http.MethodPut && len(path) == 3 && path[0] == "upload" {
+		s.uploadPart(w, r, path[1], path[2])
+		return
+	}
+
+	if err := s.authorize(r); err != nil {
+		writeError(w, http.StatusUnauthorized, "NOT_AUTHORIZED", "Authentication credentials are missing or invalid.", err.Error())
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && len(path) == 2 && path[0] == "v1" && path[1] == "apps":
+		s.listApps(w, r)
+	case r.Method == http.MethodGet && len(path) == 4 && path[0] == "v1" && path[1] == "apps" && path[3] == "appStoreVersions":
+		s.listAppStoreVersions(w, r, path[2])
+	case r.Method == http.MethodGet && len(path) == 2 && path[0] == "v1" && path[1] == "builds":
+		s.listBuilds(w, r)
+	case r.Method == http.MethodPost && len(path) == 2 && path[0] == "v1" && path[1] == "reviewSubmissions":
+		s.createReviewSubmission(w, r)
+	case r.Method == http.MethodPatch && len(path) == 3 && path[0] == "v1" && path[1] == "reviewSubmissions":
+		s.updateReviewSubmission(w, r, path[2])
+	case r.Method == http.MethodPost && len(path) == 2 && path[0] == "v1" && path[1] == "reviewSubmissionItems":
+		s.createReviewSubmissionItem(w, r)
+	case r.Method == http.MethodPost && len(path) == 2 && path[0] == "v1" && path[1] == "buildUploads":
+		s.createBuildUpload(w, r)
+	case r.Method == http.MethodGet && len(path) == 3 && path[0] == "v1" && path[1] == "buildUploads":
+		s.getBuildUpload(w, path[2])
+	case r.Method == http.MethodPost && len(path) == 2 && path[0] == "v1" && path[1] == "buildUploadFiles":
+		s.createBuildUploadFile(w, r)
+	case r.Method == http.MethodPatch && len(path) == 3 && path[0] == "v1" && path[1] == "buildUploadFiles":
+		s.commitBuildUploadFile(w, r, path[2])
+	default:
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "The specified resource does not exist", fmt.Sprintf("The path provided does not match a defined resource type: %s %s", r.Method, r.URL.Path))
+	}
+}
+
+// authorize checks the bearer token the same way App Store Connect does
+func (s *Server) authorize(r *http.Request) error {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == r.Header.Get("Authorization") {
+		return fmt.Errorf("missing bearer token")
+	}
+
+	keyID, claims, err := appstoreconnect.VerifyToken(token, func(keyID string) crypto.PublicKey {
+		if keyID != s.KeyID {
+			return nil
+		}
+		return s.publicKey
+	})
+	if err != nil {
+		return err
+	}
+	if keyID != s.KeyID {
+		return fmt.Errorf("unknown key ID: %s", keyID)
+	}
+	if claims.Audience != appstoreconnect.Audience {
+		return fmt.Errorf("invalid audience: %s", claims.Audience)
+	}
+	if claims.Issuer != s.IssuerID {
+		return fmt.Errorf("invalid issuer: %s", claims.Issuer)
+	}
+	if s.IssuerID == "" && claims.Subject != appstoreconnect.IndividualKeySubject {
+		return fmt.Errorf("invalid subject for an individual key: %s", claims.Subject)
+	}
+
+	now := time.Now().Unix()
+	if claims.Expiry <= now {
+		return fmt.Errorf("token expired")
+	}
+	if claims.Expiry-claims.IssuedAt > int64(appstoreconnect.TokenLifetime/time.Second) {
+		return fmt.Errorf("token lifetime exceeds %s", appstoreconnect.TokenLifetime)
+	}
+	return nil
+}
+
+func (s *Server) listApps(w http.ResponseWriter, r *http.Request) {
+	bundleID := r.URL.Query().Get("filter[bundleId]")
+
+	// like App Store Connect, the filter also matches the bundle IDs starting with it
+	apps := []appstoreconnect.App{}
+	for _, app := range s.apps {
+		if strings.HasPrefix(app.Attributes.BundleID, bundleID) {
+			apps = append(apps, app)
+		}
+	}
+	writeData(w, http.StatusOK, apps)
+}
+
+func (s *Server) listBuilds(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	appID := query.Get("filter[app]")
+	version := query.Get("filter[preReleaseVersion.version]")
+	platform := query.Get("filter[preReleaseVersion.platform]")
+	buildNumber := query.Get("filter[version]")
+
+	builds := []appstoreconnect.Build{}
+	for _, build := range s.builds[appID] {
+		if version != "" && s.preReleases[build.ID].version != version {
+			continue
+		}
+		if platform != "" && string(s.preReleases[build.ID].platform) != platform {
+			continue
+		}
+		if buildNumber != "" && build.Attributes.Version != buildNumber {
+			continue
+		}
+		builds = append(builds, build)
+	}
+	writeData(w, http.StatusOK, builds)
+}
+
+func (s *Server) listAppStoreVersions(w http.ResponseWriter, r *http.Request, appID string) {
+	if !s.hasApp(appID) {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "The specified resource does not exist", "There is no resource of type 'apps' with id '"+appID+"'")
+		return
+	}
+
+	query := r.URL.Query()
+	platform := query.Get("filter[platform]")
+	versionString := query.Get("filter[versionString]")
+
+	versions := []appstoreconnect.AppStoreVersion{}
+	for _, version := range s.versions[appID] {
+		if platform != "" && string(version.Attributes.Platform) != platform {
+			continue
+		}
+		if versionString != "" && version.Attributes.VersionString != versionString {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	writeData(w, http.StatusOK, versions)
+}
+
+func (s *Server) createReviewSubmission(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Data struct {
+			Attributes struct {
+				Platform appstoreconnect.Platform `json:"platform"`
+			} `json:"attributes"`
+			Relationships struct {
+				App struct {
+					Data struct {
+						ID string `json:"id"`
+					} `json:"data"`
+				} `json:"app"`
+			} `json:"relationships"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "PARAMETER_ERROR.INVALID", "A parameter has an invalid value", err.Error())
+		return
+	}
+
+	appID := request.Data.Relationships.App.Data.ID
+	if !s.hasApp(appID) {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "The specified resource does not exist", "There is no resource of type 'apps' with id '"+appID+"'")
+		return
+	}
+
+	submission := Submission{AppID: appID}
+	submission.ID = s.newID()
+	submission.Type = "reviewSubmissions"
+	submission.Attributes.Platform = request.Data.Attributes.Platform
+	submission.Attributes.State = appstoreconnect.ReviewSubmissionStateReadyForReview
+	s.submissions = append(s.submissions, submission)
+
+	writeData(w, http.StatusCreated, submission.ReviewSubmission)
+}
+
+func (s *Server) createReviewSubmissionItem(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Data struct {
+			Relationships struct {
+				ReviewSubmission struct {
+					Data struct {
+						ID string `json:"id"`
+					} `json:"data"`
+				} `json:"reviewSubmission"`
+				AppStoreVersion struct {
+					Data struct {
+						ID string `json:"id"`
+					} `json:"data"`
+				} `json:"appStoreVersion"`
+			} `json:"relationships"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "PARAMETER_ERROR.INVALID", "A parameter has an invalid value", err.Error())
+		return
+	}
+
+	submission := s.submission(request.Data.Relationships.ReviewSubmission.Data.ID)
+	if submission == nil {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "The specified resource does not exist", "There is no resource of type 'reviewSubmissions' with id '"+request.Data.Relationships.ReviewSubmission.Data.ID+"'")
+		return
+	}
+
+	versionID := request.Data.Relationships.AppStoreVersion.Data.ID
+	found := false
+	for _, version := range s.versions[submission.AppID] {
+		if version.ID == versionID {
+			found = true
+		}
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "The specified resource does not exist", "There is no resource of type 'appStoreVersions' with id '"+versionID+"'")
+		return
+	}
+
+	submission.AppStoreVersionIDs = append(submission.AppStoreVersionIDs, versionID)
+	writeData(w, http.StatusCreated, map[string]string{"id": s.newID(), "type": "reviewSubmissionItems"})
+}
+
+func (s *Server) updateReviewSubmission(w http.ResponseWriter, r *http.Request, id string) {
+	var request struct {
+		Data struct {
+			Attributes struct {
+				Submitted bool `json:"submitted"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "PARAMETER_ERROR.INVALID", "A parameter has an invalid value", err.Error())
+		return
+	}
+
+	submission := s.submission(id)
+	if submission == nil {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "The specified resource does not exist", "There is no resource of type 'reviewSubmissions' with id '"+id+"'")
+		return
+	}
+
+	if request.Data.Attributes.Submitted {
+		if len(submission.AppStoreVersionIDs) == 0 {
+			writeError(w, http.StatusConflict, "STATE_ERROR", "The request cannot be fulfilled because of the state of another resource.", "Review submission has no items to submit.")
+			return
+		}
+		submission.Attributes.Submitted = true
+		submission.Attributes.State = appstoreconnect.ReviewSubmissionStateWaitingForReview
+	}
+
+	writeData(w, http.StatusOK, submission.ReviewSubmission)
+}
+
+func (s *Server) createBuildUpload(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Data struct {
+			Attributes struct {
+				CFBundleShortVersionString string                   `json:"cfBundleShortVersionString"`
+				CFBundleVersion            string                   `json:"cfBundleVersion"`
+				Platform                   appstoreconnect.Platform `json:"platform"`
+			} `json:"attributes"`
+			Relationships struct {
+				App struct {
+					Data struct {
+						ID string `json:"id"`
+					} `json:"data"`
+				} `json:"app"`
+			} `json:"relationships"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "PARAMETER_ERROR.INVALID", "A parameter has an invalid value", err.Error())
+		return
+	}
+
+	attributes := request.Data.Attributes
+	appID := request.Data.Relationships.App.Data.ID
+	if !s.hasApp(appID) {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "The specified resource does not exist", "There is no resource of type 'apps' with id '"+appID+"'")
+		return
+	}
+	for _, build := range s.builds[appID] {
+		if s.preReleases[build.ID] == (preRelease{version: attributes.CFBundleShortVersionString, platform: attributes.Platform}) && build.Attributes.Version == attributes.CFBundleVersion {
+			writeError(w, http.StatusConflict, "ENTITY_ERROR.ATTRIBUTE.INVALID.DUPLICATE", "The provided entity includes an attribute with a value that has already been used", fmt.Sprintf("The bundle version %s has already been used for version %s.", attributes.CFBundleVersion, attributes.CFBundleShortVersionString))
+			return
+		}
+	}
+
+	upload := &buildUpload{appID: appID}
+	upload.upload.ID = s.newID()
+	upload.upload.Type = "buildUploads"
+	upload.upload.Attributes.CFBundleShortVersionString = attributes.CFBundleShortVersionString
+	upload.upload.Attributes.CFBundleVersion = attributes.CFBundleVersion
+	upload.upload.Attributes.Platform = attributes.Platform
+	upload.upload.Attributes.State.State = appstoreconnect.BuildUploadStateAwaitingUpload
+	s.uploads = append(s.uploads, upload)
+
+	writeData(w, http.StatusCreated, upload.upload)
+}
+
+// getBuildUpload completes the processing of a committed upload, so that clients see it processing once
+func (s *Server) getBuildUpload(w http.ResponseWriter, id string) {
+	upload := s.buildUpload(id)
+	if upload == nil {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "The specified resource does not exist", "There is no resource of type 'buildUploads' with id '"+id+"'")
+		return
+	}
+
+	response := upload.upload
+	if upload.upload.Attributes.State.State == appstoreconnect.BuildUploadStateProcessing {
+		upload.upload.Attributes.State.State = appstoreconnect.BuildUploadStateComplete
+		s.addBuild(upload.appID, upload.upload.Attributes.Platform, upload.upload.Attributes.CFBundleShortVersionString, upload.upload.Attributes.CFBundleVersion, "PROCESSING")
+	}
+	writeData(w, http.StatusOK, response)
+}
+
+func (s *Server) createBuildUploadFile(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Data struct {
+			Attributes struct {
+				FileName string `json:"fileName"`
+				FileSize int64  `json:"fileSize"`
+				UTI      string `json:"uti"`
+			} `json:"attributes"`
+			Relationships struct {
+				BuildUpload struct {
+					Data struct {
+						ID string `json:"id"`
+					} `json:"data"`
+				} `json:"buildUpload"`
+			} `json:"relationships"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "PARAMETER_ERROR.INVALID", "A parameter has an invalid value", err.Error())
+		return
+	}
+
+	attributes := request.Data.Attributes
+	uploadID := request.Data.Relationships.BuildUpload.Data.ID
+	upload := s.buildUpload(uploadID)
+	if upload == nil {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "The specified resource does not exist", "There is no resource of type 'buildUploads' with id '"+uploadID+"'")
+		return
+	}
+	if upload.file != nil {
+		writeError(w, http.StatusConflict, "STATE_ERROR", "The request cannot be fulfilled because of the state of another resource.", "The build upload already has a file.")
+		return
+	}
+	if attributes.FileSize <= 0 || (attributes.UTI != appstoreconnect.UTIIPA && attributes.UTI != appstoreconnect.UTIPKG) {
+		writeError(w, http.StatusUnprocessableEntity, "ENTITY_ERROR.ATTRIBUTE.INVALID", "An attribute value is invalid.", fmt.Sprintf("Invalid file: size %d, UTI %s", attributes.FileSize, attributes.UTI))
+		return
+	}
+
+	partSize := s.PartSize
+	if partSize <= 0 {
+		partSize = 5 * 1024 * 1024
+	}
+
+	file := &appstoreconnect.BuildUploadFile{ID: s.newID(), Type: "buildUploadFiles"}
+	file.Attributes.FileName = attributes.FileName
+	file.Attributes.FileSize = attributes.FileSize
+	file.Attributes.UTI = attributes.UTI
+	for offset := int64(0); offset < attributes.FileSize; offset += partSize {
+		length := partSize
+		if offset+length > attributes.FileSize {
+			length = attributes.FileSize - offset
+		}
+		file.Attributes.UploadOperations = append(file.Attributes.UploadOperations, appstoreconnect.UploadOperation{
+			Method:         http.MethodPut,
+			URL:            fmt.Sprintf("%s/upload/%s/%d", s.URL, file.ID, len(file.Attributes.UploadOperations)),
+			Offset:         offset,
+			Length:         length,
+			RequestHeaders: []appstoreconnect.HTTPHeader{{Name: "Content-Type", Value: "application/octet-stream"}},
+		})
+	}
+	upload.file = file
+	upload.parts = make([][]byte, len(file.Attributes.UploadOperations))
+
+	writeData(w, http.StatusCreated, file)
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, fileID, index string) {
+	upload := s.buildUploadOfFile(fileID)
+	partIndex, err := strconv.Atoi(index)
+	if upload == nil || err != nil || partIndex < 0 || partIndex >= len(upload.parts) {
+		http.Error(w, "no such upload part", http.StatusNotFound)
+		return
+	}
+	operation := upload.file.Attributes.UploadOperations[partIndex]
+
+	if s.FailPartUploads > 0 {
+		s.FailPartUploads--
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/octet-stream" {
+		http.Error(w, "missing request header: Content-Type", http.StatusForbidden)
+		return
+	}
+
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if int64(len(content)) != operation.Length {
+		http.Error(w, fmt.Sprintf("part length %d, expected %d", len(content), operation.Length), http.StatusBadRequest)
+		return
+	}
+	sum := md5.Sum(content)
+	if r.Header.Get("Content-MD5") != base64.StdEncoding.EncodeToString(sum[:]) {
+		http.Error(w, "BadDigest: the Content-MD5 does not match the part", http.StatusBadRequest)
+		return
+	}
+
+	upload.parts[partIndex] = content
+	w.WriteHeader(http.StatusOK)
+}
+
+// commitBuildUploadFile checks that all parts are uploaded and match the file checksum, and starts processing the upload
+func (s *Server) commitBuildUploadFile(w http.ResponseWriter, r *http.Request, id string) {
+	var request struct {
+		Data struct {
+			Attributes struct {
+				SourceFileChecksums struct {
+					File struct {
+						Hash      string `json:"hash"`
+						Algorithm string `json:"algorithm"`
+					} `json:"file"`
+				} `json:"sourceFileChecksums"`
+				Uploaded bool `json:"uploaded"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "PARAMETER_ERROR.INVALID", "A parameter has an invalid value", err.Error())
+		return
+	}
+
+	upload := s.buildUploadOfFile(id)
+	if upload == nil {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "The specified resource does not exist", "There is no resource of type 'buildUploadFiles' with id '"+id+"'")
+		return
+	}
+
+	if request.Data.Attributes.Uploaded {
+		for i, part := range upload.parts {
+			if part == nil {
+				writeError(w, http.StatusConflict, "STATE_ERROR", "The request cannot be fulfilled because of the state of another resource.", fmt.Sprintf("Part %d of the file is not uploaded.", i))
+				return
+			}
+		}
+		checksum := request.Data.Attributes.SourceFileChecksums.File
+		sum := md5.Sum(bytes.Join(upload.parts, nil))
+		if checksum.Algorithm != "MD5" || checksum.Hash != hex.EncodeToString(sum[:]) {
+			writeError(w, http.StatusConflict, "STATE_ERROR", "The request cannot be fulfilled because of the state of another resource.", "The uploaded file does not match the source file checksum.")
+			return
+		}
+		upload.file.Attributes.Uploaded = true
+		upload.upload.Attributes.State.State = appstoreconnect.BuildUploadStateProcessing
+	}
+
+	writeData(w, http.StatusOK, upload.file)
+}
+
+func (s *Server) buildUpload(id string) *buildUpload {
+	for _, upload := range s.uploads {
+		if upload.upload.ID == id {
+			return upload
+		}
+	}
+	return nil
+}
+
+func (s *Server) buildUploadOfFile(fileID string) *buildUpload {
+	for _, upload := range s.uploads {
+		if upload.file != nil && upload.file.ID == fileID {
+			return upload
+		}
+	}
+	return nil
+}
+
+func (s *Server) hasApp(appID string) bool {
+	for _, app := range s.apps {
+		if app.ID == appID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) submission(id string) *Submission {
+	for i := range s.submissions {
+		if s.submissions[i].ID == id {
+			return &s.submissions[i]
+		}
+	}
+	return nil
+}
+
+func writeData(w http.ResponseWriter, status int, data interface{}) {
+	writeJSON(w, status, map[string]interface{}{"data": data})
+}
+
+func writeError(w http.ResponseWriter, status int, code, title, detail string) {
+	writeJSON(w, status, appstoreconnect.ErrorResponse{Errors: []appstoreconnect.Error{{
+		Status: fmt.Sprintf("%d", status),
+		Code:   code,
+		Title:  title,
+		Detail: detail,
+	}}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,173 @@
+// Package appstoreconnect is a minimal App Store Connect API client, covering the resources the Step uses:
+// apps, builds, App Store versions, review submissions and build uploads.
+package appstoreconnect
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBaseURL is the base URL of the App Store Connect API
+const DefaultBaseURL = "https://api.appstoreconnect.apple.com/"
+
+// Client is an App Store Connect API client authenticated with an API key
+type Client struct {
+	BaseURL *url.URL
+
+	client     *http.Client
+	keyID      string
+	issuerID   string
+	privateKey *ecdsa.PrivateKey
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// NewClient returns a client for the API key, privateKey is the content of the .p8 key file.
+// The issuerID is empty for individual API keys.
+func NewClient(httpClient *http.Client, keyID, issuerID string, privateKey []byte) (*Client, error) {
+	key, err := ParsePrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL, err := url.Parse(DefaultBaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &Client{
+		BaseURL:    baseURL,
+		client:     httpClient,
+		keyID:      keyID,
+		issuerID:   issuerID,
+		privateKey: key,
+	}, nil
+}
+
+// ErrorResponse is the error document returned by the App Store Connect API
+type ErrorResponse struct {
+	StatusCode int     `json:"-"`
+	Errors     []Error `json:"errors"`
+}
+
+// Error is a single error of an ErrorResponse
+type Error struct {
+	Status string `json:"status"`
+	Code   string `json:"code"`
+	Title  string `json:"title"`
+	Detail string `json:"detail"`
+}
+
+func (r ErrorResponse) Error() string {
+	var messages []string
+	for _, err := range r.Errors {
+		messages = append(messages, fmt.Sprintf("%s - %s: %s", err.Code, err.Title, err.Detail))
+	}
+	if len(messages) == 0 {
+		return fmt.Sprintf("App Store Connect API request failed with status %d", r.StatusCode)
+	}
+	return fmt.Sprintf("App Store Connect API request failed with status %d: %s", r.StatusCode, strings.Join(messages, ", "))
+}
+
+// bearerToken returns a valid token, tokens are reused until shortly before they expire
+func (c *Client) bearerToken() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.token != "" && now.Add(time.Minute).Before(c.tokenExpiry) {
+		return c.token, nil
+	}
+
+	expiry := now.Add(TokenLifetime)
+	claims := Claims{
+		Issuer:   c.issuerID,
+		IssuedAt: now.Unix(),
+		Expiry:   expiry.Unix(),
+		Audience: Audience,
+	}
+	// Individual keys have no issuer, their tokens identify the user instead
+	if c.issuerID == "" {
+		claims.Subject = IndividualKeySubject
+	}
+	token, err := signToken(c.keyID, claims, c.privateKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign App Store Connect API token: %s", err)
+	}
+
+	c.token, c.tokenExpiry = token, expiry
+	return token, nil
+}
+
+func (c *Client) do(ctx context.Context, method, endpoint string, query url.Values, body, v interface{}) error {
+	u, err := c.BaseURL.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	if len(query) > 0 {
+		u.RawQuery = query.Encode()
+	}
+
+	var bodyReader io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		bodyReader = bytes.NewReader(content)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bodyReader)
+	if err != nil {
+		return err
+	}
+
+	token, err := c.bearerToken()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		errorResponse := ErrorResponse{StatusCode: resp.StatusCode}
+		_ = json.Unmarshal(content, &errorResponse)
+		return errorResponse
+	}
+
+	if v == nil || len(content) == 0 {
+		return nil
+	}
+	return json.Unmarshal(content, v)
+}
@@ -0,0 +1,224 @@
+package appstoreconnect_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/bitrise-steplib/steps-deploy-to-itunesconnect-deliver/appstoreconnect"
+	"github.com/bitrise-steplib/steps-deploy-to-itunesconnect-deliver/appstoreconnect/appstoreconnecttest"
+)
+
+func newTestServer(t *testing.T) *appstoreconnecttest.Server {
+	server, err := appstoreconnecttest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestClient(t *testing.T, server *appstoreconnecttest.Server) *appstoreconnect.Client {
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestClient_submitForReview(t *testing.T) {
+	server := newTestServer(t)
+	appID := server.AddApp("com.example.app", "Example")
+	server.AddApp("com.example.other", "Other")
+	server.AddBuild(appID, appstoreconnect.IOS, "1.0.0", "41", appstoreconnect.ProcessingStateValid)
+	server.AddBuild(appID, appstoreconnect.MacOS, "1.0.0", "42", appstoreconnect.ProcessingStateValid)
+	buildID := server.AddBuild(appID, appstoreconnect.IOS, "1.0.0", "42", appstoreconnect.ProcessingStateProcessing)
+	server.AddAppStoreVersion(appID, appstoreconnect.MacOS, "1.0.0", "PREPARE_FOR_SUBMISSION")
+	versionID := server.AddAppStoreVersion(appID, appstoreconnect.IOS, "1.0.0", "PREPARE_FOR_SUBMISSION")
+
+	client := newTestClient(t, server)
+
+	apps, err := client.ListApps("com.example.app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 1 || apps[0].ID != appID {
+		t.Fatalf("ListApps() = %v, want the app %s", apps, appID)
+	}
+
+	builds, err := client.ListBuilds(appID, appstoreconnect.IOS, "1.0.0", "42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(builds) != 1 || builds[0].ID != buildID || builds[0].Attributes.ProcessingState != appstoreconnect.ProcessingStateProcessing {
+		t.Errorf("ListBuilds() = %v, want the processing build %s", builds, buildID)
+	}
+
+	versions, err := client.ListAppStoreVersions(appID, appstoreconnect.IOS, "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0].ID != versionID {
+		t.Fatalf("ListAppStoreVersions() = %v, want the version %s", versions, versionID)
+	}
+
+	submission, err := client.CreateReviewSubmission(appID, appstoreconnect.IOS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.AddReviewSubmissionItem(submission.ID, versionID); err != nil {
+		t.Fatal(err)
+	}
+	submitted, err := client.SubmitReviewSubmission(submission.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if submitted.Attributes.State != appstoreconnect.ReviewSubmissionStateWaitingForReview {
+		t.Errorf("SubmitReviewSubmission() state = %s, want %s", submitted.Attributes.State, appstoreconnect.ReviewSubmissionStateWaitingForReview)
+	}
+
+	submissions := server.ReviewSubmissions()
+	if len(submissions) != 1 || !submissions[0].Attributes.Submitted || !reflect.DeepEqual(submissions[0].AppStoreVersionIDs, []string{versionID}) {
+		t.Errorf("ReviewSubmissions() = %+v, want one submitted submission of version %s", submissions, versionID)
+	}
+
+	wantRequests := []string{
+		"GET /v1/apps",
+		"GET /v1/builds",
+		"GET /v1/apps/" + appID + "/appStoreVersions",
+		"POST /v1/reviewSubmissions",
+		"POST /v1/reviewSubmissionItems",
+		"PATCH /v1/reviewSubmissions/" + submission.ID,
+	}
+	if got := server.Requests(); !reflect.DeepEqual(got, wantRequests) {
+		t.Errorf("Requests() = %v, want %v", got, wantRequests)
+	}
+}
+
+func TestClient_individualKey(t *testing.T) {
+	server := newTestServer(t)
+	server.IssuerID = ""
+	appID := server.AddApp("com.example.app", "Example")
+
+	apps, err := newTestClient(t, server).ListApps("com.example.app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 1 || apps[0].ID != appID {
+		t.Errorf("ListApps() = %v, want the app %s", apps, appID)
+	}
+
+	// A team key client signs tokens with an issuer, which the individual key is not accepted with
+	client, err := appstoreconnect.NewClient(server.Server.Client(), server.KeyID, "69a6de70-03db-47e3-e053-5b8c7c11a4d1", server.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.BaseURL, err = client.BaseURL.Parse(server.URL + "/"); err != nil {
+		t.Fatal(err)
+	}
+	var errorResponse appstoreconnect.ErrorResponse
+	if _, err := client.ListApps(""); !errors.As(err, &errorResponse) || errorResponse.StatusCode != http.StatusUnauthorized {
+		t.Errorf("ListApps() with an issuer error = %v, want status 401", err)
+	}
+}
+
+func TestClient_errors(t *testing.T) {
+	server := newTestServer(t)
+	appID := server.AddApp("com.example.app", "Example")
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPrivateKey := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	tests := []struct {
+		name       string
+		keyID      string
+		issuerID   string
+		privateKey []byte
+		request    func(client *appstoreconnect.Client) error
+		wantStatus int
+	}{
+		{
+			name:       "unknown key ID",
+			keyID:      "UNKNOWN123",
+			request:    func(client *appstoreconnect.Client) error { _, err := client.ListApps(""); return err },
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "invalid signature",
+			privateKey: otherPrivateKey,
+			request:    func(client *appstoreconnect.Client) error { _, err := client.ListApps(""); return err },
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "invalid issuer",
+			issuerID:   "00000000-0000-0000-0000-000000000000",
+			request:    func(client *appstoreconnect.Client) error { _, err := client.ListApps(""); return err },
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "unknown app",
+			request: func(client *appstoreconnect.Client) error {
+				_, err := client.ListAppStoreVersions("1", appstoreconnect.IOS, "")
+				return err
+			},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "submission without items",
+			request: func(client *appstoreconnect.Client) error {
+				submission, err := client.CreateReviewSubmission(appID, appstoreconnect.IOS)
+				if err != nil {
+					return err
+				}
+				_, err = client.SubmitReviewSubmission(submission.ID)
+				return err
+			},
+			wantStatus: http.StatusConflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyID, issuerID, privateKey := server.KeyID, server.IssuerID, server.PrivateKey
+			if tt.keyID != "" {
+				keyID = tt.keyID
+			}
+			if tt.issuerID != "" {
+				issuerID = tt.issuerID
+			}
+			if tt.privateKey != nil {
+				privateKey = tt.privateKey
+			}
+
+			client, err := appstoreconnect.NewClient(server.Server.Client(), keyID, issuerID, privateKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client.BaseURL, err = client.BaseURL.Parse(server.URL + "/")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = tt.request(client)
+			var errorResponse appstoreconnect.ErrorResponse
+			if !errors.As(err, &errorResponse) {
+				t.Fatalf("request error = %v, want an ErrorResponse", err)
+			}
+			if errorResponse.StatusCode != tt.wantStatus {
+				t.Errorf("request status = %d, want %d (%s)", errorResponse.StatusCode, tt.wantStatus, err)
+			}
+		})
+	}
+}
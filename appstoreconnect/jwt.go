@@ -0,0 +1,131 @@
+package appstoreconnect
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	// Audience is the JWT audience of App Store Connect API tokens
+	Audience = "appstoreconnect-v1"
+	// TokenLifetime is the lifetime of the generated tokens, App Store Connect rejects tokens valid for more than 20 minutes
+	TokenLifetime = 20 * time.Minute
+	// IndividualKeySubject is the subject of tokens signed with individual API keys, which have no issuer
+	IndividualKeySubject = "user"
+)
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Type      string `json:"typ"`
+}
+
+// Claims are the claims of an App Store Connect API token
+type Claims struct {
+	Issuer   string `json:"iss,omitempty"`
+	Subject  string `json:"sub,omitempty"`
+	IssuedAt int64  `json:"iat"`
+	Expiry   int64  `json:"exp"`
+	Audience string `json:"aud"`
+}
+
+// ParsePrivateKey parses the PEM encoded (.p8) private key of an App Store Connect API key
+func ParsePrivateKey(privateKey []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(privateKey)
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %s", err)
+	}
+
+	ecdsaKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an ECDSA key")
+	}
+	return ecdsaKey, nil
+}
+
+// signToken returns an ES256 signed JWT
+func signToken(keyID string, claims Claims, privateKey *ecdsa.PrivateKey) (string, error) {
+	header, err := json.Marshal(jwtHeader{Algorithm: "ES256", KeyID: keyID, Type: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, privateKey, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	// JWS ES256 signatures are the fixed size big endian R and S values
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// VerifyToken checks the ES256 signature of a JWT, and returns its key ID and claims
+func VerifyToken(token string, publicKey func(keyID string) crypto.PublicKey) (string, Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", Claims{}, errors.New("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", Claims{}, fmt.Errorf("malformed token header: %s", err)
+	}
+	if header.Algorithm != "ES256" {
+		return "", Claims{}, fmt.Errorf("unsupported signing algorithm: %s", header.Algorithm)
+	}
+
+	key, ok := publicKey(header.KeyID).(*ecdsa.PublicKey)
+	if !ok || key == nil {
+		return "", Claims{}, fmt.Errorf("unknown key ID: %s", header.KeyID)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(signature) != 64 {
+		return "", Claims{}, errors.New("malformed token signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(key, digest[:], r, s) {
+		return "", Claims{}, errors.New("invalid token signature")
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", Claims{}, fmt.Errorf("malformed token payload: %s", err)
+	}
+	return header.KeyID, claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	content, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, v)
+}
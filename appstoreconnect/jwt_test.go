@@ -0,0 +1,73 @@
+package appstoreconnect
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"strings"
+	"testing"
+)
+
+func TestVerifyToken(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := Claims{Issuer: "issuer", IssuedAt: 1700000000, Expiry: 1700001200, Audience: Audience}
+
+	token, err := signToken("KEY1234567", claims, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken, err := signToken("KEY1234567", claims, otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	longerClaims := claims
+	longerClaims.Expiry += 3600
+	longerToken, err := signToken("KEY1234567", longerClaims, otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+	longerParts := strings.Split(longerToken, ".")
+
+	publicKey := func(keyID string) crypto.PublicKey {
+		if keyID != "KEY1234567" {
+			return nil
+		}
+		return &key.PublicKey
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{name: "valid token", token: token},
+		{name: "malformed token", token: "abc.def", wantErr: true},
+		{name: "signed by an other key", token: otherToken, wantErr: true},
+		{name: "tampered payload", token: parts[0] + "." + longerParts[1] + "." + parts[2], wantErr: true},
+		{name: "unknown key ID", token: strings.Replace(token, parts[0], "eyJhbGciOiJFUzI1NiIsImtpZCI6Ik9USEVSIiwidHlwIjoiSldUIn0", 1), wantErr: true},
+		{name: "unsupported algorithm", token: "eyJhbGciOiJub25lIiwia2lkIjoiS0VZMTIzNDU2NyIsInR5cCI6IkpXVCJ9." + parts[1] + ".", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyID, got, err := VerifyToken(tt.token, publicKey)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if keyID != "KEY1234567" || got != claims {
+				t.Errorf("VerifyToken() = %s, %+v, want KEY1234567, %+v", keyID, got, claims)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package appstoreconnect
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// Platform is an App Store Connect platform
+type Platform string
+
+// Platforms
+const (
+	IOS   Platform = "IOS"
+	MacOS Platform = "MAC_OS"
+	TVOS  Platform = "TV_OS"
+)
+
+// App is an App Store Connect app
+type App struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	Attributes struct {
+		BundleID string `json:"bundleId"`
+		Name     string `json:"name"`
+		SKU      string `json:"sku"`
+	} `json:"attributes"`
+}
+
+// Build is an uploaded build of an app
+type Build struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	Attributes struct {
+		Version         string `json:"version"`
+		ProcessingState string `json:"processingState"`
+		UploadedDate    string `json:"uploadedDate"`
+	} `json:"attributes"`
+}
+
+// Build processing states
+const (
+	ProcessingStateProcessing = "PROCESSING"
+	ProcessingStateFailed     = "FAILED"
+	ProcessingStateInvalid    = "INVALID"
+	ProcessingStateValid      = "VALID"
+)
+
+// AppStoreVersion is a version of an app on the App Store
+type AppStoreVersion struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	Attributes struct {
+		Platform      Platform `json:"platform"`
+		VersionString string   `json:"versionString"`
+		AppStoreState string   `json:"appStoreState"`
+	} `json:"attributes"`
+}
+
+// ReviewSubmission is an App Review submission of an app
+type ReviewSubmission struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	Attributes struct {
+		Platform  Platform `json:"platform"`
+		State     string   `json:"state"`
+		Submitted bool     `json:"submitted,omitempty"`
+	} `json:"attributes"`
+}
+
+// Review submission states
+const (
+	ReviewSubmissionStateReadyForReview   = "READY_FOR_REVIEW"
+	ReviewSubmissionStateWaitingForReview = "WAITING_FOR_REVIEW"
+)
+
+// relationship is a to-one relationship of a request document
+type relationship struct {
+	Data resourceIdentifier `json:"data"`
+}
+
+type resourceIdentifier struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// ListApps returns the apps with the bundle ID
+func (c *Client) ListApps(bundleID string) ([]App, error) {
+	query := url.Values{}
+	if bundleID != "" {
+		query.Set("filter[bundleId]", bundleID)
+	}
+
+	var response struct {
+		Data []App `json:"data"`
+	}
+	if err := c.do(context.Background(), "GET", "v1/apps", query, nil, &response); err != nil {
+		return nil, fmt.Errorf("failed to list apps: %w", err)
+	}
+	return response.Data, nil
+}
+
+// ListBuilds returns the builds of the app, optionally filtered by the platform, the version (CFBundleShortVersionString)
+// and the build number (CFBundleVersion)
+func (c *Client) ListBuilds(appID string, platform Platform, version, buildNumber string) ([]Build, error) {
+	query := url.Values{}
+	query.Set("filter[app]", appID)
+	if platform != "" {
+		query.Set("filter[preReleaseVersion.platform]", string(platform))
+	}
+	if version != "" {
+		query.Set("filter[preReleaseVersion.version]", version)
+	}
+	if buildNumber != "" {
+		query.Set("filter[version]", buildNumber)
+	}
+
+	var response struct {
+		Data []Build `json:"data"`
+	}
+	if err := c.do(context.Background(), "GET", "v1/builds", query, nil, &response); err != nil {
+		return nil, fmt.Errorf("failed to list builds: %w", err)
+	}
+	return response.Data, nil
+}
+
+// ListAppStoreVersions returns the App Store versions of the app, optionally filtered by platform and version string
+func (c *Client) ListAppStoreVersions(appID string, platform Platform, versionString string) ([]AppStoreVersion, error) {
+	query := url.Values{}
+	if platform != "" {
+		query.Set("filter[platform]", string(platform))
+	}
+	if versionString != "" {
+		query.Set("filter[versionString]", versionString)
+	}
+
+	var response struct {
+		Data []AppStoreVersion `json:"data"`
+	}
+	if err := c.do(context.Background(), "GET", "v1/apps/"+url.PathEscape(appID)+"/appStoreVersions", query, nil, &response); err != nil {
+		return nil, fmt.Errorf("failed to list App Store versions: %w", err)
+	}
+	return response.Data, nil
+}
+
+// CreateReviewSubmission creates a review submission for the app
+func (c *Client) CreateReviewSubmission(appID string, platform Platform) (ReviewSubmission, error) {
+	type attributes struct {
+		Platform Platform `json:"platform"`
+	}
+	type relationships struct {
+		App relationship `json:"app"`
+	}
+	var request struct {
+		Data struct {
+			Type          string        `json:"type"`
+			Attributes    attributes    `json:"attributes"`
+			Relationships relationships `json:"relationships"`
+		} `json:"data"`
+	}
+	request.Data.Type = "reviewSubmissions"
+	request.Data.Attributes.Platform = platform
+	request.Data.Relationships.App = relationship{Data: resourceIdentifier{Type: "apps", ID: appID}}
+
+	var response struct {
+		Data ReviewSubmission `json:"data"`
+	}
+	if err := c.do(context.Background(), "POST", "v1/reviewSubmissions", nil, request, &response); err != nil {
+		return ReviewSubmission{}, fmt.Errorf("failed to create review submission: %w", err)
+	}
+	return response.Data, nil
+}
+
+// AddReviewSubmissionItem adds the App Store version to the review submission
+func (c *Client) AddReviewSubmissionItem(submissionID, appStoreVersionID string) error {
+	type relationships struct {
+		ReviewSubmission relationship `json:"reviewSubmission"`
+		AppStoreVersion  relationship `json:"appStoreVersion"`
+	}
+	var request struct {
+		Data struct {
+			Type          string        `json:"type"`
+			Relationships relationships `json:"relationships"`
+		} `json:"data"`
+	}
+	request.Data.Type = "reviewSubmissionItems"
+	request.Data.Relationships.ReviewSubmission = relationship{Data: resourceIdentifier{Type: "reviewSubmissions", ID: submissionID}}
+	request.Data.Relationships.AppStoreVersion = relationship{Data: resourceIdentifier{Type: "appStoreVersions", ID: appStoreVersionID}}
+
+	if err := c.do(context.Background(), "POST", "v1/reviewSubmissionItems", nil, request, nil); err != nil {
+		return fmt.Errorf("failed to add App Store version to review submission: %w", err)
+	}
+	return nil
+}
+
+// SubmitReviewSubmission submits the review submission to App Review
+func (c *Client) SubmitReviewSubmission(submissionID string) (ReviewSubmission, error) {
+	type attributes struct {
+		Submitted bool `json:"submitted"`
+	}
+	var request struct {
+		Data struct {
+			Type       string     `json:"type"`
+			ID         string     `json:"id"`
+			Attributes attributes `json:"attributes"`
+		} `json:"data"`
+	}
+	request.Data.Type = "reviewSubmissions"
+	request.Data.ID = submissionID
+	request.Data.Attributes.Submitted = true
+
+	var response struct {
+		Data ReviewSubmission `json:"data"`
+	}
+	if err := c.do(context.Background(), "PATCH", "v1/reviewSubmissions/"+url.PathEscape(submissionID), nil, request, &response); err != nil {
+		return ReviewSubmission{}, fmt.Errorf("failed to submit review submission: %w", err)
+	}
+	return response.Data, nil
+}
@@ -0,0 +1,440 @@
+package appstoreconnect
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Uniform Type Identifiers of the uploadable build files
+const (
+	UTIIPA = "com.apple.ipa"
+	UTIPKG = "com.apple.pkg"
+)
+
+// BuildUpload is an upload of a build binary
+type BuildUpload struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	Attributes struct {
+		CFBundleShortVersionString string           `json:"cfBundleShortVersionString"`
+		CFBundleVersion            string           `json:"cfBundleVersion"`
+		Platform                   Platform         `json:"platform"`
+		State                      BuildUploadState `json:"state"`
+	} `json:"attributes"`
+}
+
+// BuildUploadState is the processing state of a build upload, with the problems found in the binary
+type BuildUploadState struct {
+	State    string             `json:"state"`
+	Errors   []BuildUploadIssue `json:"errors,omitempty"`
+	Warnings []BuildUploadIssue `json:"warnings,omitempty"`
+}
+
+// BuildUploadIssue is an error or a warning of a build upload
+type BuildUploadIssue struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+// Build upload states
+const (
+	BuildUploadStateAwaitingUpload = "AWAITING_UPLOAD"
+	BuildUploadStateProcessing     = "PROCESSING"
+	BuildUploadStateFailed         = "FAILED"
+	BuildUploadStateComplete       = "COMPLETE"
+)
+
+// BuildUploadFile is a file of a build upload, with the operations uploading its parts
+type BuildUploadFile struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	Attributes struct {
+		FileName         string            `json:"fileName"`
+		FileSize         int64             `json:"fileSize"`
+		UTI              string            `json:"uti"`
+		UploadOperations []UploadOperation `json:"uploadOperations"`
+		Uploaded         bool              `json:"uploaded"`
+	} `json:"attributes"`
+}
+
+// UploadOperation is the request uploading a part of a file
+type UploadOperation struct {
+	Method         string       `json:"method"`
+	URL            string       `json:"url"`
+	Offset         int64        `json:"offset"`
+	Length         int64        `json:"length"`
+	RequestHeaders []HTTPHeader `json:"requestHeaders"`
+}
+
+// HTTPHeader is a header of an UploadOperation request
+type HTTPHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// CreateBuildUpload reserves an upload for the build with the version (CFBundleShortVersionString)
+// and build number (CFBundleVersion) of the app
+func (c *Client) CreateBuildUpload(ctx context.Context, appID, version, buildNumber string, platform Platform) (BuildUpload, error) {
+	type attributes struct {
+		CFBundleShortVersionString string   `json:"cfBundleShortVersionString"`
+		CFBundleVersion            string   `json:"cfBundleVersion"`
+		Platform                   Platform `json:"platform"`
+	}
+	type relationships struct {
+		App relationship `json:"app"`
+	}
+	var request struct {
+		Data struct {
+			Type          string        `json:"type"`
+			Attributes    attributes    `json:"attributes"`
+			Relationships relationships `json:"relationships"`
+		} `json:"data"`
+	}
+	request.Data.Type = "buildUploads"
+	request.Data.Attributes = attributes{CFBundleShortVersionString: version, CFBundleVersion: buildNumber, Platform: platform}
+	request.Data.Relationships.App = relationship{Data: resourceIdentifier{Type: "apps", ID: appID}}
+
+	var response struct {
+		Data BuildUpload `json:"data"`
+	}
+	if err := c.do(ctx, "POST", "v1/buildUploads", nil, request, &response); err != nil {
+		return BuildUpload{}, fmt.Errorf("failed to create build upload: %w", err)
+	}
+	return response.Data, nil
+}
+
+// GetBuildUpload returns the build upload
+func (c *Client) GetBuildUpload(ctx context.Context, id string) (BuildUpload, error) {
+	var response struct {
+		Data BuildUpload `json:"data"`
+	}
+	if err := c.do(ctx, "GET", "v1/buildUploads/"+url.PathEscape(id), nil, nil, &response); err != nil {
+		return BuildUpload{}, fmt.Errorf("failed to get build upload: %w", err)
+	}
+	return response.Data, nil
+}
+
+// CreateBuildUploadFile reserves the file of the build upload, the returned file has the operations uploading its parts
+func (c *Client) CreateBuildUploadFile(ctx context.Context, buildUploadID, fileName string, fileSize int64, uti string) (BuildUploadFile, error) {
+	type attributes struct {
+		AssetType string `json:"assetType"`
+		FileName  string `json:"fileName"`
+		FileSize  int64  `json:"fileSize"`
+		UTI       string `json:"uti"`
+	}
+	type relationships struct {
+		BuildUpload relationship `json:"buildUpload"`
+	}
+	var request struct {
+		Data struct {
+			Type          string        `json:"type"`
+			Attributes    attributes    `json:"attributes"`
+			Relationships relationships `json:"relationships"`
+		} `json:"data"`
+	}
+	request.Data.Type = "buildUploadFiles"
+	request.Data.Attributes = attributes{AssetType: "ASSET", FileName: fileName, FileSize: fileSize, UTI: uti}
+	request.Data.Relationships.BuildUpload = relationship{Data: resourceIdentifier{Type: "buildUploads", ID: buildUploadID}}
+
+	var response struct {
+		Data BuildUploadFile `json:"data"`
+	}
+	if err := c.do(ctx, "POST", "v1/buildUploadFiles", nil, request, &response); err != nil {
+		return BuildUploadFile{}, fmt.Errorf("failed to create build upload file: %w", err)
+	}
+	return response.Data, nil
+}
+
+// CommitBuildUploadFile marks the file uploaded, App Store Connect verifies the file against its MD5 checksum (hex encoded)
+func (c *Client) CommitBuildUploadFile(ctx context.Context, id, md5Checksum string) (BuildUploadFile, error) {
+	type checksum struct {
+		Hash      string `json:"hash"`
+		Algorithm string `json:"algorithm"`
+	}
+	type checksums struct {
+		File checksum `json:"file"`
+	}
+	type attributes struct {
+		SourceFileChecksums checksums `json:"sourceFileChecksums"`
+		Uploaded            bool      `json:"uploaded"`
+	}
+	var request struct {
+		Data struct {
+			Type       string     `json:"type"`
+			ID         string     `json:"id"`
+			Attributes attributes `json:"attributes"`
+		} `json:"data"`
+	}
+	request.Data.Type = "buildUploadFiles"
+	request.Data.ID = id
+	request.Data.Attributes = attributes{SourceFileChecksums: checksums{File: checksum{Hash: md5Checksum, Algorithm: "MD5"}}, Uploaded: true}
+
+	var response struct {
+		Data BuildUploadFile `json:"data"`
+	}
+	if err := c.do(ctx, "PATCH", "v1/buildUploadFiles/"+url.PathEscape(id), nil, request, &response); err != nil {
+		return BuildUploadFile{}, fmt.Errorf("failed to commit build upload file: %w", err)
+	}
+	return response.Data, nil
+}
+
+// BuildUploadOptions configures Client.UploadBuild, the zero value uses the defaults
+type BuildUploadOptions struct {
+	// Concurrency is the number of parts uploaded in parallel, 4 by default
+	Concurrency int
+	// Retries is the number of times a failed part upload is retried, 3 by default
+	Retries int
+	// RetryWait is the wait before retrying a part upload, 5 seconds by default
+	RetryWait time.Duration
+	// PollInterval is the interval of checking the build upload state after the upload, 10 seconds by default
+	PollInterval time.Duration
+	// Progress is called after each uploaded part, with the uploaded and the total bytes
+	Progress func(uploaded, total int64)
+}
+
+func (o BuildUploadOptions) withDefaults() BuildUploadOptions {
+	if o.Concurrency <= 0 {
+		o.Concurrency = 4
+	}
+	if o.Retries < 0 {
+		o.Retries = 0
+	} else if o.Retries == 0 {
+		o.Retries = 3
+	}
+	if o.RetryWait <= 0 {
+		o.RetryWait = 5 * time.Second
+	}
+	if o.PollInterval <= 0 {
+		o.PollInterval = 10 * time.Second
+	}
+	return o
+}
+
+// BuildUploadError is returned when App Store Connect rejects the uploaded build
+type BuildUploadError struct {
+	Upload BuildUpload
+}
+
+func (e BuildUploadError) Error() string {
+	var issues []string
+	for _, issue := range e.Upload.Attributes.State.Errors {
+		issues = append(issues, fmt.Sprintf("%s: %s", issue.Code, issue.Description))
+	}
+	if len(issues) == 0 {
+		return fmt.Sprintf("build upload %s failed", e.Upload.ID)
+	}
+	return fmt.Sprintf("build upload %s failed: %s", e.Upload.ID, strings.Join(issues, ", "))
+}
+
+// UploadBuild uploads the IPA or PKG file as the build of the app, and waits until App Store Connect accepts it:
+// it reserves the upload, uploads the parts dictated by App Store Connect in parallel, commits the file with its
+// checksum and waits for the upload processing to complete.
+// The context cancels all of its requests and the wait.
+func (c *Client) UploadBuild(ctx context.Context, appID, version, buildNumber string, platform Platform, pth string, opts BuildUploadOptions) (BuildUpload, error) {
+	opts = opts.withDefaults()
+
+	f, err := os.Open(pth)
+	if err != nil {
+		return BuildUpload{}, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	info, err := f.Stat()
+	if err != nil {
+		return BuildUpload{}, err
+	}
+
+	checksum, err := fileMD5(f, info.Size())
+	if err != nil {
+		return BuildUpload{}, fmt.Errorf("failed to calculate the checksum of %s: %w", pth, err)
+	}
+
+	uti := UTIIPA
+	if strings.EqualFold(filepath.Ext(pth), ".pkg") {
+		uti = UTIPKG
+	}
+
+	upload, err := c.CreateBuildUpload(ctx, appID, version, buildNumber, platform)
+	if err != nil {
+		return BuildUpload{}, err
+	}
+
+	file, err := c.CreateBuildUploadFile(ctx, upload.ID, filepath.Base(pth), info.Size(), uti)
+	if err != nil {
+		return upload, err
+	}
+
+	if err := c.uploadParts(ctx, file.Attributes.UploadOperations, f, info.Size(), opts); err != nil {
+		return upload, err
+	}
+
+	if _, err := c.CommitBuildUploadFile(ctx, file.ID, checksum); err != nil {
+		return upload, err
+	}
+
+	return c.waitForBuildUpload(ctx, upload.ID, opts.PollInterval)
+}
+
+// uploadParts uploads the parts of the file in parallel, after checking that the operations cover the whole file
+func (c *Client) uploadParts(ctx context.Context, operations []UploadOperation, f io.ReaderAt, size int64, opts BuildUploadOptions) error {
+	operations = append([]UploadOperation(nil), operations...)
+	sort.Slice(operations, func(i, j int) bool { return operations[i].Offset < operations[j].Offset })
+
+	var offset int64
+	for _, operation := range operations {
+		if operation.Offset != offset || operation.Length <= 0 {
+			return fmt.Errorf("invalid upload operations: part at offset %d with length %d, expected offset %d", operation.Offset, operation.Length, offset)
+		}
+		offset += operation.Length
+	}
+	if offset != size {
+		return fmt.Errorf("invalid upload operations: the parts cover %d bytes of the %d bytes file", offset, size)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		uploaded int64
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	parts := make(chan UploadOperation)
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for operation := range parts {
+				err := c.uploadPartWithRetry(ctx, operation, f, opts)
+
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				if err == nil {
+					uploaded += operation.Length
+					if opts.Progress != nil {
+						opts.Progress(uploaded, size)
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, operation := range operations {
+		select {
+		case parts <- operation:
+		case <-ctx.Done():
+		}
+	}
+	close(parts)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+func (c *Client) uploadPartWithRetry(ctx context.Context, operation UploadOperation, f io.ReaderAt, opts BuildUploadOptions) error {
+	var err error
+	for attempt := 0; attempt <= opts.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(opts.RetryWait):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if err = c.uploadPart(ctx, operation, f); err == nil || ctx.Err() != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("failed to upload part at offset %d after %d attempts: %w", operation.Offset, opts.Retries+1, err)
+}
+
+// uploadPart sends a part with its MD5 checksum, so that a part corrupted in transit is rejected
+func (c *Client) uploadPart(ctx context.Context, operation UploadOperation, f io.ReaderAt) error {
+	part := io.NewSectionReader(f, operation.Offset, operation.Length)
+	hash := md5.New()
+	if _, err := io.Copy(hash, part); err != nil {
+		return err
+	}
+	if _, err := part.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, operation.Method, operation.URL, part)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = operation.Length
+	for _, header := range operation.RequestHeaders {
+		req.Header.Set(header.Name, header.Value)
+	}
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(hash.Sum(nil)))
+
+	// upload operation URLs are pre-signed, the API token is not sent
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("part upload failed with status %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
+
+// waitForBuildUpload polls the build upload until it is complete or failed
+func (c *Client) waitForBuildUpload(ctx context.Context, id string, interval time.Duration) (BuildUpload, error) {
+	for {
+		upload, err := c.GetBuildUpload(ctx, id)
+		if err != nil {
+			return BuildUpload{}, err
+		}
+
+		switch upload.Attributes.State.State {
+		case BuildUploadStateComplete:
+			return upload, nil
+		case BuildUploadStateFailed:
+			return upload, BuildUploadError{Upload: upload}
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return upload, fmt.Errorf("build upload %s is still %s: %w", id, upload.Attributes.State.State, ctx.Err())
+		}
+	}
+}
+
+func fileMD5(f io.ReaderAt, size int64) (string, error) {
+	hash := md5.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(f, 0, size)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,189 @@
+package appstoreconnect_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-steplib/steps-deploy-to-itunesconnect-deliver/appstoreconnect"
+)
+
+func writeTestBuild(t *testing.T, name string, size int) (string, []byte) {
+	content := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(content)
+
+	pth := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(pth, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return pth, content
+}
+
+func TestClient_UploadBuild(t *testing.T) {
+	tests := []struct {
+		name            string
+		fileName        string
+		size            int
+		partSize        int64
+		failPartUploads int
+		wantPlatform    appstoreconnect.Platform
+		wantUTI         string
+	}{
+		{
+			name:         "single part",
+			fileName:     "app.ipa",
+			size:         1000,
+			partSize:     4096,
+			wantPlatform: appstoreconnect.IOS,
+			wantUTI:      appstoreconnect.UTIIPA,
+		},
+		{
+			name:         "parts uploaded in parallel",
+			fileName:     "app.ipa",
+			size:         10*1024 + 17,
+			partSize:     1024,
+			wantPlatform: appstoreconnect.IOS,
+			wantUTI:      appstoreconnect.UTIIPA,
+		},
+		{
+			name:            "failed parts are retried",
+			fileName:        "app.pkg",
+			size:            5000,
+			partSize:        1000,
+			failPartUploads: 3,
+			wantPlatform:    appstoreconnect.MacOS,
+			wantUTI:         appstoreconnect.UTIPKG,
+		},
+		{
+			name:         "upper case extension",
+			fileName:     "App.PKG",
+			size:         1000,
+			partSize:     4096,
+			wantPlatform: appstoreconnect.MacOS,
+			wantUTI:      appstoreconnect.UTIPKG,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t)
+			server.PartSize = tt.partSize
+			server.FailPartUploads = tt.failPartUploads
+			appID := server.AddApp("com.example.app", "Example")
+			client := newTestClient(t, server)
+
+			pth, content := writeTestBuild(t, tt.fileName, tt.size)
+
+			var lastUploaded, lastTotal int64
+			upload, err := client.UploadBuild(context.Background(), appID, "1.0.0", "42", tt.wantPlatform, pth, appstoreconnect.BuildUploadOptions{
+				RetryWait:    time.Millisecond,
+				PollInterval: time.Millisecond,
+				Progress: func(uploaded, total int64) {
+					lastUploaded, lastTotal = uploaded, total
+				},
+			})
+			if err != nil {
+				t.Fatalf("UploadBuild() error = %v", err)
+			}
+			if upload.Attributes.State.State != appstoreconnect.BuildUploadStateComplete {
+				t.Errorf("UploadBuild() state = %s, want %s", upload.Attributes.State.State, appstoreconnect.BuildUploadStateComplete)
+			}
+			if lastUploaded != int64(tt.size) || lastTotal != int64(tt.size) {
+				t.Errorf("last progress = %d of %d, want %d of %d", lastUploaded, lastTotal, tt.size, tt.size)
+			}
+
+			uploaded := server.UploadedBuilds()
+			if len(uploaded) != 1 {
+				t.Fatalf("uploaded builds = %d, want 1", len(uploaded))
+			}
+			got := uploaded[0]
+			if got.AppID != appID || got.Version != "1.0.0" || got.BuildNumber != "42" || got.Platform != tt.wantPlatform || got.FileName != tt.fileName {
+				t.Errorf("uploaded build = %s %s (%s) %s of app %s, want 1.0.0 42 (%s) %s of app %s", got.Version, got.BuildNumber, got.Platform, got.FileName, got.AppID, tt.wantPlatform, tt.fileName, appID)
+			}
+			if got.UTI != tt.wantUTI {
+				t.Errorf("uploaded build UTI = %s, want %s", got.UTI, tt.wantUTI)
+			}
+			if !bytes.Equal(got.Content, content) {
+				t.Errorf("uploaded content differs from the file")
+			}
+
+			builds, err := client.ListBuilds(appID, tt.wantPlatform, "1.0.0", "42")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(builds) != 1 {
+				t.Errorf("ListBuilds() = %v, want the uploaded build", builds)
+			}
+		})
+	}
+}
+
+func TestClient_UploadBuild_errors(t *testing.T) {
+	t.Run("duplicate build", func(t *testing.T) {
+		server := newTestServer(t)
+		appID := server.AddApp("com.example.app", "Example")
+		server.AddBuild(appID, appstoreconnect.IOS, "1.0.0", "42", appstoreconnect.ProcessingStateValid)
+		client := newTestClient(t, server)
+		pth, _ := writeTestBuild(t, "app.ipa", 100)
+
+		_, err := client.UploadBuild(context.Background(), appID, "1.0.0", "42", appstoreconnect.IOS, pth, appstoreconnect.BuildUploadOptions{})
+		var errorResponse appstoreconnect.ErrorResponse
+		if !errors.As(err, &errorResponse) || errorResponse.StatusCode != http.StatusConflict {
+			t.Errorf("UploadBuild() error = %v, want a conflict", err)
+		}
+	})
+
+	t.Run("part upload keeps failing", func(t *testing.T) {
+		server := newTestServer(t)
+		server.PartSize = 100
+		server.FailPartUploads = 100
+		appID := server.AddApp("com.example.app", "Example")
+		client := newTestClient(t, server)
+		pth, _ := writeTestBuild(t, "app.ipa", 300)
+
+		_, err := client.UploadBuild(context.Background(), appID, "1.0.0", "42", appstoreconnect.IOS, pth, appstoreconnect.BuildUploadOptions{Retries: 2, RetryWait: time.Millisecond})
+		if err == nil {
+			t.Fatalf("UploadBuild() error = nil, want the part upload error")
+		}
+		if len(server.UploadedBuilds()) != 0 {
+			t.Errorf("uploaded builds = %v, want none", server.UploadedBuilds())
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		server := newTestServer(t)
+		server.FailPartUploads = 100
+		appID := server.AddApp("com.example.app", "Example")
+		client := newTestClient(t, server)
+		pth, _ := writeTestBuild(t, "app.ipa", 100)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := client.UploadBuild(ctx, appID, "1.0.0", "42", appstoreconnect.IOS, pth, appstoreconnect.BuildUploadOptions{RetryWait: time.Hour})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("UploadBuild() error = %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("cancelled before the upload", func(t *testing.T) {
+		server := newTestServer(t)
+		appID := server.AddApp("com.example.app", "Example")
+		client := newTestClient(t, server)
+		pth, _ := writeTestBuild(t, "app.ipa", 100)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := client.UploadBuild(ctx, appID, "1.0.0", "42", appstoreconnect.IOS, pth, appstoreconnect.BuildUploadOptions{})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("UploadBuild() error = %v, want %v", err, context.Canceled)
+		}
+		if requests := server.Requests(); len(requests) != 0 {
+			t.Errorf("requests = %q, want none", requests)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// Characters iTMSTransporter and altool are known to handle in artifact paths,
+// anything else (spaces, quotes, non-ASCII characters, ...) is worked around by staging the artifact.
+var safeArtifactPathRegexp = regexp.MustCompile(`^[A-Za-z0-9_./+-]+$`)
+
+var unsafeArtifactNameCharsRegexp = regexp.MustCompile(`[^A-Za-z0-9_.+-]+`)
+
+// stageArtifact returns a path to the artifact that is safe to pass to deliver.
+// If the artifact path contains no problematic characters it is used as is,
+// otherwise the artifact is made available in tmpDir under a sanitised version of its original name:
+// hardlinked if possible, symlinked as a fallback and copied only if neither works.
+func stageArtifact(pth, tmpDir string) (string, error) {
+	absPth, err := filepath.Abs(pth)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(absPth); err != nil {
+		return "", err
+	}
+
+	if safeArtifactPathRegexp.MatchString(absPth) {
+		log.Debugf("Artifact path (%s) needs no sanitising", absPth)
+		return absPth, nil
+	}
+
+	stagedPth := filepath.Join(tmpDir, sanitizedArtifactName(absPth))
+
+	err = os.Link(absPth, stagedPth)
+	if err == nil {
+		log.Debugf("Artifact hardlinked to: %s", stagedPth)
+		return stagedPth, nil
+	}
+	log.Debugf("Failed to hardlink artifact: %s", err)
+
+	err = os.Symlink(absPth, stagedPth)
+	if err == nil {
+		log.Debugf("Artifact symlinked to: %s", stagedPth)
+		return stagedPth, nil
+	}
+	log.Debugf("Failed to symlink artifact: %s", err)
+
+	if err := command.CopyFile(absPth, stagedPth); err != nil {
+		return "", fmt.Errorf("failed to copy artifact: %s", err)
+	}
+	log.Debugf("Artifact copied to: %s", stagedPth)
+
+	return stagedPth, nil
+}
+
+// sanitizedArtifactName keeps the original file name (which shows up in App Store Connect logs)
+// with the problematic characters replaced.
+func sanitizedArtifactName(pth string) string {
+	ext := filepath.Ext(pth)
+	base := strings.TrimSuffix(filepath.Base(pth), ext)
+
+	base = strings.Trim(unsafeArtifactNameCharsRegexp.ReplaceAllString(base, "_"), "_")
+	if base == "" {
+		base = "artifact"
+	}
+
+	return base + ext
+}
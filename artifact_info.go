@@ -0,0 +1,315 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-xcode/plistutil"
+)
+
+const maxPlistSize = 10 * 1024 * 1024
+
+// artifactInfo is the app bundle information read from an IPA or PKG
+type artifactInfo struct {
+	BundleID    string `json:"bundle_id"`
+	Version     string `json:"version"`
+	BuildNumber string `json:"build_number"`
+}
+
+// readArtifactInfo reads the bundle ID, version and build number of the app packaged in an IPA or PKG
+func readArtifactInfo(pth string) (artifactInfo, error) {
+	if strings.EqualFold(filepath.Ext(pth), ".pkg") {
+		return readPkgInfo(pth)
+	}
+
+	r, err := zip.OpenReader(pth)
+	if err != nil {
+		return artifactInfo{}, err
+	}
+	defer closeZip(r)
+
+	infoPlist, _, err := ipaInfoPlist(&r.Reader)
+	if err != nil {
+		return artifactInfo{}, err
+	}
+
+	var info artifactInfo
+	info.BundleID, _ = infoPlist.GetString("CFBundleIdentifier")
+	info.Version, _ = infoPlist.GetString("CFBundleShortVersionString")
+	info.BuildNumber, _ = infoPlist.GetString("CFBundleVersion")
+	return info, nil
+}
+
+// readArtifactSignature returns the signing certificate of an IPA (the app executable's code signature)
+// or a PKG (the installer package signature)
+func readArtifactSignature(pth string) (signatureInfo, error) {
+	var cert *x509.Certificate
+	var err error
+	if strings.EqualFold(filepath.Ext(pth), ".pkg") {
+		cert, err = pkgSigningCertificate(pth)
+	} else {
+		cert, err = ipaSigningCertificate(pth)
+	}
+	if err != nil {
+		return signatureInfo{}, err
+	}
+
+	return newSignatureInfo(cert), nil
+}
+
+func closeZip(r *zip.ReadCloser) {
+	if err := r.Close(); err != nil {
+		log.Warnf("Failed to close archive: %s", err)
+	}
+}
+
+// ipaInfoPlist returns the main app's Info.plist (Payload/*.app/Info.plist) and the app's path
+func ipaInfoPlist(r *zip.Reader) (plistutil.PlistData, string, error) {
+	for _, f := range r.File {
+		components := strings.Split(f.Name, "/")
+		if len(components) != 3 || components[0] != "Payload" || !strings.HasSuffix(components[1], ".app") || components[2] != "Info.plist" {
+			continue
+		}
+
+		content, err := readZipFile(f, maxPlistSize)
+		if err != nil {
+			return nil, "", err
+		}
+
+		infoPlist, err := plistutil.NewPlistDataFromContent(string(content))
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to parse %s: %s", f.Name, err)
+		}
+		return infoPlist, path.Dir(f.Name), nil
+	}
+
+	return nil, "", errors.New("no Payload/*.app/Info.plist found in the IPA")
+}
+
+func ipaSigningCertificate(pth string) (*x509.Certificate, error) {
+	archive, err := os.Open(pth)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := archive.Close(); err != nil {
+			log.Warnf("Failed to close %s: %s", pth, err)
+		}
+	}()
+
+	info, err := archive.Stat()
+	if err != nil {
+		return nil, err
+	}
+	r, err := zip.NewReader(archive, info.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	infoPlist, appPth, err := ipaInfoPlist(r)
+	if err != nil {
+		return nil, err
+	}
+
+	executableName, _ := infoPlist.GetString("CFBundleExecutable")
+	if executableName == "" {
+		executableName = strings.TrimSuffix(path.Base(appPth), ".app")
+	}
+
+	executablePth := path.Join(appPth, executableName)
+	for _, f := range r.File {
+		if f.Name != executablePth {
+			continue
+		}
+
+		executable, cleanup, err := zipFileReaderAt(archive, f)
+		if err != nil {
+			return nil, err
+		}
+		defer cleanup()
+		return machoSigningCertificate(executable)
+	}
+
+	return nil, fmt.Errorf("app executable (%s) not found in the IPA", executablePth)
+}
+
+// zipFileReaderAt returns a random access reader of the zip entry, which debug/macho requires.
+// Stored entries are read in place, compressed ones are extracted to a temporary file instead of into memory.
+func zipFileReaderAt(archive *os.File, f *zip.File) (io.ReaderAt, func(), error) {
+	if f.Method == zip.Store {
+		offset, err := f.DataOffset()
+		if err != nil {
+			return nil, nil, err
+		}
+		return io.NewSectionReader(archive, offset, int64(f.UncompressedSize64)), func() {}, nil
+	}
+
+	tmpDir, err := tempFiles.MkdirTemp("executable")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		if err := tempFiles.Remove(tmpDir); err != nil {
+			log.Warnf("Failed to remove temporary directory (%s): %s", tmpDir, err)
+		}
+	}
+
+	if err := extractZipFile(f, filepath.Join(tmpDir, path.Base(f.Name))); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	executable, err := os.Open(filepath.Join(tmpDir, path.Base(f.Name)))
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return executable, func() {
+		if err := executable.Close(); err != nil {
+			log.Warnf("Failed to close %s: %s", f.Name, err)
+		}
+		cleanup()
+	}, nil
+}
+
+func extractZipFile(f *zip.File, destination string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := rc.Close(); err != nil {
+			log.Warnf("Failed to close %s: %s", f.Name, err)
+		}
+	}()
+
+	out, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, rc); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func readZipFile(f *zip.File, maxSize int64) ([]byte, error) {
+	if f.UncompressedSize64 > uint64(maxSize) {
+		return nil, fmt.Errorf("%s is too large (%d bytes)", f.Name, f.UncompressedSize64)
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rc.Close(); err != nil {
+			log.Warnf("Failed to close %s: %s", f.Name, err)
+		}
+	}()
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, io.LimitReader(rc, maxSize)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// pkgDistribution is the relevant part of a product archive's Distribution file:
+//
+//	<pkg-ref id="com.example.app">
+//	  <bundle-version>
+//	    <bundle CFBundleShortVersionString="1.0" CFBundleVersion="42" id="com.example.app" path="Example.app"/>
+//	  </bundle-version>
+//	</pkg-ref>
+type pkgDistribution struct {
+	PkgRefs []struct {
+		Bundles []struct {
+			ID           string `xml:"id,attr"`
+			ShortVersion string `xml:"CFBundleShortVersionString,attr"`
+			Version      string `xml:"CFBundleVersion,attr"`
+		} `xml:"bundle-version>bundle"`
+	} `xml:"pkg-ref"`
+}
+
+func readPkgInfo(pth string) (artifactInfo, error) {
+	archive, err := openXar(pth)
+	if err != nil {
+		return artifactInfo{}, err
+	}
+	defer func() {
+		if err := archive.Close(); err != nil {
+			log.Warnf("Failed to close %s: %s", pth, err)
+		}
+	}()
+
+	file, ok := archive.findFile("Distribution")
+	if !ok {
+		return artifactInfo{}, errors.New("no Distribution file found in the PKG")
+	}
+
+	content, err := archive.readFile(file, maxPlistSize)
+	if err != nil {
+		return artifactInfo{}, err
+	}
+
+	var distribution pkgDistribution
+	if err := xml.Unmarshal(content, &distribution); err != nil {
+		return artifactInfo{}, fmt.Errorf("failed to parse Distribution: %s", err)
+	}
+
+	for _, pkgRef := range distribution.PkgRefs {
+		if len(pkgRef.Bundles) > 0 {
+			bundle := pkgRef.Bundles[0]
+			return artifactInfo{
+				BundleID:    bundle.ID,
+				Version:     bundle.ShortVersion,
+				BuildNumber: bundle.Version,
+			}, nil
+		}
+	}
+
+	return artifactInfo{}, errors.New("no app bundle version found in the PKG Distribution")
+}
+
+func pkgSigningCertificate(pth string) (*x509.Certificate, error) {
+	archive, err := openXar(pth)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := archive.Close(); err != nil {
+			log.Warnf("Failed to close %s: %s", pth, err)
+		}
+	}()
+
+	if len(archive.toc.Signature.Certificates) == 0 {
+		return nil, errors.New("package is not signed")
+	}
+
+	var certs []*x509.Certificate
+	for _, encoded := range archive.toc.Signature.Certificates {
+		der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(encoded), ""))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode package certificate: %s", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse package certificate: %s", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	return leafCertificate(certs)
+}
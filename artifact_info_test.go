@@ -0,0 +1,277 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_readArtifactInfo_ipa(t *testing.T) {
+	for _, name := range []string{"Info.xml.plist", "Info.binary.plist"} {
+		t.Run(name, func(t *testing.T) {
+			infoPlist, err := os.ReadFile(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pth := filepath.Join(t.TempDir(), "Example.ipa")
+			writeTestZip(t, pth, zip.Deflate, map[string][]byte{
+				"Payload/Example.app/Info.plist":                      infoPlist,
+				"Payload/Example.app/PlugIns/Widget.appex/Info.plist": []byte("not the main app"),
+				"Payload/Example.app/Example":                         []byte("executable"),
+			})
+
+			got, err := readArtifactInfo(pth)
+			if err != nil {
+				t.Fatalf("readArtifactInfo() error = %v", err)
+			}
+			if want := (artifactInfo{BundleID: "io.bitrise.Example", Version: "1.2.3", BuildNumber: "42"}); got != want {
+				t.Errorf("readArtifactInfo() got = %v, want %v", got, want)
+			}
+
+			if _, err := readArtifactSignature(pth); err == nil {
+				t.Errorf("readArtifactSignature() expected error for an unsigned executable")
+			}
+		})
+	}
+}
+
+func Test_readArtifactSignature_ipa(t *testing.T) {
+	infoPlist, err := os.ReadFile(filepath.Join("testdata", "Info.binary.plist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, leaf := testCertificateChain(t)
+	executable := testMachO(t, testCodeSignature(t, root.Raw, leaf.Raw))
+
+	for name, method := range map[string]uint16{"stored": zip.Store, "deflated": zip.Deflate} {
+		t.Run(name, func(t *testing.T) {
+			pth := filepath.Join(t.TempDir(), "Example.ipa")
+			writeTestZip(t, pth, method, map[string][]byte{
+				"Payload/Example.app/Info.plist": infoPlist,
+				"Payload/Example.app/Example":    executable,
+			})
+
+			signature, err := readArtifactSignature(pth)
+			if err != nil {
+				t.Fatalf("readArtifactSignature() error = %v", err)
+			}
+			if signature.TeamID != "ABCDE12345" {
+				t.Errorf("readArtifactSignature() got = %+v", signature)
+			}
+		})
+	}
+}
+
+func Test_readArtifactInfo_pkg(t *testing.T) {
+	root, leaf := testCertificateChain(t)
+	distribution := `<?xml version="1.0" encoding="utf-8"?>
+<installer-gui-script minSpecVersion="2">
+    <pkg-ref id="io.bitrise.Example">
+        <bundle-version>
+            <bundle CFBundleShortVersionString="1.2.3" CFBundleVersion="42" id="io.bitrise.Example" path="Example.app"/>
+        </bundle-version>
+    </pkg-ref>
+    <product id="io.bitrise.Example" version="1.2.3"/>
+</installer-gui-script>`
+
+	pth := filepath.Join(t.TempDir(), "Example.pkg")
+	if err := os.WriteFile(pth, testXar(t, distribution, leaf.Raw, root.Raw), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readArtifactInfo(pth)
+	if err != nil {
+		t.Fatalf("readArtifactInfo() error = %v", err)
+	}
+	if want := (artifactInfo{BundleID: "io.bitrise.Example", Version: "1.2.3", BuildNumber: "42"}); got != want {
+		t.Errorf("readArtifactInfo() got = %v, want %v", got, want)
+	}
+
+	signature, err := readArtifactSignature(pth)
+	if err != nil {
+		t.Fatalf("readArtifactSignature() error = %v", err)
+	}
+	if signature.TeamID != "ABCDE12345" || signature.CertificateName != "3rd Party Mac Developer Installer: Bitrise (ABCDE12345)" {
+		t.Errorf("readArtifactSignature() got = %+v", signature)
+	}
+}
+
+func Test_codeSignatureCertificate(t *testing.T) {
+	root, leaf := testCertificateChain(t)
+
+	cert, err := codeSignatureCertificate(testCodeSignature(t, root.Raw, leaf.Raw))
+	if err != nil {
+		t.Fatalf("codeSignatureCertificate() error = %v", err)
+	}
+	if info := newSignatureInfo(cert); info.TeamID != "ABCDE12345" {
+		t.Errorf("codeSignatureCertificate() got = %+v", info)
+	}
+}
+
+// testCodeSignature returns an embedded signature super blob with a single CMS blob, holding the certificates in the given order
+func testCodeSignature(t *testing.T, certs ...[]byte) []byte {
+	signedData, err := asn1.Marshal(struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		ContentInfo      struct{ ContentType asn1.ObjectIdentifier }
+		Certificates     asn1.RawValue
+	}{
+		Version:          1,
+		DigestAlgorithms: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true},
+		ContentInfo:      struct{ ContentType asn1.ObjectIdentifier }{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: bytes.Join(certs, nil)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cms, err := asn1.Marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{
+		ContentType: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2},
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Super blob with a single CMS blob wrapper
+	var superBlob bytes.Buffer
+	for _, value := range []uint32{csMagicEmbeddedSignature, uint32(20 + 8 + len(cms)), 1, 0x10000, 20, csMagicBlobWrapper, uint32(8 + len(cms))} {
+		if err := binary.Write(&superBlob, binary.BigEndian, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	superBlob.Write(cms)
+
+	return superBlob.Bytes()
+}
+
+// testMachO returns a 64-bit arm64 Mach-O executable with an LC_CODE_SIGNATURE load command pointing to the signature
+func testMachO(t *testing.T, signature []byte) []byte {
+	const headerSize, loadCmdSize = 32, 16
+	var executable bytes.Buffer
+	for _, value := range []uint32{
+		0xfeedfacf, 0x0100000c, 0, 2, 1, loadCmdSize, 0, 0, // mach_header_64: magic, cputype, cpusubtype, filetype, ncmds, sizeofcmds, flags, reserved
+		loadCmdCodeSignature, loadCmdSize, headerSize + loadCmdSize, uint32(len(signature)), // linkedit_data_command: cmd, cmdsize, dataoff, datasize
+	} {
+		if err := binary.Write(&executable, binary.LittleEndian, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	executable.Write(signature)
+	return executable.Bytes()
+}
+
+func writeTestZip(t *testing.T, pth string, method uint16, files map[string][]byte) {
+	f, err := os.Create(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	for name, content := range files {
+		fw, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testCertificateChain(t *testing.T) (*x509.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Apple Worldwide Developer Relations Certification Authority"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := x509.ParseCertificate(rootDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "3rd Party Mac Developer Installer: Bitrise (ABCDE12345)", OrganizationalUnit: []string{"ABCDE12345"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, root, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(leafDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return root, leaf
+}
+
+// testXar creates a xar archive with an uncompressed Distribution file and the given signature certificates
+func testXar(t *testing.T, distribution string, certs ...[]byte) []byte {
+	var certElements string
+	for _, cert := range certs {
+		certElements += fmt.Sprintf("<X509Certificate>%s</X509Certificate>", base64.StdEncoding.EncodeToString(cert))
+	}
+
+	toc := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<xar><toc>
+<signature style="RSA"><offset>0</offset><size>0</size><KeyInfo xmlns="http://www.w3.org/2000/09/xmldsig#"><X509Data>%s</X509Data></KeyInfo></signature>
+<file id="1"><name>Distribution</name><type>file</type><data><length>%d</length><offset>0</offset><size>%d</size><encoding style="application/octet-stream"/></data></file>
+</toc></xar>`, certElements, len(distribution), len(distribution))
+
+	var compressedTOC bytes.Buffer
+	zw := zlib.NewWriter(&compressedTOC)
+	if _, err := zw.Write([]byte(toc)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	for _, value := range []interface{}{uint32(xarMagic), uint16(28), uint16(1), uint64(compressedTOC.Len()), uint64(len(toc)), uint32(1)} {
+		if err := binary.Write(&archive, binary.BigEndian, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archive.Write(compressedTOC.Bytes())
+	archive.WriteString(distribution)
+
+	return archive.Bytes()
+}
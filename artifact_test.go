@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_sanitizedArtifactName(t *testing.T) {
+	tests := []struct {
+		pth  string
+		want string
+	}{
+		{pth: "/deploy/My App.ipa", want: "My_App.ipa"},
+		{pth: "/deploy/Brand (Staging) ñ.pkg", want: "Brand_Staging.pkg"},
+		{pth: "/deploy/ .ipa", want: "artifact.ipa"},
+		{pth: "/deploy/App-1.0+2.ipa", want: "App-1.0+2.ipa"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pth, func(t *testing.T) {
+			if got := sanitizedArtifactName(tt.pth); got != tt.want {
+				t.Errorf("sanitizedArtifactName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_stageArtifact(t *testing.T) {
+	dir := t.TempDir()
+	tmpDir := t.TempDir()
+
+	safePth := filepath.Join(dir, "App.ipa")
+	unsafePth := filepath.Join(dir, "My App.ipa")
+	for _, pth := range []string{safePth, unsafePth} {
+		if err := os.WriteFile(pth, []byte("ipa"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := stageArtifact(safePth, tmpDir)
+	if err != nil {
+		t.Fatalf("stageArtifact() error = %v", err)
+	}
+	if got != safePth {
+		t.Errorf("stageArtifact() = %v, want %v", got, safePth)
+	}
+
+	got, err = stageArtifact(unsafePth, tmpDir)
+	if err != nil {
+		t.Fatalf("stageArtifact() error = %v", err)
+	}
+	if want := filepath.Join(tmpDir, "My_App.ipa"); got != want {
+		t.Errorf("stageArtifact() = %v, want %v", got, want)
+	}
+	if content, err := os.ReadFile(got); err != nil || string(content) != "ipa" {
+		t.Errorf("staged artifact content = %s, error = %v", content, err)
+	}
+
+	if _, err := stageArtifact(filepath.Join(dir, "missing.ipa"), tmpDir); err == nil {
+		t.Errorf("stageArtifact() expected error for missing artifact")
+	}
+}
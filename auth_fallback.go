@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-xcode/appleauth"
+	"github.com/bitrise-io/go-xcode/devportalservice"
+)
+
+// authCandidate is the credential of a configured authentication source
+type authCandidate struct {
+	Source      string
+	Credentials appleauth.Credentials
+}
+
+// selectAuthCandidates returns the credentials of every configured authentication source, in the order of the sources.
+// appleauth.Select only returns the first one, the others are the fallbacks of deliver if the first credential is rejected.
+// A source failing before a credential is found fails the selection like appleauth.Select does, a later one is skipped.
+func selectAuthCandidates(conn *devportalservice.AppleDeveloperConnection, authSources []appleauth.Source, inputs appleauth.Inputs) ([]authCandidate, error) {
+	var candidates []authCandidate
+	for _, source := range authSources {
+		auth, err := source.Fetch(conn, inputs)
+		if err != nil {
+			if len(candidates) == 0 {
+				return nil, err
+			}
+			log.Warnf("The %s can't be used as a fallback: %s", authSourceName(source), err)
+			continue
+		}
+		if auth == nil {
+			continue
+		}
+
+		if len(candidates) == 0 {
+			fmt.Println()
+			log.Infof("%s", source.Description())
+		} else {
+			log.Printf("Fallback authentication: %s", authSourceName(source))
+		}
+		candidates = append(candidates, authCandidate{Source: authSourceName(source), Credentials: *auth})
+	}
+
+	if len(candidates) == 0 {
+		return nil, &appleauth.MissingAuthConfigError{}
+	}
+	return candidates, nil
+}
+
+// authSourceName returns the name of the authentication source used in the logs
+func authSourceName(source appleauth.Source) string {
+	switch source.(type) {
+	case *appleauth.ConnectionAPIKeySource:
+		return "Bitrise Apple Developer Connection API key"
+	case *appleauth.ConnectionAppleIDSource, *appleauth.ConnectionAppleIDFastlaneSource:
+		return "Bitrise Apple Developer Connection Apple ID"
+	case *appleauth.InputAPIKeySource:
+		return "API key inputs"
+	case *appleauth.InputAppleIDSource, *appleauth.InputAppleIDFastlaneSource:
+		return "Apple ID inputs"
+	case *fileAPIKeySource:
+		return "API key file"
+	case *envAPIKeySource:
+		return "API key environment variables"
+	case *vaultAPIKeySource:
+		return "API key in Vault"
+	default:
+		return source.Description()
+	}
+}
+
+// authFailurePattern matches the errors fastlane prints when App Store Connect rejects the credential,
+// for example an expired Apple ID session or a revoked API key
+var authFailurePattern = regexp.MustCompile(`(?i)invalid username and password combination|session (has )?expired|` +
+	`authentication credentials are missing or invalid|not_authorized|unauthorized access|unable to authenticate|` +
+	`the provided entity is not authorized`)
+
+// authFailureDetector watches the output of deliver for rejected credentials
+type authFailureDetector struct {
+	mu      sync.Mutex
+	failure string
+}
+
+// Writer returns a writer passing the output to w
+func (d *authFailureDetector) Writer(w io.Writer) io.Writer {
+	return &authFailureWriter{detector: d, out: w}
+}
+
+// Failure returns the first output line reporting a rejected credential, if any
+func (d *authFailureDetector) Failure() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.failure
+}
+
+func (d *authFailureDetector) check(line string) {
+	if !authFailurePattern.MatchString(line) {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.failure == "" {
+		d.failure = line
+	}
+}
+
+type authFailureWriter struct {
+	detector *authFailureDetector
+	out      io.Writer
+
+	line []byte
+}
+
+func (w *authFailureWriter) Write(p []byte) (int, error) {
+	n, err := w.out.Write(p)
+	for _, b := range p[:n] {
+		if b == '\n' || b == '\r' {
+			w.detector.check(string(w.line))
+			w.line = w.line[:0]
+			continue
+		}
+		if len(w.line) < 1024 {
+			w.line = append(w.line, b)
+		}
+	}
+	return n, err
+}
+
+// isAuthFailure reports whether deliver failed because App Store Connect rejected the credential.
+// An Apple ID session that is expired makes fastlane ask for the password or the 2FA code, which stops deliver too.
+func isAuthFailure(err error, detector *authFailureDetector) bool {
+	if err == nil || isTimeout(err) {
+		return false
+	}
+	var promptErr interactivePromptError
+	if errors.As(err, &promptErr) {
+		return promptErr.Authentication
+	}
+	return detector.Failure() != ""
+}
+
+// authFailureReason returns the prompt or the output line of the rejected credential
+func authFailureReason(err error, detector *authFailureDetector) string {
+	var promptErr interactivePromptError
+	if errors.As(err, &promptErr) {
+		return promptErr.Message
+	}
+	return strings.TrimSpace(detector.Failure())
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-xcode/appleauth"
+	"github.com/bitrise-io/go-xcode/devportalservice"
+	"github.com/bitrise-steplib/steps-deploy-to-itunesconnect-deliver/appstoreconnect"
+	"github.com/bitrise-steplib/steps-deploy-to-itunesconnect-deliver/appstoreconnect/appstoreconnecttest"
+)
+
+// fakeAuthSource returns the configured credential or error
+type fakeAuthSource struct {
+	name        string
+	credentials *appleauth.Credentials
+	err         error
+}
+
+func (s *fakeAuthSource) Description() string {
+	return s.name
+}
+
+func (s *fakeAuthSource) Fetch(conn *devportalservice.AppleDeveloperConnection, inputs appleauth.Inputs) (*appleauth.Credentials, error) {
+	return s.credentials, s.err
+}
+
+func Test_selectAuthCandidates(t *testing.T) {
+	appleID := &appleauth.Credentials{AppleID: &appleauth.AppleID{Username: "user@example.com"}}
+	apiKey := &appleauth.Credentials{APIKey: &devportalservice.APIKeyConnection{KeyID: "ABC123"}}
+
+	tests := []struct {
+		name        string
+		sources     []appleauth.Source
+		wantSources []string
+		wantErr     bool
+	}{
+		{
+			name: "every configured source in order",
+			sources: []appleauth.Source{
+				&fakeAuthSource{name: "connection API key"},
+				&fakeAuthSource{name: "connection Apple ID", credentials: appleID},
+				&fakeAuthSource{name: "input API key", credentials: apiKey},
+			},
+			wantSources: []string{"connection Apple ID", "input API key"},
+		},
+		{
+			name: "failing fallback is skipped",
+			sources: []appleauth.Source{
+				&fakeAuthSource{name: "connection Apple ID", credentials: appleID},
+				&fakeAuthSource{name: "input API key", err: errors.New("invalid API key")},
+			},
+			wantSources: []string{"connection Apple ID"},
+		},
+		{
+			name: "failing first source",
+			sources: []appleauth.Source{
+				&fakeAuthSource{name: "input API key", err: errors.New("invalid API key")},
+				&fakeAuthSource{name: "input Apple ID", credentials: appleID},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "no configured source",
+			sources: []appleauth.Source{&fakeAuthSource{name: "input API key"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectAuthCandidates(nil, tt.sources, appleauth.Inputs{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectAuthCandidates() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var gotSources []string
+			for _, candidate := range got {
+				gotSources = append(gotSources, candidate.Source)
+			}
+			if strings.Join(gotSources, ", ") != strings.Join(tt.wantSources, ", ") {
+				t.Errorf("selectAuthCandidates() sources = %q, want %q", gotSources, tt.wantSources)
+			}
+		})
+	}
+}
+
+func Test_deployTarget_authFallback(t *testing.T) {
+	appleID := authCandidate{Credentials: appleauth.Credentials{AppleID: &appleauth.AppleID{Username: "user@example.com", Session: "expired"}}}
+	apiKey := authCandidate{Source: "API key inputs", Credentials: appleauth.Credentials{APIKey: &devportalservice.APIKeyConnection{KeyID: "ABC123", IssuerID: "issuer", PrivateKey: "key"}}}
+	connectionAPIKey := authCandidate{Credentials: appleauth.Credentials{APIKey: &devportalservice.APIKeyConnection{KeyID: "REVOKED123", IssuerID: "issuer", PrivateKey: "revoked"}}}
+
+	tests := []struct {
+		name      string
+		first     authCandidate
+		fallbacks []authCandidate
+		// failing is the deliver command line prefix of the failing runs
+		failing        string
+		output         string
+		err            error
+		wantSuccess    bool
+		wantAuthSource string
+		wantCommands   int
+	}{
+		{
+			name:           "expired session falls back to the API key",
+			first:          appleID,
+			fallbacks:      []authCandidate{apiKey},
+			failing:        "fastlane deliver --username",
+			output:         "[!] Your session has expired. Please login again.",
+			err:            errors.New("exit status 1"),
+			wantSuccess:    true,
+			wantAuthSource: "API key inputs",
+			wantCommands:   2,
+		},
+		{
+			name:           "2FA prompt falls back to the API key",
+			first:          appleID,
+			fallbacks:      []authCandidate{apiKey},
+			failing:        "fastlane deliver --username",
+			err:            interactivePromptError{Prompt: "Please enter the 6 digit code:", Message: "Apple ID requires 2FA", Authentication: true},
+			wantSuccess:    true,
+			wantAuthSource: "API key inputs",
+			wantCommands:   2,
+		},
+		{
+			name:         "other failures are not retried",
+			first:        appleID,
+			fallbacks:    []authCandidate{apiKey},
+			failing:      "fastlane deliver --username",
+			output:       "[!] The provided IPA is invalid",
+			err:          errors.New("exit status 1"),
+			wantCommands: 1,
+		},
+		{
+			name:         "no fallback credential",
+			first:        appleID,
+			failing:      "fastlane deliver --username",
+			output:       "[!] Your session has expired. Please login again.",
+			err:          errors.New("exit status 1"),
+			wantCommands: 1,
+		},
+		{
+			name:         "first credential succeeds",
+			first:        appleID,
+			fallbacks:    []authCandidate{apiKey},
+			wantSuccess:  true,
+			wantCommands: 1,
+		},
+		{
+			// both runs fail, the fallback is retried with its own api_key.json
+			name:         "revoked API key falls back to the API key inputs",
+			first:        connectionAPIKey,
+			fallbacks:    []authCandidate{apiKey},
+			failing:      "fastlane deliver --api_key_path",
+			output:       "[!] Authentication credentials are missing or invalid.",
+			err:          errors.New("exit status 1"),
+			wantCommands: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ipaPth := filepath.Join(t.TempDir(), "app.ipa")
+			writeTestFile(t, ipaPth, "ipa")
+			target := appTarget{IpaPath: ipaPth, BundleID: "com.example.app"}
+			fastlane := fastlaneInstallation{CmdSlice: []string{"fastlane"}}
+
+			runner := newFakeRunner()
+			if tt.failing != "" {
+				runner.on(tt.failing, tt.output, tt.err)
+			}
+			result := deployTarget(runner, Config{Platform: "ios"}, stepTimeouts{}, target, tt.first.Credentials, fastlane, uploadBackendAltool, nil, tt.fallbacks...)
+			if result.Success != tt.wantSuccess || result.AuthSource != tt.wantAuthSource {
+				t.Errorf("deployTarget() = %+v, want success %v with %q", result, tt.wantSuccess, tt.wantAuthSource)
+			}
+
+			commands := runner.commandLines()
+			if len(commands) != tt.wantCommands {
+				t.Fatalf("deployTarget() commands = %q, want %d deliver runs", commands, tt.wantCommands)
+			}
+			if tt.wantCommands == 2 && !strings.Contains(commands[1], "/fallback/api_key.json") {
+				t.Errorf("deployTarget() retried with %s, want the API key of the fallback", commands[1])
+			}
+		})
+	}
+}
+
+func Test_deployTarget_authFallbackAPIUpload(t *testing.T) {
+	server, err := appstoreconnecttest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.AddApp("io.bitrise.Example", "Example")
+
+	baseURL, options := appStoreConnectBaseURL, apiUploadOptions
+	appStoreConnectBaseURL = server.URL + "/"
+	apiUploadOptions = appstoreconnect.BuildUploadOptions{RetryWait: time.Millisecond, PollInterval: time.Millisecond}
+	defer func() {
+		appStoreConnectBaseURL, apiUploadOptions = baseURL, options
+	}()
+
+	ipaPth := filepath.Join(t.TempDir(), "app.ipa")
+	writeTestIPA(t, ipaPth)
+	target := appTarget{IpaPath: ipaPth, BundleID: "io.bitrise.Example"}
+	revoked := appleauth.Credentials{APIKey: &devportalservice.APIKeyConnection{KeyID: "REVOKED123", IssuerID: server.IssuerID, PrivateKey: string(server.PrivateKey)}}
+	fallback := authCandidate{Source: "API key inputs", Credentials: appleauth.Credentials{APIKey: &devportalservice.APIKeyConnection{KeyID: server.KeyID, IssuerID: server.IssuerID, PrivateKey: string(server.PrivateKey)}}}
+	fastlane := fastlaneInstallation{CmdSlice: []string{"fastlane"}}
+	cfg := Config{SkipMetadata: "yes", SkipScreenshots: "yes", SkipAppVersionUpdate: "yes", SubmitForReview: "no", Platform: "ios"}
+
+	// the fallback credentials are only used by deliver, the API upload fails with the rejected key
+	runner := newFakeRunner()
+	result := deployTarget(runner, cfg, stepTimeouts{}, target, revoked, fastlane, uploadBackendAPI, nil, fallback)
+	if result.Success || result.AuthSource != "" {
+		t.Errorf("deployTarget() = %+v, want the API upload error", result)
+	}
+	if uploaded := server.UploadedBuilds(); len(uploaded) != 0 {
+		t.Errorf("uploaded builds = %+v, want none", uploaded)
+	}
+	if commands := runner.commandLines(); len(commands) != 0 {
+		t.Errorf("deployTarget() commands = %q, want deliver not run", commands)
+	}
+}
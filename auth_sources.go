@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-xcode/appleauth"
+	"github.com/bitrise-io/go-xcode/devportalservice"
+	"github.com/bitrise-steplib/steps-deploy-to-itunesconnect-deliver/appstoreconnect"
+)
+
+// API key environment variables, as set by most CI secret managers
+const (
+	ascKeyIDEnvKey      = "ASC_KEY_ID"
+	ascIssuerIDEnvKey   = "ASC_ISSUER_ID"
+	ascKeyContentEnvKey = "ASC_KEY_CONTENT"
+)
+
+// App Store Connect API key types: team keys belong to an issuer, individual keys to a user and have no issuer ID
+const (
+	apiKeyTypeTeam       = "team"
+	apiKeyTypeIndividual = "individual"
+)
+
+// validateAuthInputs checks the authentication inputs like appleauth.Inputs.Validate does,
+// except that individual API keys need no issuer ID
+func validateAuthInputs(inputs *appleauth.Inputs, keyType string) error {
+	if keyType != apiKeyTypeIndividual {
+		return inputs.Validate()
+	}
+
+	inputs.APIKeyPath = strings.TrimSpace(inputs.APIKeyPath)
+	if inputs.APIIssuer = strings.TrimSpace(inputs.APIIssuer); inputs.APIIssuer != "" {
+		log.Warnf("Individual API keys have no issuer ID, the API Key: Issuer ID input is ignored")
+		inputs.APIIssuer = ""
+	}
+
+	isAppleIDAuthType := strings.TrimSpace(inputs.Username) != "" || inputs.Password != "" || strings.TrimSpace(inputs.AppSpecificPassword) != ""
+	if !isAppleIDAuthType {
+		return nil
+	}
+	if inputs.APIKeyPath != "" {
+		return fmt.Errorf("both Apple ID and API key related configuration provided, but only one of them expected")
+	}
+	return inputs.Validate()
+}
+
+// fileAPIKeySource provides API Key from a local JSON file in the format fastlane's api_key_path uses:
+// key_id, issuer_id and the key content (key) or path (key_filepath)
+type fileAPIKeySource struct {
+	Path    string
+	KeyType string
+}
+
+// envAPIKeySource provides API Key from the ASC_KEY_ID, ASC_ISSUER_ID and ASC_KEY_CONTENT (base64 encoded .p8 file) environment variables
+type envAPIKeySource struct {
+	KeyType string
+}
+
+// vaultAPIKeySource provides API Key from a HashiCorp Vault compatible KV secret,
+// with key_id, issuer_id and private_key fields
+type vaultAPIKeySource struct {
+	Address    string
+	Token      string
+	SecretPath string
+	KeyType    string
+
+	client *http.Client
+}
+
+// Description ...
+func (*fileAPIKeySource) Description() string {
+	return "API key file found"
+}
+
+// Fetch ...
+func (s *fileAPIKeySource) Fetch(conn *devportalservice.AppleDeveloperConnection, inputs appleauth.Inputs) (*appleauth.Credentials, error) {
+	if s.Path == "" { // Not configured
+		return nil, nil
+	}
+
+	content, err := os.ReadFile(s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read API key file: %s", err)
+	}
+
+	var keyFile struct {
+		KeyID       string `json:"key_id"`
+		IssuerID    string `json:"issuer_id"`
+		Key         string `json:"key"`
+		KeyFilepath string `json:"key_filepath"`
+	}
+	if err := json.Unmarshal(content, &keyFile); err != nil {
+		return nil, fmt.Errorf("invalid API key file (%s): %s", s.Path, err)
+	}
+
+	privateKey := keyFile.Key
+	if privateKey == "" && keyFile.KeyFilepath != "" {
+		keyPth := keyFile.KeyFilepath
+		if !filepath.IsAbs(keyPth) {
+			keyPth = filepath.Join(filepath.Dir(s.Path), keyPth)
+		}
+		content, err := os.ReadFile(keyPth)
+		if err != nil {
+			return nil, fmt.Errorf("could not read private key referenced by the API key file: %s", err)
+		}
+		privateKey = string(content)
+	}
+
+	return newAPIKeyCredentials(keyFile.KeyID, keyFile.IssuerID, privateKey, s.KeyType, s.Path)
+}
+
+//
+
+// Description ...
+func (*envAPIKeySource) Description() string {
+	return "API key environment variables found"
+}
+
+// Fetch ...
+func (s *envAPIKeySource) Fetch(conn *devportalservice.AppleDeveloperConnection, inputs appleauth.Inputs) (*appleauth.Credentials, error) {
+	keyID, issuerID, keyContent := os.Getenv(ascKeyIDEnvKey), os.Getenv(ascIssuerIDEnvKey), os.Getenv(ascKeyContentEnvKey)
+	if keyID == "" && issuerID == "" && keyContent == "" { // Not configured
+		return nil, nil
+	}
+
+	privateKey := strings.TrimSpace(keyContent)
+	// Accept the .p8 file content as is too, not only base64 encoded
+	if privateKey != "" && !strings.HasPrefix(privateKey, "-----BEGIN") {
+		decoded, err := base64.StdEncoding.DecodeString(privateKey)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not base64 encoded: %s", ascKeyContentEnvKey, err)
+		}
+		privateKey = string(decoded)
+	}
+
+	return newAPIKeyCredentials(keyID, issuerID, privateKey, s.KeyType, "environment variables")
+}
+
+//
+
+// Description ...
+func (*vaultAPIKeySource) Description() string {
+	return "API key found in Vault"
+}
+
+// Fetch ...
+func (s *vaultAPIKeySource) Fetch(conn *devportalservice.AppleDeveloperConnection, inputs appleauth.Inputs) (*appleauth.Credentials, error) {
+	if s.SecretPath == "" { // Not configured
+		return nil, nil
+	}
+	if s.Address == "" {
+		return nil, fmt.Errorf("no Vault address provided")
+	}
+
+	secretURL := strings.TrimSuffix(s.Address, "/") + "/v1/" + strings.TrimPrefix(s.SecretPath, "/")
+	req, err := http.NewRequest(http.MethodGet, secretURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", s.Token)
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	client := s.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not read Vault secret (%s): %s", s.SecretPath, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.Unmarshal(body, &vaultErr)
+		return nil, fmt.Errorf("could not read Vault secret (%s): status %d %s", s.SecretPath, resp.StatusCode, strings.Join(vaultErr.Errors, ", "))
+	}
+
+	// KV version 2 nests the secret in data.data, version 1 returns it in data
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return nil, fmt.Errorf("invalid Vault response: %s", err)
+	}
+	fields := secret.Data
+	if nested, ok := secret.Data["data"]; ok {
+		if err := json.Unmarshal(nested, &fields); err != nil {
+			return nil, fmt.Errorf("invalid Vault response: %s", err)
+		}
+	}
+
+	field := func(key string) string {
+		var value string
+		_ = json.Unmarshal(fields[key], &value)
+		return value
+	}
+
+	return newAPIKeyCredentials(field("key_id"), field("issuer_id"), field("private_key"), s.KeyType, "Vault secret "+s.SecretPath)
+}
+
+// newAPIKeyCredentials checks that the API key is complete and the private key is valid
+func newAPIKeyCredentials(keyID, issuerID, privateKey, keyType, source string) (*appleauth.Credentials, error) {
+	if keyType == apiKeyTypeIndividual && issuerID != "" {
+		log.Warnf("Individual API keys have no issuer ID, the issuer ID in %s is ignored", source)
+		issuerID = ""
+	}
+
+	var missing []string
+	if keyID == "" {
+		missing = append(missing, "key ID")
+	}
+	if issuerID == "" && keyType != apiKeyTypeIndividual {
+		missing = append(missing, "issuer ID")
+	}
+	if privateKey == "" {
+		missing = append(missing, "private key")
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("incomplete API key in %s, missing: %s", source, strings.Join(missing, ", "))
+	}
+
+	if _, err := appstoreconnect.ParsePrivateKey([]byte(privateKey)); err != nil {
+		return nil, fmt.Errorf("invalid API key in %s: %s", source, err)
+	}
+
+	return &appleauth.Credentials{
+		APIKey: &devportalservice.APIKeyConnection{
+			KeyID:      keyID,
+			IssuerID:   issuerID,
+			PrivateKey: privateKey,
+		},
+	}, nil
+}
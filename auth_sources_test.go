@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-xcode/appleauth"
+	"github.com/bitrise-io/go-xcode/devportalservice"
+)
+
+func testAPIPrivateKey(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func Test_fileAPIKeySource(t *testing.T) {
+	privateKey := testAPIPrivateKey(t)
+	escapedKey := strings.ReplaceAll(privateKey, "\n", `\n`)
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "keys"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "keys", "AuthKey_ABC123DEFG.p8"), privateKey)
+
+	tests := []struct {
+		name    string
+		content string
+		want    *devportalservice.APIKeyConnection
+		wantErr string
+	}{
+		{
+			name:    "inline key",
+			content: `{"key_id": "ABC123DEFG", "issuer_id": "issuer", "key": "` + escapedKey + `"}`,
+			want:    &devportalservice.APIKeyConnection{KeyID: "ABC123DEFG", IssuerID: "issuer", PrivateKey: privateKey},
+		},
+		{
+			name:    "key file path relative to the JSON file",
+			content: `{"key_id": "ABC123DEFG", "issuer_id": "issuer", "key_filepath": "keys/AuthKey_ABC123DEFG.p8"}`,
+			want:    &devportalservice.APIKeyConnection{KeyID: "ABC123DEFG", IssuerID: "issuer", PrivateKey: privateKey},
+		},
+		{
+			name:    "missing issuer",
+			content: `{"key_id": "ABC123DEFG", "key": "` + escapedKey + `"}`,
+			wantErr: "missing: issuer ID",
+		},
+		{
+			name:    "invalid key",
+			content: `{"key_id": "ABC123DEFG", "issuer_id": "issuer", "key": "not a key"}`,
+			wantErr: "private key is not PEM encoded",
+		},
+		{
+			name:    "invalid JSON",
+			content: `key_id: ABC123DEFG`,
+			wantErr: "invalid API key file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pth := filepath.Join(dir, "api_key.json")
+			writeTestFile(t, pth, tt.content)
+
+			got, err := (&fileAPIKeySource{Path: pth}).Fetch(nil, appleauth.Inputs{})
+			assertAPIKeyCredentials(t, got, err, tt.want, tt.wantErr)
+		})
+	}
+
+	if got, err := (&fileAPIKeySource{}).Fetch(nil, appleauth.Inputs{}); got != nil || err != nil {
+		t.Errorf("Fetch() without path = %v, %v, want not configured", got, err)
+	}
+}
+
+func Test_envAPIKeySource(t *testing.T) {
+	privateKey := testAPIPrivateKey(t)
+
+	tests := []struct {
+		name       string
+		keyID      string
+		issuerID   string
+		keyContent string
+		keyType    string
+		want       *devportalservice.APIKeyConnection
+		wantErr    string
+	}{
+		{
+			name: "not configured",
+		},
+		{
+			name:       "base64 encoded key",
+			keyID:      "ABC123DEFG",
+			issuerID:   "issuer",
+			keyContent: base64.StdEncoding.EncodeToString([]byte(privateKey)),
+			want:       &devportalservice.APIKeyConnection{KeyID: "ABC123DEFG", IssuerID: "issuer", PrivateKey: privateKey},
+		},
+		{
+			name:       "plain key",
+			keyID:      "ABC123DEFG",
+			issuerID:   "issuer",
+			keyContent: privateKey,
+			want:       &devportalservice.APIKeyConnection{KeyID: "ABC123DEFG", IssuerID: "issuer", PrivateKey: strings.TrimSpace(privateKey)},
+		},
+		{
+			name:       "invalid base64",
+			keyID:      "ABC123DEFG",
+			issuerID:   "issuer",
+			keyContent: "%%%",
+			wantErr:    "ASC_KEY_CONTENT is not base64 encoded",
+		},
+		{
+			name:    "missing key content",
+			keyID:   "ABC123DEFG",
+			wantErr: "missing: issuer ID, private key",
+		},
+		{
+			name:       "individual key",
+			keyID:      "ABC123DEFG",
+			keyContent: privateKey,
+			keyType:    apiKeyTypeIndividual,
+			want:       &devportalservice.APIKeyConnection{KeyID: "ABC123DEFG", PrivateKey: strings.TrimSpace(privateKey)},
+		},
+		{
+			name:       "individual key ignores the issuer",
+			keyID:      "ABC123DEFG",
+			issuerID:   "issuer",
+			keyContent: privateKey,
+			keyType:    apiKeyTypeIndividual,
+			want:       &devportalservice.APIKeyConnection{KeyID: "ABC123DEFG", PrivateKey: strings.TrimSpace(privateKey)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(ascKeyIDEnvKey, tt.keyID)
+			t.Setenv(ascIssuerIDEnvKey, tt.issuerID)
+			t.Setenv(ascKeyContentEnvKey, tt.keyContent)
+
+			got, err := (&envAPIKeySource{KeyType: tt.keyType}).Fetch(nil, appleauth.Inputs{})
+			assertAPIKeyCredentials(t, got, err, tt.want, tt.wantErr)
+		})
+	}
+}
+
+func Test_vaultAPIKeySource(t *testing.T) {
+	privateKey := testAPIPrivateKey(t)
+	escapedKey := strings.ReplaceAll(privateKey, "\n", `\n`)
+	secrets := map[string]string{
+		"/v1/secret/data/appstoreconnect": `{"data": {"data": {"key_id": "ABC123DEFG", "issuer_id": "issuer", "private_key": "` + escapedKey + `"}, "metadata": {"version": 3}}}`,
+		"/v1/kv/appstoreconnect":          `{"data": {"key_id": "ABC123DEFG", "issuer_id": "issuer", "private_key": "` + escapedKey + `"}}`,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = fmt.Fprint(w, `{"errors": ["permission denied"]}`)
+			return
+		}
+		secret, ok := secrets[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = fmt.Fprint(w, `{"errors": []}`)
+			return
+		}
+		_, _ = fmt.Fprint(w, secret)
+	}))
+	defer server.Close()
+
+	want := &devportalservice.APIKeyConnection{KeyID: "ABC123DEFG", IssuerID: "issuer", PrivateKey: privateKey}
+	tests := []struct {
+		name       string
+		token      string
+		secretPath string
+		want       *devportalservice.APIKeyConnection
+		wantErr    string
+	}{
+		{name: "KV version 2", token: "token", secretPath: "secret/data/appstoreconnect", want: want},
+		{name: "KV version 1", token: "token", secretPath: "/kv/appstoreconnect", want: want},
+		{name: "permission denied", token: "other", secretPath: "secret/data/appstoreconnect", wantErr: "status 403 permission denied"},
+		{name: "missing secret", token: "token", secretPath: "secret/data/other", wantErr: "status 404"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &vaultAPIKeySource{Address: server.URL + "/", Token: tt.token, SecretPath: tt.secretPath, client: server.Client()}
+			got, err := source.Fetch(nil, appleauth.Inputs{})
+			assertAPIKeyCredentials(t, got, err, tt.want, tt.wantErr)
+		})
+	}
+}
+
+func Test_validateAuthInputs(t *testing.T) {
+	tests := []struct {
+		name       string
+		inputs     appleauth.Inputs
+		keyType    string
+		wantIssuer string
+		wantErr    bool
+	}{
+		{name: "team key", inputs: appleauth.Inputs{APIKeyPath: "file:///AuthKey_ABC.p8", APIIssuer: "issuer"}, keyType: apiKeyTypeTeam, wantIssuer: "issuer"},
+		{name: "team key without issuer", inputs: appleauth.Inputs{APIKeyPath: "file:///AuthKey_ABC.p8"}, keyType: apiKeyTypeTeam, wantErr: true},
+		{name: "individual key", inputs: appleauth.Inputs{APIKeyPath: "file:///AuthKey_ABC.p8"}, keyType: apiKeyTypeIndividual},
+		{name: "individual key ignores the issuer", inputs: appleauth.Inputs{APIKeyPath: "file:///AuthKey_ABC.p8", APIIssuer: " issuer "}, keyType: apiKeyTypeIndividual},
+		{name: "individual key and Apple ID", inputs: appleauth.Inputs{APIKeyPath: "file:///AuthKey_ABC.p8", Username: "user@example.com", Password: "password"}, keyType: apiKeyTypeIndividual, wantErr: true},
+		{name: "Apple ID without password", inputs: appleauth.Inputs{Username: "user@example.com"}, keyType: apiKeyTypeIndividual, wantErr: true},
+		{name: "Apple ID", inputs: appleauth.Inputs{Username: "user@example.com", Password: "password"}, keyType: apiKeyTypeIndividual},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inputs := tt.inputs
+			err := validateAuthInputs(&inputs, tt.keyType)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateAuthInputs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && inputs.APIIssuer != tt.wantIssuer {
+				t.Errorf("validateAuthInputs() issuer = %q, want %q", inputs.APIIssuer, tt.wantIssuer)
+			}
+		})
+	}
+}
+
+func assertAPIKeyCredentials(t *testing.T, got *appleauth.Credentials, err error, want *devportalservice.APIKeyConnection, wantErr string) {
+	t.Helper()
+
+	if wantErr != "" {
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("Fetch() error = %v, want error containing %q", err, wantErr)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if want == nil {
+		if got != nil {
+			t.Errorf("Fetch() = %+v, want not configured", got)
+		}
+		return
+	}
+	if got == nil || got.APIKey == nil || *got.APIKey != *want || got.AppleID != nil {
+		t.Errorf("Fetch() = %+v, want API key %+v", got, want)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// Bundle install modes
+const (
+	bundleModeDefault    = "default"
+	bundleModeFrozen     = "frozen"
+	bundleModeDeployment = "deployment"
+)
+
+// bundleConfig configures how the fastlane bundle is installed and executed.
+// The settings are passed to bundler as environment variables, so they apply to
+// `bundle install`, `bundle check` and `bundle exec` alike, whatever the bundler version.
+type bundleConfig struct {
+	Path    string
+	Without []string
+	Mode    string
+}
+
+func newBundleConfig(pth, without, mode string) bundleConfig {
+	return bundleConfig{
+		Path:    pth,
+		Without: strings.FieldsFunc(without, func(r rune) bool { return r == ',' || r == ':' || r == ' ' }),
+		Mode:    mode,
+	}
+}
+
+func (c bundleConfig) envs(gemfilePth string) []string {
+	var envs []string
+	if gemfilePth != "" {
+		if absPth, err := filepath.Abs(gemfilePth); err == nil {
+			gemfilePth = absPth
+		}
+		envs = append(envs, "BUNDLE_GEMFILE="+gemfilePth)
+	}
+	if c.Path != "" {
+		pth := c.Path
+		if absPth, err := filepath.Abs(pth); err == nil {
+			pth = absPth
+		}
+		envs = append(envs, "BUNDLE_PATH="+pth)
+	}
+	if len(c.Without) > 0 {
+		envs = append(envs, "BUNDLE_WITHOUT="+strings.Join(c.Without, ":"))
+	}
+	switch c.Mode {
+	case bundleModeFrozen:
+		envs = append(envs, "BUNDLE_FROZEN=true")
+	case bundleModeDeployment:
+		envs = append(envs, "BUNDLE_DEPLOYMENT=true")
+	}
+	return envs
+}
+
+// requiresLockfile reports whether bundler refuses to install without an up-to-date lockfile
+func (c bundleConfig) requiresLockfile() bool {
+	return c.Mode == bundleModeFrozen || c.Mode == bundleModeDeployment
+}
+
+// resolveGemfilePath returns the Gemfile to use: the given path, or BUNDLE_GEMFILE if no path is given.
+// A missing Gemfile falls back to gems.rb in the same directory.
+func resolveGemfilePath(gemfilePth string) string {
+	if gemfilePth == "" {
+		gemfilePth = os.Getenv("BUNDLE_GEMFILE")
+	}
+	if gemfilePth == "" {
+		return ""
+	}
+
+	if filepath.Base(gemfilePth) == "Gemfile" {
+		if exist, err := pathutil.IsPathExists(gemfilePth); err == nil && !exist {
+			gemsRbPth := filepath.Join(filepath.Dir(gemfilePth), "gems.rb")
+			if exist, err := pathutil.IsPathExists(gemsRbPth); err == nil && exist {
+				return gemsRbPth
+			}
+		}
+	}
+
+	return gemfilePth
+}
+
+// gemfileLockPath returns the lockfile bundler uses for the Gemfile: gems.locked for gems.rb, <Gemfile>.lock otherwise
+func gemfileLockPath(gemfilePth string) string {
+	if filepath.Base(gemfilePth) == "gems.rb" {
+		return filepath.Join(filepath.Dir(gemfilePth), "gems.locked")
+	}
+	return gemfilePth + ".lock"
+}
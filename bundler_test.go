@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_bundleConfig_envs(t *testing.T) {
+	gemfilePth := filepath.Join(t.TempDir(), "Fastfile.gems")
+	bundlePth, err := filepath.Abs("vendor/bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		config bundleConfig
+		want   []string
+	}{
+		{
+			name:   "default",
+			config: newBundleConfig("", "", bundleModeDefault),
+			want:   []string{"BUNDLE_GEMFILE=" + gemfilePth},
+		},
+		{
+			name:   "path, groups and frozen mode",
+			config: newBundleConfig("vendor/bundle", "development, test", bundleModeFrozen),
+			want: []string{
+				"BUNDLE_GEMFILE=" + gemfilePth,
+				"BUNDLE_PATH=" + bundlePth,
+				"BUNDLE_WITHOUT=development:test",
+				"BUNDLE_FROZEN=true",
+			},
+		},
+		{
+			name:   "deployment mode",
+			config: newBundleConfig("", "", bundleModeDeployment),
+			want:   []string{"BUNDLE_GEMFILE=" + gemfilePth, "BUNDLE_DEPLOYMENT=true"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.envs(gemfilePth); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("envs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_resolveGemfilePath(t *testing.T) {
+	gemsRbDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(gemsRbDir, "gems.rb"), []byte(`gem "fastlane"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	customGemfilePth := filepath.Join(t.TempDir(), "Gemfile.fastlane")
+
+	tests := []struct {
+		name          string
+		gemfilePth    string
+		bundleGemfile string
+		want          string
+	}{
+		{name: "gems.rb fallback", gemfilePth: filepath.Join(gemsRbDir, "Gemfile"), want: filepath.Join(gemsRbDir, "gems.rb")},
+		{name: "no fallback", gemfilePth: "testdata/Gemfile", want: "testdata/Gemfile"},
+		{name: "BUNDLE_GEMFILE", bundleGemfile: customGemfilePth, want: customGemfilePth},
+		{name: "input overrides BUNDLE_GEMFILE", gemfilePth: "testdata/Gemfile", bundleGemfile: customGemfilePth, want: "testdata/Gemfile"},
+		{name: "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("BUNDLE_GEMFILE", tt.bundleGemfile)
+			if got := resolveGemfilePath(tt.gemfilePth); got != tt.want {
+				t.Errorf("resolveGemfilePath() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_gemfileLockPath(t *testing.T) {
+	tests := map[string]string{
+		"ios/Gemfile":          "ios/Gemfile.lock",
+		"ios/gems.rb":          "ios/gems.locked",
+		"ios/Gemfile.fastlane": "ios/Gemfile.fastlane.lock",
+	}
+	for gemfilePth, want := range tests {
+		if got := gemfileLockPath(gemfilePth); got != want {
+			t.Errorf("gemfileLockPath(%s) = %s, want %s", gemfilePth, got, want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	cacheCompleteMarker = ".complete"
+	setupDurationEnvKey = "DELIVER_SETUP_DURATION_SECONDS"
+)
+
+// fastlaneCache stores fastlane installations (gem homes or bundle paths) between builds.
+// Entries are keyed by everything that affects the installed gems: the Ruby version,
+// the fastlane version and the Gemfile.lock content.
+type fastlaneCache struct {
+	dir string
+}
+
+// cacheEntry is a single fastlane installation in the cache
+type cacheEntry struct {
+	key string
+	dir string
+}
+
+func newFastlaneCache(dir string) *fastlaneCache {
+	if dir == "" {
+		return nil
+	}
+	return &fastlaneCache{dir: dir}
+}
+
+// entry returns the cache entry for the given key components, the entry is not necessarily populated
+func (c *fastlaneCache) entry(kind string, keyComponents ...string) (cacheEntry, error) {
+	absDir, err := filepath.Abs(c.dir)
+	if err != nil {
+		return cacheEntry{}, err
+	}
+
+	key := kind + ":" + strings.Join(keyComponents, ":")
+	hash := sha256.Sum256([]byte(key))
+
+	return cacheEntry{
+		key: key,
+		dir: filepath.Join(absDir, kind+"-"+hex.EncodeToString(hash[:])[:16]),
+	}, nil
+}
+
+// restore reports whether the entry was fully populated by a previous build
+func (e cacheEntry) restore() bool {
+	_, err := os.Stat(filepath.Join(e.dir, cacheCompleteMarker))
+	if err == nil {
+		log.Donef("fastlane cache hit (%s)", e.key)
+		return true
+	}
+
+	log.Printf("fastlane cache miss (%s)", e.key)
+	// Remove any leftovers of an interrupted installation
+	if err := os.RemoveAll(e.dir); err != nil {
+		log.Warnf("Failed to clean cache entry (%s): %s", e.dir, err)
+	}
+	return false
+}
+
+// save marks the entry as fully populated
+func (e cacheEntry) save() error {
+	if err := os.MkdirAll(e.dir, 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(e.dir, cacheCompleteMarker), []byte(time.Now().UTC().Format(time.RFC3339)), 0644); err != nil {
+		return err
+	}
+
+	log.Printf("fastlane installation saved to cache: %s", e.dir)
+	return nil
+}
+
+// gemHomeEnvs isolates gem installs (and the installed executables) into the cache entry
+func (e cacheEntry) gemHomeEnvs() []string {
+	return []string{
+		"GEM_HOME=" + e.dir,
+		"GEM_PATH=" + e.dir,
+		"PATH=" + filepath.Join(e.dir, "bin") + string(os.PathListSeparator) + os.Getenv("PATH"),
+	}
+}
+
+// bundlePathEnvs makes bundler install the bundle into the cache entry
+func (e cacheEntry) bundlePathEnvs() []string {
+	return []string{"BUNDLE_PATH=" + e.dir}
+}
+
+// rubyVersion returns the version and platform of the Ruby active in dir (for example: 3.2.2-arm64-darwin23)
+func rubyVersion(runner commandRunner, dir string) (string, error) {
+	out, err := runner.CombinedOutput(newCommandSpec("ruby", "-e", `print RUBY_VERSION, "-", RUBY_PLATFORM`).withDir(dir))
+	if err != nil {
+		return "", fmt.Errorf("failed to get Ruby version: %s, output: %s", err, out)
+	}
+	return out, nil
+}
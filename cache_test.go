@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_fastlaneCache_entry(t *testing.T) {
+	cache := newFastlaneCache(t.TempDir())
+
+	entry, err := cache.entry("bundle", "ruby=3.2.2-arm64-darwin23", "fastlane=2.219.0", "lock=abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	same, err := cache.entry("bundle", "ruby=3.2.2-arm64-darwin23", "fastlane=2.219.0", "lock=abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRuby, err := cache.entry("bundle", "ruby=3.3.0-arm64-darwin23", "fastlane=2.219.0", "lock=abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.dir != same.dir {
+		t.Errorf("entry() dir = %s, want %s", same.dir, entry.dir)
+	}
+	if entry.dir == otherRuby.dir {
+		t.Errorf("entry() returned the same dir for different Ruby versions: %s", entry.dir)
+	}
+
+	if entry.restore() {
+		t.Errorf("restore() = true for an empty cache")
+	}
+	if err := entry.save(); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+	if !same.restore() {
+		t.Errorf("restore() = false for a saved entry")
+	}
+	if otherRuby.restore() {
+		t.Errorf("restore() = true for a different key")
+	}
+}
+
+func Test_newFastlaneCache_disabled(t *testing.T) {
+	if cache := newFastlaneCache(""); cache != nil {
+		t.Errorf("newFastlaneCache() = %v, want nil", cache)
+	}
+}
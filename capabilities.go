@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-xcode/models"
+)
+
+// capability is an operation of the Step, and whether it is possible on this host
+type capability struct {
+	Operation string
+	Available bool
+	// Detail is the tool doing the operation, or the reason it is not possible
+	Detail string
+}
+
+// deliverTools are the tools running deliver found on the host
+type deliverTools struct {
+	// RubyPath and FastlanePath are empty if the tool is not found in the PATH
+	RubyPath     string
+	FastlanePath string
+}
+
+// probeDeliverTools looks for Ruby and fastlane in the PATH
+func probeDeliverTools(runner commandRunner) deliverTools {
+	var tools deliverTools
+	if pth, err := runner.LookPath("ruby"); err == nil {
+		tools.RubyPath = pth
+	} else {
+		log.Debugf("Ruby not found: %s", err)
+	}
+	if pth, err := runner.LookPath("fastlane"); err == nil {
+		tools.FastlanePath = pth
+	} else {
+		log.Debugf("fastlane not found: %s", err)
+	}
+	return tools
+}
+
+// hostCapabilities returns which operations are possible with the tools found on this host and the selected authentication
+func hostCapabilities(xcode *models.XcodebuildVersionModel, tools uploadTools, deliver deliverTools, hasAPIKey bool) []capability {
+	xcodeCapability := capability{Operation: "Xcode", Detail: "not found"}
+	if xcode != nil {
+		xcodeCapability = capability{Operation: "Xcode", Available: true, Detail: fmt.Sprintf("%s (%s)", xcode.Version, xcode.BuildVersion)}
+	}
+
+	altool := capability{Operation: "Upload with altool", Available: tools.XcodeMajorVersion >= 14 && tools.AltoolPath != ""}
+	switch {
+	case altool.Available:
+		altool.Detail = tools.AltoolPath
+	case !tools.hasXcode():
+		altool.Detail = "requires Xcode 14 or later"
+	case tools.XcodeMajorVersion < 14:
+		altool.Detail = fmt.Sprintf("requires Xcode 14 or later, the selected Xcode is %d", tools.XcodeMajorVersion)
+	default:
+		altool.Detail = "not found in the selected Xcode"
+	}
+
+	transporter := capability{Operation: "Upload with iTMSTransporter", Available: tools.hasTransporter()}
+	switch {
+	case tools.TransporterPath != "":
+		transporter.Detail = tools.TransporterPath
+	case transporter.Available:
+		transporter.Detail = fmt.Sprintf("bundled in Xcode %d", tools.XcodeMajorVersion)
+	default:
+		transporter.Detail = "requires the Transporter app"
+	}
+
+	api := capability{Operation: "Upload with the App Store Connect API", Available: hasAPIKey, Detail: "requires an API key"}
+	if hasAPIKey {
+		api.Detail = "API key"
+	}
+
+	// deliver manages metadata, screenshots and submissions through the App Store Connect API, it doesn't need Xcode.
+	// fastlane is installed in the setup if it is not found, that only needs Ruby.
+	deliverDetail := "requires Ruby and fastlane"
+	switch {
+	case deliver.RubyPath != "" && deliver.FastlanePath != "":
+		deliverDetail = "fastlane deliver, " + deliver.FastlanePath
+	case deliver.RubyPath != "":
+		deliverDetail = "fastlane deliver, fastlane is installed in the setup"
+	}
+
+	return []capability{
+		xcodeCapability,
+		altool,
+		transporter,
+		api,
+		{Operation: "Metadata and screenshots", Available: deliver.RubyPath != "", Detail: deliverDetail},
+		{Operation: "Submit for review", Available: deliver.RubyPath != "", Detail: deliverDetail},
+	}
+}
+
+func printCapabilities(capabilities []capability) {
+	for _, c := range capabilities {
+		available := "no"
+		if c.Available {
+			available = "yes"
+		}
+		log.Printf("%-38s %-3s  %s", c.Operation+":", available, c.Detail)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/go-xcode/models"
+)
+
+func Test_hostCapabilities(t *testing.T) {
+	xcode15 := &models.XcodebuildVersionModel{Version: "Xcode 15.0", BuildVersion: "15A240d", MajorVersion: 15}
+	xcode13 := &models.XcodebuildVersionModel{Version: "Xcode 13.4.1", BuildVersion: "13F100", MajorVersion: 13}
+
+	tests := []struct {
+		name      string
+		xcode     *models.XcodebuildVersionModel
+		tools     uploadTools
+		deliver   deliverTools
+		hasAPIKey bool
+		want      map[string]bool
+	}{
+		{
+			name:  "Xcode 15 with altool",
+			xcode: xcode15,
+			tools: uploadTools{XcodeMajorVersion: 15, AltoolPath: "/Xcode.app/usr/bin/altool"},
+			want: map[string]bool{
+				"Xcode":                                 true,
+				"Upload with altool":                    true,
+				"Upload with iTMSTransporter":           false,
+				"Upload with the App Store Connect API": false,
+			},
+		},
+		{
+			name:  "Xcode 13 bundles iTMSTransporter",
+			xcode: xcode13,
+			tools: uploadTools{XcodeMajorVersion: 13},
+			want: map[string]bool{
+				"Upload with altool":          false,
+				"Upload with iTMSTransporter": true,
+			},
+		},
+		{
+			name:      "Linux with an API key",
+			deliver:   deliverTools{RubyPath: "/usr/bin/ruby"},
+			hasAPIKey: true,
+			want: map[string]bool{
+				"Xcode":                                 false,
+				"Upload with altool":                    false,
+				"Upload with iTMSTransporter":           false,
+				"Upload with the App Store Connect API": true,
+				"Metadata and screenshots":              true,
+				"Submit for review":                     true,
+			},
+		},
+		{
+			name:      "Linux without Ruby",
+			hasAPIKey: true,
+			want: map[string]bool{
+				"Upload with the App Store Connect API": true,
+				"Metadata and screenshots":              false,
+				"Submit for review":                     false,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := map[string]capability{}
+			for _, c := range hostCapabilities(tt.xcode, tt.tools, tt.deliver, tt.hasAPIKey) {
+				if c.Detail == "" {
+					t.Errorf("%s has no detail", c.Operation)
+				}
+				got[c.Operation] = c
+			}
+
+			for operation, want := range tt.want {
+				c, ok := got[operation]
+				if !ok {
+					t.Errorf("%s is missing", operation)
+					continue
+				}
+				if c.Available != want {
+					t.Errorf("%s available = %v (%s), want %v", operation, c.Available, c.Detail, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"debug/macho"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	loadCmdCodeSignature = 0x1d
+	// code signatures are a few hundred KB, the CMS blob is a few KB
+	maxCodeSignatureSize = 16 * 1024 * 1024
+
+	csMagicEmbeddedSignature = 0xfade0cc0
+	csMagicBlobWrapper       = 0xfade0b01
+)
+
+// signatureInfo describes the certificate an artifact was signed with
+type signatureInfo struct {
+	TeamID             string `json:"team_id"`
+	CertificateName    string `json:"certificate_name"`
+	CertificateSHA1    string `json:"certificate_sha1"`
+	CertificateSHA256  string `json:"certificate_sha256"`
+	CertificateExpires string `json:"certificate_expires"`
+}
+
+func newSignatureInfo(cert *x509.Certificate) signatureInfo {
+	sha1Sum := sha1.Sum(cert.Raw)
+	sha256Sum := sha256.Sum256(cert.Raw)
+
+	info := signatureInfo{
+		CertificateName:    cert.Subject.CommonName,
+		CertificateSHA1:    hex.EncodeToString(sha1Sum[:]),
+		CertificateSHA256:  hex.EncodeToString(sha256Sum[:]),
+		CertificateExpires: cert.NotAfter.UTC().Format("2006-01-02T15:04:05Z"),
+	}
+	// Apple signing certificates store the Team ID as the organizational unit
+	if len(cert.Subject.OrganizationalUnit) > 0 {
+		info.TeamID = cert.Subject.OrganizationalUnit[0]
+	}
+	return info
+}
+
+// machoSigningCertificate returns the leaf certificate of a Mach-O executable's code signature.
+// Only the load commands and the LC_CODE_SIGNATURE region of the executable are read.
+func machoSigningCertificate(executable io.ReaderAt) (*x509.Certificate, error) {
+	if fat, err := macho.NewFatFile(executable); err == nil {
+		// Every architecture is signed with the same certificate
+		if len(fat.Arches) == 0 {
+			return nil, errors.New("universal binary contains no architectures")
+		}
+		arch := fat.Arches[0]
+		return machoSigningCertificate(io.NewSectionReader(executable, int64(arch.Offset), int64(arch.Size)))
+	}
+
+	f, err := macho.NewFile(executable)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, load := range f.Loads {
+		raw := load.Raw()
+		if len(raw) < 16 || f.ByteOrder.Uint32(raw) != loadCmdCodeSignature {
+			continue
+		}
+
+		offset := f.ByteOrder.Uint32(raw[8:])
+		size := f.ByteOrder.Uint32(raw[12:])
+		if size > maxCodeSignatureSize {
+			return nil, fmt.Errorf("code signature is too large (%d bytes)", size)
+		}
+		superBlob, err := io.ReadAll(io.NewSectionReader(executable, int64(offset), int64(size)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read code signature: %s", err)
+		}
+		if len(superBlob) != int(size) {
+			return nil, errors.New("code signature is out of bounds")
+		}
+		return codeSignatureCertificate(superBlob)
+	}
+
+	return nil, errors.New("executable is not signed")
+}
+
+// codeSignatureCertificate parses an embedded signature super blob, and returns the leaf certificate
+// of its CMS signature blob
+func codeSignatureCertificate(superBlob []byte) (*x509.Certificate, error) {
+	// Code signature blobs are always big endian
+	if len(superBlob) < 12 || binary.BigEndian.Uint32(superBlob) != csMagicEmbeddedSignature {
+		return nil, errors.New("invalid code signature")
+	}
+
+	count := binary.BigEndian.Uint32(superBlob[8:])
+	for i := uint32(0); i < count; i++ {
+		index := 12 + int(i)*8
+		if index+8 > len(superBlob) {
+			break
+		}
+
+		offset := binary.BigEndian.Uint32(superBlob[index+4:])
+		if int(offset)+8 > len(superBlob) || binary.BigEndian.Uint32(superBlob[offset:]) != csMagicBlobWrapper {
+			continue
+		}
+
+		length := binary.BigEndian.Uint32(superBlob[offset+4:])
+		if length <= 8 || int(offset)+int(length) > len(superBlob) {
+			return nil, errors.New("invalid code signature CMS blob")
+		}
+
+		certs, err := cmsCertificates(superBlob[offset+8 : offset+length])
+		if err != nil {
+			return nil, err
+		}
+		return leafCertificate(certs)
+	}
+
+	return nil, errors.New("code signature has no CMS signature (ad-hoc signed)")
+}
+
+// cmsCertificates returns the certificates embedded in a CMS (PKCS #7) SignedData structure
+func cmsCertificates(cms []byte) ([]*x509.Certificate, error) {
+	var contentInfo struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue `asn1:"explicit,tag:0"`
+	}
+	if _, err := asn1.Unmarshal(cms, &contentInfo); err != nil {
+		return nil, fmt.Errorf("failed to parse CMS content info: %s", err)
+	}
+
+	var signedData struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		ContentInfo      asn1.RawValue
+		Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	}
+	if _, err := asn1.Unmarshal(contentInfo.Content.Bytes, &signedData); err != nil {
+		return nil, fmt.Errorf("failed to parse CMS signed data: %s", err)
+	}
+	if len(signedData.Certificates.Bytes) == 0 {
+		return nil, errors.New("CMS signed data contains no certificates")
+	}
+
+	return x509.ParseCertificates(signedData.Certificates.Bytes)
+}
+
+// leafCertificate returns the certificate which is not the issuer of any other certificate in the chain
+func leafCertificate(certs []*x509.Certificate) (*x509.Certificate, error) {
+	for _, cert := range certs {
+		isIssuer := false
+		for _, other := range certs {
+			if other != cert && bytes.Equal(other.RawIssuer, cert.RawSubject) {
+				isIssuer = true
+				break
+			}
+		}
+		if !isIssuer {
+			return cert, nil
+		}
+	}
+	return nil, errors.New("no leaf certificate found")
+}
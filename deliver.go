@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// deliverInvocation is the fastlane deliver command line and environment of an app's deploy
+type deliverInvocation struct {
+	Args []string
+	Envs []string
+}
+
+// deliverArtifact is the binary to upload, Flag is --ipa or --pkg
+type deliverArtifact struct {
+	Flag string
+	Path string
+	// Uploaded is the app information of a binary already uploaded by the api backend,
+	// deliver only selects the build for the remaining actions then
+	Uploaded *artifactInfo
+}
+
+// newDeliverInvocation builds the deliver arguments and environment variables from the Step inputs,
+// the app target, the fastlane authentication params and the upload backend.
+// The artifact is optional (metadata only deploy). submitForReview is the Submit for Review input,
+// unless the deploy decided not to submit the build (an already uploaded build with the skip mode).
+func newDeliverInvocation(cfg Config, target appTarget, auth FastlaneParams, artifact *deliverArtifact, backend uploadBackend, submitForReview bool, options []string) deliverInvocation {
+	// fastlane doesn't ask for input on CI, the Step stops deliver if it still prompts (see interactivePrompts)
+	envs := []string{"CI=true"}
+	envs = append(envs, backend.uploadEnvs(cfg.ITMSParameters)...)
+
+	envKeys := make([]string, 0, len(auth.Envs))
+	for envKey := range auth.Envs {
+		envKeys = append(envKeys, envKey)
+	}
+	sort.Strings(envKeys)
+	for _, envKey := range envKeys {
+		envs = append(envs, fmt.Sprintf("%s=%s", envKey, auth.Envs[envKey]))
+	}
+
+	args := []string{"deliver"}
+	for _, arg := range auth.Args {
+		args = append(args, []string{arg.Key, arg.Value}...)
+	}
+
+	if target.AppID != "" {
+		args = append(args, "--app", target.AppID)
+	} else if target.BundleID != "" {
+		args = append(args, "--app_identifier", target.BundleID)
+	}
+
+	if target.TeamName != "" {
+		args = append(args, "--team_name", target.TeamName)
+	} else if target.TeamID != "" {
+		args = append(args, "--team_id", target.TeamID)
+	}
+
+	if artifact != nil && artifact.Uploaded != nil {
+		args = append(args, "--app_version", artifact.Uploaded.Version, "--build_number", artifact.Uploaded.BuildNumber, "--skip_binary_upload")
+	} else if artifact != nil {
+		args = append(args, artifact.Flag, artifact.Path)
+	}
+
+	if target.MetadataPath != "" {
+		args = append(args, "--metadata_path", target.MetadataPath)
+	}
+
+	if cfg.SkipScreenshots == "yes" {
+		args = append(args, "--skip_screenshots")
+	}
+
+	if cfg.SkipMetadata == "yes" {
+		args = append(args, "--skip_metadata")
+	}
+
+	if cfg.SkipAppVersionUpdate == "yes" {
+		args = append(args, "--skip_app_version_update")
+	}
+
+	args = append(args, "--force")
+
+	if submitForReview {
+		args = append(args, "--submit_for_review")
+	}
+
+	args = append(args, "--platform", cfg.Platform)
+
+	args = append(args, options...)
+
+	return deliverInvocation{Args: args, Envs: envs}
+}
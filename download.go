@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/filedownloader"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/retry"
+)
+
+const artifactDownloadRetries = 3
+
+// isRemoteArtifact reports whether an artifact input is an URL instead of a local path
+func isRemoteArtifact(pth string) bool {
+	return strings.HasPrefix(pth, "http://") || strings.HasPrefix(pth, "https://") || strings.HasPrefix(pth, "file://")
+}
+
+// fetchArtifact returns the local path of the artifact: http(s) URLs are downloaded into tmpDir,
+// file URLs are converted to local paths.
+// The downloaded file gets the extension of the artifact type (ext), presigned or redirecting URLs often don't end with it.
+func fetchArtifact(pth, ext, tmpDir string) (string, error) {
+	localPth := pth
+	if isRemoteArtifact(pth) {
+		u, err := url.Parse(pth)
+		if err != nil {
+			return "", fmt.Errorf("invalid artifact URL (%s)", redactURL(pth))
+		}
+
+		if u.Scheme == "file" {
+			localPth = u.Path
+		} else {
+			localPth = filepath.Join(tmpDir, downloadedArtifactName(u, ext))
+
+			log.Printf("Downloading artifact from %s", redactURL(pth))
+			startTime := time.Now()
+			if err := downloadArtifact(retry.NewHTTPClient().StandardClient(), u.String(), localPth); err != nil {
+				// the HTTP client and the downloader errors contain the URL
+				return "", fmt.Errorf("failed to download artifact: %s", strings.ReplaceAll(err.Error(), u.String(), redactURL(pth)))
+			}
+			log.Printf("Artifact downloaded in %s", time.Since(startTime).Round(time.Second))
+		}
+	}
+
+	return localPth, nil
+}
+
+// redactURL strips the credentials from an artifact URL: the userinfo, and the query and fragment,
+// which hold the tokens of presigned S3 and GCS URLs. Local paths are returned as is.
+func redactURL(pth string) string {
+	if !isRemoteArtifact(pth) {
+		return pth
+	}
+	u, err := url.Parse(pth)
+	if err != nil {
+		return "<invalid URL>"
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.ForceQuery = false
+	u.Fragment = ""
+	u.RawFragment = ""
+	return u.String()
+}
+
+// withRedactedURLs returns the config with the credentials stripped from the artifact URLs, for printing it
+func (cfg Config) withRedactedURLs() Config {
+	cfg.IpaPath = redactURL(cfg.IpaPath)
+	cfg.PkgPath = redactURL(cfg.PkgPath)
+	return cfg
+}
+
+// downloadedArtifactName returns the file name of the downloaded artifact, with the extension of the artifact type
+func downloadedArtifactName(u *url.URL, ext string) string {
+	name := sanitizedArtifactName(path.Base(u.Path))
+	if !strings.EqualFold(filepath.Ext(name), ext) {
+		name = strings.TrimSuffix(name, ".") + ext
+	}
+	return name
+}
+
+// downloadArtifact downloads source to destination, interrupted downloads are resumed using range requests
+// if the server supports them.
+func downloadArtifact(client filedownloader.HTTPClient, source, destination string) error {
+	return retry.Times(artifactDownloadRetries).Wait(5 * time.Second).Try(func(attempt uint) error {
+		if attempt > 0 {
+			log.Warnf("%d attempt failed", attempt)
+		}
+
+		info, err := os.Stat(destination)
+		if err != nil || info.Size() == 0 {
+			return filedownloader.New(client).Get(destination, source)
+		}
+
+		log.Printf("Resuming download from byte %d", info.Size())
+		return resumeDownload(client, source, destination, info.Size())
+	})
+}
+
+func resumeDownload(client filedownloader.HTTPClient, source, destination string, offset int64) error {
+	req, err := http.NewRequest(http.MethodGet, source, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %s", err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Errorf("Failed to close body, error: %s", err)
+		}
+	}()
+
+	flags := os.O_WRONLY | os.O_CREATE
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		// Appending a range other than the requested one would corrupt the file, starting over
+		if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != offset {
+			log.Warnf("The server returned a different range (%s) than requested (from byte %d), starting over", resp.Header.Get("Content-Range"), offset)
+			if err := os.Remove(destination); err != nil {
+				return err
+			}
+			return filedownloader.New(client).Get(destination, source)
+		}
+		flags |= os.O_APPEND
+	case http.StatusOK:
+		// Range requests are not supported, starting over
+		flags |= os.O_TRUNC
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The previous attempt downloaded the whole file, unless the local file doesn't match the size of the remote one
+		if total, ok := contentRangeTotal(resp.Header.Get("Content-Range")); ok && total == offset {
+			return nil
+		}
+		log.Warnf("The partially downloaded file doesn't match the remote file, starting over")
+		if err := os.Remove(destination); err != nil {
+			return err
+		}
+		return filedownloader.New(client).Get(destination, source)
+	default:
+		return fmt.Errorf("unable to download file from: %s. Status code: %d", source, resp.StatusCode)
+	}
+
+	f, err := os.OpenFile(destination, flags, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Errorf("Failed to close file, error: %s", err)
+		}
+	}()
+
+	_, err = io.Copy(f, resp.Body)
+	return err
+}
+
+// contentRangeStart returns the first byte position from the Content-Range header of a 206 response (bytes <start>-<end>/<length>)
+func contentRangeStart(contentRange string) (int64, bool) {
+	byteRange, found := strings.CutPrefix(strings.TrimSpace(contentRange), "bytes ")
+	if !found {
+		return 0, false
+	}
+	start, _, found := strings.Cut(byteRange, "-")
+	if !found {
+		return 0, false
+	}
+	position, err := strconv.ParseInt(strings.TrimSpace(start), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return position, true
+}
+
+// contentRangeTotal returns the complete length from the Content-Range header of a 416 response (bytes */<length>)
+func contentRangeTotal(contentRange string) (int64, bool) {
+	_, total, found := strings.Cut(contentRange, "/")
+	if !found || total == "*" {
+		return 0, false
+	}
+	length, err := strconv.ParseInt(strings.TrimSpace(total), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return length, true
+}
+
+func fileSHA256(pth string) (string, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Warnf("Failed to close file (%s): %s", pth, err)
+		}
+	}()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func verifySHA256(name, expected, actual string) error {
+	if !strings.EqualFold(actual, strings.TrimSpace(expected)) {
+		return fmt.Errorf("SHA-256 checksum mismatch for %s: expected %s, got %s", name, expected, actual)
+	}
+	return nil
+}
@@ -3,18 +3,17 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"os"
 	"path/filepath"
 
-	"github.com/bitrise-io/go-utils/pathutil"
 	"github.com/bitrise-io/go-xcode/appleauth"
 )
 
 // fastlaneAPIKey is used to serialize App Store Connect API Key into JSON for fastlane
 // see: https://docs.fastlane.tools/app-store-connect-api/#using-fastlane-api-key-json-file
+// Individual API keys have no issuer ID, fastlane authenticates with them when issuer_id is omitted.
 type fastlaneAPIKey struct {
 	KeyID      string `json:"key_id"`
-	IssuerID   string `json:"issuer_id"`
+	IssuerID   string `json:"issuer_id,omitempty"`
 	PrivateKey string `json:"key"`
 }
 
@@ -29,8 +28,9 @@ type FastlaneParams struct {
 	Args []Arg
 }
 
-// FastlaneAuthParams converts Apple credentials to Fastlane env vars and arguments
-func FastlaneAuthParams(authConfig appleauth.Credentials) (FastlaneParams, error) {
+// FastlaneAuthParams converts Apple credentials to Fastlane env vars and arguments,
+// generated files (API key JSON) are written into tmpDir
+func FastlaneAuthParams(authConfig appleauth.Credentials, tmpDir string) (FastlaneParams, error) {
 	envs := make(map[string]string)
 	var args []Arg
 	if authConfig.AppleID != nil {
@@ -68,12 +68,9 @@ func FastlaneAuthParams(authConfig appleauth.Credentials) (FastlaneParams, error
 			return FastlaneParams{}, fmt.Errorf("failed to marshal Fastane API Key configuration: %v", err)
 		}
 
-		tmpDir, err := pathutil.NormalizedOSTempDirPath("apiKey")
-		if err != nil {
-			return FastlaneParams{}, err
-		}
 		fastlaneAuthFile := filepath.Join(tmpDir, "api_key.json")
-		if err := os.WriteFile(fastlaneAuthFile, privateKey, os.ModePerm); err != nil {
+		// The file contains the private key, only the current user may read it
+		if err := writeSecretFile(fastlaneAuthFile, privateKey); err != nil {
 			return FastlaneParams{}, err
 		}
 
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/bitrise-io/go-xcode/appleauth"
+	"github.com/bitrise-io/go-xcode/devportalservice"
+)
+
+func TestFastlaneAuthParams_apiKeyJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		apiKey devportalservice.APIKeyConnection
+		want   string
+	}{
+		{
+			name:   "team key",
+			apiKey: devportalservice.APIKeyConnection{KeyID: "ABC123DEFG", IssuerID: "issuer", PrivateKey: "private key"},
+			want:   `{"key_id":"ABC123DEFG","issuer_id":"issuer","key":"private key"}`,
+		},
+		{
+			name:   "individual key",
+			apiKey: devportalservice.APIKeyConnection{KeyID: "ABC123DEFG", PrivateKey: "private key"},
+			want:   `{"key_id":"ABC123DEFG","key":"private key"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiKey := tt.apiKey
+			params, err := FastlaneAuthParams(appleauth.Credentials{APIKey: &apiKey}, t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(params.Args) == 0 || params.Args[0].Key != "--api_key_path" {
+				t.Fatalf("FastlaneAuthParams() args = %v, want --api_key_path first", params.Args)
+			}
+			content, err := os.ReadFile(params.Args[0].Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.want {
+				t.Errorf("API key JSON = %s, want %s", content, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const fastlaneVersionEnvKey = "DELIVER_FASTLANE_VERSION"
+
+// gemConstraint is a single RubyGems version constraint, for example: ~> 2.219
+type gemConstraint struct {
+	Operator string
+	Version  string
+}
+
+// gemRequirement is a list of constraints which all have to be satisfied, for example: >= 2.210, < 3
+type gemRequirement []gemConstraint
+
+var gemConstraintRegexp = regexp.MustCompile(`^\s*(=|!=|>=|<=|>|<|~>)?\s*([0-9][0-9A-Za-z.]*)\s*$`)
+
+// isGemRequirement reports whether the version input is a constraint rather than an exact version
+func isGemRequirement(version string) bool {
+	return strings.ContainsAny(version, "<>=~!,")
+}
+
+func parseGemRequirement(requirement string) (gemRequirement, error) {
+	var constraints gemRequirement
+	for _, part := range strings.Split(requirement, ",") {
+		match := gemConstraintRegexp.FindStringSubmatch(part)
+		if match == nil {
+			return nil, fmt.Errorf("invalid version constraint: %s", strings.TrimSpace(part))
+		}
+
+		operator := match[1]
+		if operator == "" {
+			operator = "="
+		}
+		constraints = append(constraints, gemConstraint{Operator: operator, Version: match[2]})
+	}
+	return constraints, nil
+}
+
+func (r gemRequirement) isSatisfiedBy(version string) bool {
+	for _, constraint := range r {
+		if !constraint.isSatisfiedBy(version) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c gemConstraint) isSatisfiedBy(version string) bool {
+	cmp := compareGemVersions(version, c.Version)
+	switch c.Operator {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case "~>":
+		// ~> 2.219 means >= 2.219 and < 3, ~> 2.219.1 means >= 2.219.1 and < 2.220
+		return cmp >= 0 && compareGemVersions(version, pessimisticUpperBound(c.Version)) < 0
+	}
+	return false
+}
+
+func pessimisticUpperBound(version string) string {
+	segments := gemVersionSegments(version)
+	// Prerelease segments are ignored
+	for i, segment := range segments {
+		if _, err := strconv.Atoi(segment); err != nil {
+			segments = segments[:i]
+			break
+		}
+	}
+	if len(segments) > 1 {
+		segments = segments[:len(segments)-1]
+	}
+
+	last, _ := strconv.Atoi(segments[len(segments)-1])
+	segments[len(segments)-1] = strconv.Itoa(last + 1)
+	return strings.Join(segments, ".")
+}
+
+var gemVersionSegmentRegexp = regexp.MustCompile(`[0-9]+|[A-Za-z]+`)
+
+func gemVersionSegments(version string) []string {
+	return gemVersionSegmentRegexp.FindAllString(version, -1)
+}
+
+// compareGemVersions compares versions the way Gem::Version does:
+// a version containing letters is a prerelease, which is lower than the release, for example 2.220.0.rc1 < 2.220.0
+func compareGemVersions(a, b string) int {
+	aSegments, bSegments := gemVersionSegments(a), gemVersionSegments(b)
+	for i := 0; i < len(aSegments) || i < len(bSegments); i++ {
+		aSegment, bSegment := "0", "0"
+		if i < len(aSegments) {
+			aSegment = aSegments[i]
+		}
+		if i < len(bSegments) {
+			bSegment = bSegments[i]
+		}
+
+		aNumber, aErr := strconv.Atoi(aSegment)
+		bNumber, bErr := strconv.Atoi(bSegment)
+		switch {
+		case aErr == nil && bErr == nil:
+			if aNumber != bNumber {
+				if aNumber < bNumber {
+					return -1
+				}
+				return 1
+			}
+		case aErr != nil && bErr != nil:
+			if cmp := strings.Compare(aSegment, bSegment); cmp != 0 {
+				return cmp
+			}
+		case aErr != nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+	return 0
+}
+
+// highestMatchingVersion returns the highest version satisfying the requirement, prereleases are only
+// considered if the requirement itself refers to a prerelease
+func highestMatchingVersion(requirement gemRequirement, versions []string) string {
+	allowPrerelease := false
+	for _, constraint := range requirement {
+		if isGemPrerelease(constraint.Version) {
+			allowPrerelease = true
+		}
+	}
+
+	highest := ""
+	for _, version := range versions {
+		if !allowPrerelease && isGemPrerelease(version) {
+			continue
+		}
+		if requirement.isSatisfiedBy(version) && (highest == "" || compareGemVersions(version, highest) > 0) {
+			highest = version
+		}
+	}
+	return highest
+}
+
+func isGemPrerelease(version string) bool {
+	return strings.IndexFunc(version, func(r rune) bool {
+		return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+	}) != -1
+}
+
+// parseGemListVersions returns the versions from the output of `gem list`, for example:
+// fastlane (2.219.0, 2.210.1 ruby, default: 2.200.0)
+func parseGemListVersions(gem, out string) []string {
+	prefix := gem + " ("
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, prefix) || !strings.HasSuffix(line, ")") {
+			continue
+		}
+
+		var versions []string
+		for _, item := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(line, prefix), ")"), ",") {
+			fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(item), "default:"))
+			if len(fields) > 0 {
+				versions = append(versions, fields[0])
+			}
+		}
+		return versions
+	}
+	return nil
+}
+
+func gemListVersions(runner commandRunner, gem string, remote bool) ([]string, error) {
+	args := []string{"list", gem, "--exact"}
+	if remote {
+		args = append(args, "--remote", "--all")
+	} else {
+		args = append(args, "--local")
+	}
+
+	out, err := runner.Output(newCommandSpec("gem", args...))
+	if err != nil {
+		return nil, fmt.Errorf("gem list failed, output: %s, error: %s", out, err)
+	}
+	return parseGemListVersions(gem, out), nil
+}
+
+// resolveGemRequirement resolves a version constraint to an exact version,
+// preferring the versions already installed, so that an install can be skipped
+func resolveGemRequirement(runner commandRunner, gem, requirement string) (version string, installed bool, err error) {
+	constraints, err := parseGemRequirement(requirement)
+	if err != nil {
+		return "", false, err
+	}
+
+	localVersions, err := gemListVersions(runner, gem, false)
+	if err != nil {
+		log.Warnf("Failed to list installed %s versions: %s", gem, err)
+	} else if version := highestMatchingVersion(constraints, localVersions); version != "" {
+		log.Printf("%s %s satisfies %s and is already installed", gem, version, requirement)
+		return version, true, nil
+	}
+
+	remoteVersions, err := gemListVersions(runner, gem, true)
+	if err != nil {
+		return "", false, err
+	}
+	version = highestMatchingVersion(constraints, remoteVersions)
+	if version == "" {
+		return "", false, fmt.Errorf("no %s version satisfies %s", gem, requirement)
+	}
+
+	log.Printf("%s %s is the highest version satisfying %s", gem, version, requirement)
+	return version, false, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_gemRequirement_isSatisfiedBy(t *testing.T) {
+	tests := []struct {
+		requirement string
+		version     string
+		want        bool
+	}{
+		{requirement: "~> 2.219", version: "2.219.0", want: true},
+		{requirement: "~> 2.219", version: "2.225.1", want: true},
+		{requirement: "~> 2.219", version: "3.0.0", want: false},
+		{requirement: "~> 2.219", version: "2.218.0", want: false},
+		{requirement: "~> 2.219.1", version: "2.219.5", want: true},
+		{requirement: "~> 2.219.1", version: "2.220.0", want: false},
+		{requirement: ">= 2.210, < 3", version: "2.219.0", want: true},
+		{requirement: ">= 2.210, < 3", version: "3.0.0", want: false},
+		{requirement: ">= 2.210, < 3", version: "2.209.1", want: false},
+		{requirement: "!= 2.215.0", version: "2.215.0", want: false},
+		{requirement: "= 2.219.0", version: "2.219.0", want: true},
+		{requirement: "<= 2.219", version: "2.219.0", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.requirement+" "+tt.version, func(t *testing.T) {
+			requirement, err := parseGemRequirement(tt.requirement)
+			if err != nil {
+				t.Fatalf("parseGemRequirement() error = %v", err)
+			}
+			if got := requirement.isSatisfiedBy(tt.version); got != tt.want {
+				t.Errorf("isSatisfiedBy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseGemRequirement_invalid(t *testing.T) {
+	for _, requirement := range []string{"~>", ">= 2.210, ", "=> 2.210", "latest"} {
+		if _, err := parseGemRequirement(requirement); err == nil {
+			t.Errorf("parseGemRequirement(%s) error = nil, want error", requirement)
+		}
+	}
+}
+
+func Test_isGemRequirement(t *testing.T) {
+	tests := map[string]bool{
+		"2.219.0":       false,
+		"latest-stable": false,
+		"latest":        false,
+		"~> 2.219":      true,
+		">= 2.210, < 3": true,
+	}
+	for version, want := range tests {
+		if got := isGemRequirement(version); got != want {
+			t.Errorf("isGemRequirement(%s) = %v, want %v", version, got, want)
+		}
+	}
+}
+
+func Test_compareGemVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "2.219.0", b: "2.219", want: 0},
+		{a: "2.219.1", b: "2.219.0", want: 1},
+		{a: "2.220.0.rc1", b: "2.220.0", want: -1},
+		{a: "2.220.0.rc2", b: "2.220.0.rc1", want: 1},
+		{a: "2.220.0.rc1", b: "2.219.0", want: 1},
+	}
+	for _, tt := range tests {
+		if got := compareGemVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareGemVersions(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func Test_highestMatchingVersion(t *testing.T) {
+	versions := []string{"2.210.1", "2.219.0", "2.225.0", "2.226.0.rc1", "3.0.0"}
+
+	tests := []struct {
+		requirement string
+		want        string
+	}{
+		{requirement: "~> 2.219", want: "2.225.0"},
+		{requirement: ">= 2.210, < 2.220", want: "2.219.0"},
+		{requirement: ">= 2.226.0.rc1", want: "3.0.0"},
+		{requirement: "~> 2.226.0.rc1", want: "2.226.0.rc1"},
+		{requirement: "~> 4.0", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.requirement, func(t *testing.T) {
+			requirement, err := parseGemRequirement(tt.requirement)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := highestMatchingVersion(requirement, versions); got != tt.want {
+				t.Errorf("highestMatchingVersion() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseGemListVersions(t *testing.T) {
+	out := `
+*** LOCAL GEMS ***
+
+fastlane (2.219.0, 2.210.1 ruby, default: 2.200.0)
+fastlane-plugin-versioning (0.5.1)`
+
+	want := []string{"2.219.0", "2.210.1", "2.200.0"}
+	if got := parseGemListVersions("fastlane", out); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseGemListVersions() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	uploadProgressLineRegexp    = regexp.MustCompile(`(?i)upload|transfer|progress|sent`)
+	uploadProgressPercentRegexp = regexp.MustCompile(`(\d{1,3}(?:\.\d+)?)\s?%`)
+	uploadProgressBytesRegexp   = regexp.MustCompile(`(?i)(\d+(?:\.\d+)?\s?[KMGT]?B)\s+(?:of|/)\s+(\d+(?:\.\d+)?\s?[KMGT]?B)`)
+)
+
+// parseUploadProgress returns the upload progress reported by an output line of the iTunes Transporter or altool,
+// for example "37%" or "37% (7.0 MB of 55.1 MB)", or an empty string if the line is not a progress report
+func parseUploadProgress(line string) string {
+	if !uploadProgressLineRegexp.MatchString(line) {
+		return ""
+	}
+
+	var progress []string
+	if match := uploadProgressPercentRegexp.FindStringSubmatch(line); match != nil {
+		progress = append(progress, match[1]+"%")
+	}
+	if match := uploadProgressBytesRegexp.FindStringSubmatch(line); match != nil {
+		bytes := fmt.Sprintf("%s of %s", match[1], match[2])
+		if len(progress) > 0 {
+			bytes = "(" + bytes + ")"
+		}
+		progress = append(progress, bytes)
+	}
+	return strings.Join(progress, " ")
+}
+
+// uploadMonitor passes the deliver output through, and prints a heartbeat line when the output has been silent
+// for the heartbeat interval, so that CI no-output timeouts are not hit during long uploads.
+// Heartbeat lines are only printed between complete output lines, and include the last progress reported by the upload tool.
+type uploadMonitor struct {
+	out          io.Writer
+	interval     time.Duration
+	artifactName string
+	artifactSize int64
+
+	mu          sync.Mutex
+	start       time.Time
+	lastOutput  time.Time
+	atLineStart bool
+	line        []byte
+	progress    string
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newUploadMonitor returns a monitor printing its heartbeat to out, the artifact is optional (metadata only deploy)
+func newUploadMonitor(out io.Writer, interval time.Duration, artifactName string, artifactSize int64) *uploadMonitor {
+	return &uploadMonitor{
+		out:          out,
+		interval:     interval,
+		artifactName: artifactName,
+		artifactSize: artifactSize,
+		atLineStart:  true,
+	}
+}
+
+// Start starts the heartbeat, a zero interval disables it
+func (m *uploadMonitor) Start() {
+	m.mu.Lock()
+	m.start = time.Now()
+	m.lastOutput = m.start
+	m.mu.Unlock()
+
+	if m.interval <= 0 {
+		return
+	}
+
+	m.stop, m.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(m.done)
+
+		timer := time.NewTimer(m.interval)
+		defer timer.Stop()
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-timer.C:
+				timer.Reset(m.beat())
+			}
+		}
+	}()
+}
+
+// Stop stops the heartbeat
+func (m *uploadMonitor) Stop() {
+	if m.stop == nil {
+		return
+	}
+	close(m.stop)
+	<-m.done
+	m.stop = nil
+}
+
+// Progress returns the last upload progress reported by the upload tool
+func (m *uploadMonitor) Progress() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.progress
+}
+
+// Writer returns a writer passing the output to w, to be used as the deliver command's stdout or stderr
+func (m *uploadMonitor) Writer(w io.Writer) io.Writer {
+	return monitoredWriter{monitor: m, out: w}
+}
+
+type monitoredWriter struct {
+	monitor *uploadMonitor
+	out     io.Writer
+}
+
+func (w monitoredWriter) Write(p []byte) (int, error) {
+	m := w.monitor
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, err := w.out.Write(p)
+	if n > 0 {
+		m.lastOutput = time.Now()
+		m.atLineStart = p[n-1] == '\n' || p[n-1] == '\r'
+		m.scan(p[:n])
+	}
+	return n, err
+}
+
+// scan picks up the progress from the complete lines of the output
+func (m *uploadMonitor) scan(p []byte) {
+	for _, b := range p {
+		if b != '\n' && b != '\r' {
+			// a progress report is a short line, don't keep long lines around
+			if len(m.line) < 1024 {
+				m.line = append(m.line, b)
+			}
+			continue
+		}
+		if progress := parseUploadProgress(string(m.line)); progress != "" {
+			m.progress = progress
+		}
+		m.line = m.line[:0]
+	}
+}
+
+// beat prints the heartbeat if the output has been silent for the interval, and returns the time until the next check
+func (m *uploadMonitor) beat() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if silent := now.Sub(m.lastOutput); silent < m.interval {
+		return m.interval - silent
+	}
+	if !m.atLineStart {
+		// the output stopped mid-line, for example at a prompt or a progress indicator, end it so the heartbeat is a line of its own
+		_, _ = fmt.Fprintln(m.out)
+		m.atLineStart = true
+	}
+
+	_, _ = fmt.Fprintln(m.out, m.heartbeatLine(now.Sub(m.start)))
+	m.lastOutput = now
+	return m.interval
+}
+
+func (m *uploadMonitor) heartbeatLine(elapsed time.Duration) string {
+	line := "Still running deliver"
+	if m.artifactName != "" {
+		line = fmt.Sprintf("Still uploading %s (%s to send)", m.artifactName, formatBytes(m.artifactSize))
+	}
+	line += fmt.Sprintf(", %s elapsed", elapsed.Round(time.Second))
+	if m.progress != "" {
+		line += ", last reported progress: " + m.progress
+	}
+	return line
+}
+
+// formatBytes returns a human readable size, for example 55.1 MB
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, exp := float64(size)/unit, 0
+	for value >= unit && exp < 3 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", value, "KMGT"[exp])
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_parseUploadProgress(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{line: "[2024-01-10 10:55:38 CET] <main>  INFO: Transferred 12.5 MB of 55.1 MB (22%)", want: "22% (12.5 MB of 55.1 MB)"},
+		{line: "[10:55:38]: Transporter output: upload progress: 37.50%", want: "37.50%"},
+		{line: "Progress: 80%", want: "80%"},
+		{line: "Sent 3 MB / 10 MB", want: "3 MB of 10 MB"},
+		{line: "[10:55:38]: Uploading binary to App Store Connect", want: ""},
+		{line: "[10:55:38]: Battery 100%", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			if got := parseUploadProgress(tt.line); got != tt.want {
+				t.Errorf("parseUploadProgress() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_formatBytes(t *testing.T) {
+	tests := []struct {
+		size int64
+		want string
+	}{
+		{size: 512, want: "512 B"},
+		{size: 1536, want: "1.5 KB"},
+		{size: 55*1024*1024 + 100*1024, want: "55.1 MB"},
+		{size: 3 * 1024 * 1024 * 1024, want: "3.0 GB"},
+	}
+	for _, tt := range tests {
+		if got := formatBytes(tt.size); got != tt.want {
+			t.Errorf("formatBytes(%d) = %s, want %s", tt.size, got, tt.want)
+		}
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe to write from the heartbeat goroutine
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func Test_uploadMonitor(t *testing.T) {
+	var out syncBuffer
+	monitor := newUploadMonitor(&out, 50*time.Millisecond, "app.ipa", 55*1024*1024)
+	stdout := monitor.Writer(&out)
+
+	monitor.Start()
+	if _, err := stdout.Write([]byte("[deliver] Transferred 12.5 MB of 55.1 MB (22%)\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	// a heartbeat after an unterminated line starts on a new line
+	if _, err := stdout.Write([]byte("[deliver] Waiting")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if _, err := stdout.Write([]byte(" for the upload\n")); err != nil {
+		t.Fatal(err)
+	}
+	monitor.Stop()
+
+	output := out.String()
+	if !strings.Contains(output, "Still uploading app.ipa (55.0 MB to send), ") || !strings.Contains(output, "last reported progress: 22% (12.5 MB of 55.1 MB)\n") {
+		t.Errorf("output = %q, want heartbeat lines with the artifact and the progress", output)
+	}
+	if !strings.Contains(output, "[deliver] Waiting\nStill uploading app.ipa") {
+		t.Errorf("output = %q, want a heartbeat on a new line after the unterminated Waiting line", output)
+	}
+	if strings.Contains(output, "[deliver] WaitingStill") {
+		t.Errorf("output = %q, want the heartbeat not appended to the Waiting line", output)
+	}
+	if got := monitor.Progress(); got != "22% (12.5 MB of 55.1 MB)" {
+		t.Errorf("Progress() = %s, want 22%% (12.5 MB of 55.1 MB)", got)
+	}
+}
+
+func Test_uploadMonitor_disabled(t *testing.T) {
+	var out syncBuffer
+	monitor := newUploadMonitor(&out, 0, "", 0)
+	stdout := monitor.Writer(&out)
+
+	monitor.Start()
+	if _, err := stdout.Write([]byte("[deliver] Uploading\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	monitor.Stop()
+
+	if got := out.String(); got != "[deliver] Uploading\n" {
+		t.Errorf("output = %q, want only the deliver output", got)
+	}
+}
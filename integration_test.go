@@ -0,0 +1,1062 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bitrise-steplib/steps-deploy-to-itunesconnect-deliver/appstoreconnect"
+	"github.com/bitrise-steplib/steps-deploy-to-itunesconnect-deliver/appstoreconnect/appstoreconnecttest"
+)
+
+// The test binary doubles as the Step and as fake versions of the tools it calls:
+// the integration tests put symlinks named after the tools on PATH, and TestMain dispatches on the invoked name.
+// Every fake tool call is recorded to invocations.jsonl in the fake tools directory, and answered from
+// responses.json (scripted by the test) or from the defaults below.
+const (
+	fakeToolsDirEnvKey = "DELIVER_TEST_FAKE_TOOLS_DIR"
+	// fakeAppStoreConnectURLEnvKey points the Step's App Store Connect API client to an appstoreconnecttest server
+	fakeAppStoreConnectURLEnvKey = "DELIVER_TEST_APP_STORE_CONNECT_URL"
+	stepExecutableName           = "deliver-step"
+)
+
+var fakeToolNames = []string{"fastlane", "bundle", "gem", "ruby", "xcodebuild", "xcrun", "envman"}
+
+// fakeInvocation is a recorded call of a fake tool
+type fakeInvocation struct {
+	Tool  string            `json:"tool"`
+	Args  []string          `json:"args"`
+	Env   map[string]string `json:"env"`
+	Dir   string            `json:"dir"`
+	Stdin string            `json:"stdin,omitempty"`
+	PID   int               `json:"pid"`
+}
+
+func (i fakeInvocation) commandLine() string {
+	return strings.Join(append([]string{i.Tool}, i.Args...), " ")
+}
+
+// fakeResponse is the output and exit code of the fake tool calls starting with Command.
+// The leading _version_ argument of fastlane and bundle is ignored when matching.
+type fakeResponse struct {
+	Command  string `json:"command"`
+	Output   string `json:"output"`
+	ExitCode int    `json:"exit_code"`
+	// Prompt is printed after the Output without a new line, like a prompt waiting for input
+	Prompt string `json:"prompt,omitempty"`
+	// Delay is how long the call takes after printing the Output, for example "10s"
+	Delay string `json:"delay,omitempty"`
+	// IgnoreSIGTERM makes the call ignore SIGTERM, like a hung process, so that only SIGKILL stops it
+	IgnoreSIGTERM bool `json:"ignore_sigterm,omitempty"`
+}
+
+var defaultFakeResponses = []fakeResponse{
+	{Command: "gem install", Output: "Successfully installed fastlane-2.219.0\n1 gem installed"},
+	{Command: "gem specification"},
+	{Command: "gem list"},
+	{Command: "bundle"},
+	{Command: "fastlane -v", Output: "fastlane installation at path:\n/usr/local/lib/ruby/gems/3.2.0/gems/fastlane-2.219.0/bin/fastlane\n-----------------------------\n[✔] 🚀 \nfastlane 2.219.0"},
+	{Command: "fastlane deliver", Output: "[deliver] Successfully uploaded the new binary to App Store Connect"},
+	{Command: "xcodebuild -version", Output: "Xcode 15.0\nBuild version 15A240d"},
+	{Command: "xcrun --find altool", Output: "/Applications/Xcode.app/Contents/Developer/usr/bin/altool"},
+	{Command: "xcrun --find iTMSTransporter", Output: "xcrun: error: unable to find utility \"iTMSTransporter\", not a developer tool or in PATH", ExitCode: 1},
+	{Command: "envman add"},
+}
+
+func TestMain(m *testing.M) {
+	name := filepath.Base(os.Args[0])
+	if name == stepExecutableName {
+		if url := os.Getenv(fakeAppStoreConnectURLEnvKey); url != "" {
+			appStoreConnectBaseURL = url
+			apiUploadOptions = appstoreconnect.BuildUploadOptions{RetryWait: time.Millisecond, PollInterval: time.Millisecond}
+		}
+		main()
+		os.Exit(0)
+	}
+	for _, tool := range fakeToolNames {
+		if name == tool {
+			os.Exit(runFakeTool(tool, os.Args[1:]))
+		}
+	}
+
+	code := m.Run()
+	tempFiles.Cleanup()
+	os.Exit(code)
+}
+
+func runFakeTool(tool string, args []string) int {
+	dir := os.Getenv(fakeToolsDirEnvKey)
+	if dir == "" {
+		fmt.Fprintf(os.Stderr, "fake %s: %s is not set\n", tool, fakeToolsDirEnvKey)
+		return 127
+	}
+
+	invocation := fakeInvocation{Tool: tool, Args: args, Env: map[string]string{}}
+	for _, env := range os.Environ() {
+		key, value, _ := strings.Cut(env, "=")
+		invocation.Env[key] = value
+	}
+	invocation.Dir, _ = os.Getwd()
+	invocation.PID = os.Getpid()
+	if tool == "envman" {
+		stdin, _ := io.ReadAll(os.Stdin)
+		invocation.Stdin = string(stdin)
+	}
+
+	if err := recordFakeInvocation(dir, invocation); err != nil {
+		fmt.Fprintf(os.Stderr, "fake %s: %s\n", tool, err)
+		return 127
+	}
+
+	// bundle exec runs the command in the bundle's environment, answer it as the command itself
+	if tool == "bundle" {
+		if execArgs := stripVersionArg(args); len(execArgs) > 1 && execArgs[0] == "exec" {
+			return runFakeTool(execArgs[1], execArgs[2:])
+		}
+	}
+
+	if tool == "ruby" && len(args) > 0 && args[0] == "-e" {
+		fmt.Printf("3.2.2\n%s", filepath.Join(dir, "bin", "ruby"))
+		return 0
+	}
+
+	var scripted []fakeResponse
+	if content, err := os.ReadFile(filepath.Join(dir, "responses.json")); err == nil {
+		if err := json.Unmarshal(content, &scripted); err != nil {
+			fmt.Fprintf(os.Stderr, "fake %s: invalid responses.json: %s\n", tool, err)
+			return 127
+		}
+	}
+
+	commandLine := strings.Join(append([]string{tool}, stripVersionArg(args)...), " ")
+	response, ok := matchFakeResponse(scripted, commandLine)
+	if !ok {
+		response, ok = matchFakeResponse(defaultFakeResponses, commandLine)
+	}
+	if !ok {
+		fmt.Fprintf(os.Stderr, "fake %s: unexpected command: %s\n", tool, commandLine)
+		return 127
+	}
+
+	if response.Output != "" {
+		fmt.Println(response.Output)
+	}
+	if response.Prompt != "" {
+		fmt.Print(response.Prompt)
+	}
+
+	if response.IgnoreSIGTERM {
+		signal.Ignore(syscall.SIGTERM)
+	}
+	if response.Delay != "" {
+		delay, err := time.ParseDuration(response.Delay)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fake %s: invalid delay: %s\n", tool, err)
+			return 127
+		}
+		time.Sleep(delay)
+	}
+	return response.ExitCode
+}
+
+var versionArgRegexp = regexp.MustCompile(`^_\d+(\.\d+)*\S*_$`)
+
+func stripVersionArg(args []string) []string {
+	if len(args) > 0 && versionArgRegexp.MatchString(args[0]) {
+		return args[1:]
+	}
+	return args
+}
+
+// matchFakeResponse returns the response with the longest Command prefix of the command line
+func matchFakeResponse(responses []fakeResponse, commandLine string) (fakeResponse, bool) {
+	var match fakeResponse
+	found := false
+	for _, response := range responses {
+		if commandLine != response.Command && !strings.HasPrefix(commandLine, response.Command+" ") {
+			continue
+		}
+		if !found || len(response.Command) > len(match.Command) {
+			match, found = response, true
+		}
+	}
+	return match, found
+}
+
+func recordFakeInvocation(dir string, invocation fakeInvocation) error {
+	content, err := json.Marshal(invocation)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, "invocations.jsonl"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(content, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// stepTest runs the Step binary in a temporary directory, with only the fake tools on PATH
+type stepTest struct {
+	t         *testing.T
+	dir       string
+	toolsDir  string
+	inputs    map[string]string
+	responses []fakeResponse
+}
+
+// stepRun is the outcome of a Step run
+type stepRun struct {
+	ExitCode    int
+	Output      string
+	Invocations []fakeInvocation
+	// Outputs are the environment variables exported with envman
+	Outputs map[string]string
+}
+
+func newStepTest(t *testing.T) *stepTest {
+	dir := t.TempDir()
+	toolsDir := filepath.Join(dir, "fake-tools")
+	binDir := filepath.Join(toolsDir, "bin")
+	for _, d := range []string{binDir, filepath.Join(dir, "home"), filepath.Join(dir, "tmp"), filepath.Join(dir, "deploy")} {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testBinary, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range append([]string{stepExecutableName}, fakeToolNames...) {
+		if err := os.Symlink(testBinary, filepath.Join(binDir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeTestFile(t, filepath.Join(dir, "app.ipa"), "fake ipa")
+
+	return &stepTest{
+		t:        t,
+		dir:      dir,
+		toolsDir: toolsDir,
+		// the step.yml defaults, with Apple ID authentication from the inputs
+		inputs: map[string]string{
+			"connection":               "off",
+			"itunescon_user":           "user@example.com",
+			"password":                 "password",
+			"app_password":             "abcd-efgh-ijkl-mnop",
+			"api_key_type":             "team",
+			"ipa_path":                 filepath.Join(dir, "app.ipa"),
+			"platform":                 "ios",
+			"bundle_id":                "com.example.app",
+			"submit_for_review":        "no",
+			"skip_metadata":            "yes",
+			"skip_screenshots":         "yes",
+			"skip_app_version_update":  "no",
+			"gemfile_path":             "./Gemfile",
+			"bundle_install_mode":      "default",
+			"fastlane_version":         "latest-stable",
+			"upload_backend":           "auto",
+			"if_already_uploaded":      "fail",
+			"setup_timeout":            "",
+			"upload_timeout":           "",
+			"processing_timeout":       "",
+			"termination_grace_period": "10s",
+			"heartbeat_interval":       "1m",
+			"verbose_log":              "no",
+		},
+	}
+}
+
+// respond scripts the fake tool calls starting with command
+func (s *stepTest) respond(command, output string, exitCode int) *stepTest {
+	s.responses = append(s.responses, fakeResponse{Command: command, Output: output, ExitCode: exitCode})
+	return s
+}
+
+// hang makes the fake tool calls starting with command take the given time
+func (s *stepTest) hang(command string, delay time.Duration) *stepTest {
+	s.responses = append(s.responses, fakeResponse{Command: command, Delay: delay.String()})
+	return s
+}
+
+// hangIgnoringSIGTERM makes the fake tool calls starting with command take the given time, even if they receive SIGTERM
+func (s *stepTest) hangIgnoringSIGTERM(command string, delay time.Duration) *stepTest {
+	s.responses = append(s.responses, fakeResponse{Command: command, Delay: delay.String(), IgnoreSIGTERM: true})
+	return s
+}
+
+func (s *stepTest) run() stepRun {
+	s.t.Helper()
+	return s.runInterrupted("", nil)
+}
+
+// runInterrupted runs the Step, and sends sig to it once the fake tool call starting with command is recorded
+func (s *stepTest) runInterrupted(command string, sig os.Signal) stepRun {
+	s.t.Helper()
+
+	content, err := json.Marshal(s.responses)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	writeTestFile(s.t, filepath.Join(s.toolsDir, "responses.json"), string(content))
+	_ = os.Remove(filepath.Join(s.toolsDir, "invocations.jsonl"))
+
+	env := []string{
+		"PATH=" + filepath.Join(s.toolsDir, "bin"),
+		"HOME=" + filepath.Join(s.dir, "home"),
+		"TMPDIR=" + filepath.Join(s.dir, "tmp"),
+		"BITRISE_DEPLOY_DIR=" + filepath.Join(s.dir, "deploy"),
+		fakeToolsDirEnvKey + "=" + s.toolsDir,
+	}
+	keys := make([]string, 0, len(s.inputs))
+	for key := range s.inputs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		env = append(env, key+"="+s.inputs[key])
+	}
+
+	// The output is written to a file, not a pipe: tool calls outliving an interrupted Step would keep a pipe open
+	outputPth := filepath.Join(s.toolsDir, "output.log")
+	output, err := os.Create(outputPth)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer func() {
+		_ = output.Close()
+	}()
+
+	cmd := exec.Command(filepath.Join(s.toolsDir, "bin", stepExecutableName))
+	cmd.Dir = s.dir
+	cmd.Env = env
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if err := cmd.Start(); err != nil {
+		s.t.Fatalf("failed to run the Step: %s", err)
+	}
+
+	if sig != nil {
+		s.waitForInvocation(command)
+		if err := cmd.Process.Signal(sig); err != nil {
+			s.t.Fatal(err)
+		}
+	}
+	err = cmd.Wait()
+
+	out, readErr := os.ReadFile(outputPth)
+	if readErr != nil {
+		s.t.Fatal(readErr)
+	}
+
+	run := stepRun{Output: string(out), Outputs: map[string]string{}}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		run.ExitCode = exitErr.ExitCode()
+	} else if err != nil {
+		s.t.Fatalf("failed to run the Step: %s", err)
+	}
+
+	run.Invocations = s.invocations()
+	for _, invocation := range run.Invocations {
+		if invocation.Tool == "envman" && len(invocation.Args) == 3 && invocation.Args[1] == "--key" {
+			run.Outputs[invocation.Args[2]] = invocation.Stdin
+		}
+	}
+	return run
+}
+
+func (s *stepTest) waitForInvocation(command string) {
+	s.t.Helper()
+
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(10 * time.Millisecond) {
+		if _, ok := (stepRun{Invocations: s.invocations()}).lastInvocation(command); ok {
+			return
+		}
+	}
+	s.t.Fatalf("%s was not called in 10 seconds", command)
+}
+
+// tempFiles returns the files and directories left in the Step's TMPDIR
+func (s *stepTest) tempFiles() []string {
+	s.t.Helper()
+
+	var pths []string
+	tmpDir := filepath.Join(s.dir, "tmp")
+	if err := filepath.Walk(tmpDir, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if pth != tmpDir {
+			pths = append(pths, pth)
+		}
+		return nil
+	}); err != nil {
+		s.t.Fatal(err)
+	}
+	return pths
+}
+
+func (s *stepTest) invocations() []fakeInvocation {
+	f, err := os.Open(filepath.Join(s.toolsDir, "invocations.jsonl"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		s.t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var invocations []fakeInvocation
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+	for scanner.Scan() {
+		var invocation fakeInvocation
+		if err := json.Unmarshal(scanner.Bytes(), &invocation); err != nil {
+			s.t.Fatal(err)
+		}
+		invocations = append(invocations, invocation)
+	}
+	if err := scanner.Err(); err != nil {
+		s.t.Fatal(err)
+	}
+	return invocations
+}
+
+// lastInvocation returns the last recorded call starting with the command, for example "fastlane deliver"
+func (r stepRun) lastInvocation(command string) (fakeInvocation, bool) {
+	for i := len(r.Invocations) - 1; i >= 0; i-- {
+		invocation := r.Invocations[i]
+		commandLine := strings.Join(append([]string{invocation.Tool}, stripVersionArg(invocation.Args)...), " ")
+		if commandLine == command || strings.HasPrefix(commandLine, command+" ") {
+			return invocation, true
+		}
+	}
+	return fakeInvocation{}, false
+}
+
+func (r stepRun) commandLines() []string {
+	var lines []string
+	for _, invocation := range r.Invocations {
+		lines = append(lines, invocation.commandLine())
+	}
+	return lines
+}
+
+// processRunning reports whether the process is running, exited processes not yet reaped by their parent are not
+func processRunning(pid int) bool {
+	if err := syscall.Kill(pid, 0); err != nil {
+		return false
+	}
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return true
+	}
+	// the state follows the parenthesized command name: pid (comm) state ...
+	fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+	return len(fields) == 0 || fields[0] != "Z"
+}
+
+func argValue(args []string, flag string) string {
+	if i := indexOf(args, flag); i != -1 && i+1 < len(args) {
+		return args[i+1]
+	}
+	return ""
+}
+
+func Test_step_deploysIpa(t *testing.T) {
+	s := newStepTest(t)
+	run := s.run()
+
+	if run.ExitCode != 0 {
+		t.Fatalf("exit code = %d, want 0, output:\n%s", run.ExitCode, run.Output)
+	}
+
+	wantCommands := []string{
+		"ruby -e",
+		"gem specification fastlane required_ruby_version --remote",
+		"gem install fastlane --no-document",
+		"fastlane -v",
+		"gem list ^fastlane-plugin-",
+		"envman add --key " + fastlaneVersionEnvKey,
+		"envman add --key " + setupDurationEnvKey,
+		"xcodebuild -version",
+		"fastlane deliver",
+	}
+	for _, command := range wantCommands {
+		if _, ok := run.lastInvocation(command); !ok {
+			t.Errorf("%s was not called, commands:\n%s", command, strings.Join(run.commandLines(), "\n"))
+		}
+	}
+
+	deliver, _ := run.lastInvocation("fastlane deliver")
+	if got := argValue(deliver.Args, "--username"); got != "user@example.com" {
+		t.Errorf("--username = %s, want user@example.com", got)
+	}
+	if got := argValue(deliver.Args, "--app_identifier"); got != "com.example.app" {
+		t.Errorf("--app_identifier = %s, want com.example.app", got)
+	}
+	if got := argValue(deliver.Args, "--ipa"); got != s.inputs["ipa_path"] {
+		t.Errorf("--ipa = %s, want %s", got, s.inputs["ipa_path"])
+	}
+	if got := deliver.Env["FASTLANE_APPLE_APPLICATION_SPECIFIC_PASSWORD"]; got != "abcd-efgh-ijkl-mnop" {
+		t.Errorf("FASTLANE_APPLE_APPLICATION_SPECIFIC_PASSWORD = %s, want the app_password input", got)
+	}
+	if _, ok := deliver.Env["FASTLANE_PASSWORD"]; ok {
+		t.Errorf("FASTLANE_PASSWORD is set for deliver")
+	}
+	// the temporary directory can be behind a symlink, for example /var -> /private/var on macOS
+	if wantDir, err := filepath.EvalSymlinks(s.dir); err != nil {
+		t.Fatal(err)
+	} else if deliver.Dir != wantDir {
+		t.Errorf("deliver working directory = %s, want %s", deliver.Dir, wantDir)
+	}
+
+	if got := run.Outputs[fastlaneVersionEnvKey]; got != "2.219.0" {
+		t.Errorf("%s = %s, want 2.219.0", fastlaneVersionEnvKey, got)
+	}
+	if got := run.Outputs[provenanceReportPthEnvKey]; filepath.Dir(got) != filepath.Join(s.dir, "deploy") {
+		t.Errorf("%s = %s, want a report in the deploy dir", provenanceReportPthEnvKey, got)
+	}
+	if !strings.Contains(run.Output, "Success") {
+		t.Errorf("output does not contain Success:\n%s", run.Output)
+	}
+}
+
+func Test_step_deliverFailure(t *testing.T) {
+	s := newStepTest(t)
+	s.respond("fastlane deliver", "[!] The provided entity includes an attribute with a value that has already been used", 1)
+	run := s.run()
+
+	if run.ExitCode != 1 {
+		t.Fatalf("exit code = %d, want 1, output:\n%s", run.ExitCode, run.Output)
+	}
+	if !strings.Contains(run.Output, "already been used") {
+		t.Errorf("output does not contain the deliver error:\n%s", run.Output)
+	}
+	if !strings.Contains(run.Output, "Deploy failed") {
+		t.Errorf("output does not contain the failure:\n%s", run.Output)
+	}
+
+	var results []deployResult
+	if err := json.Unmarshal([]byte(run.Outputs[deployResultsEnvKey]), &results); err != nil {
+		t.Fatalf("invalid %s: %s", deployResultsEnvKey, err)
+	}
+	if len(results) != 1 || results[0].Success {
+		t.Errorf("%s = %+v, want a single failed result", deployResultsEnvKey, results)
+	}
+}
+
+func Test_step_bundledFastlane(t *testing.T) {
+	s := newStepTest(t)
+	s.inputs["fastlane_version"] = ""
+	s.inputs["bundle_path"] = "vendor/bundle"
+	writeTestFile(t, filepath.Join(s.dir, "Gemfile"), "source \"https://rubygems.org\"\ngem \"fastlane\"\n")
+	writeTestFile(t, filepath.Join(s.dir, "Gemfile.lock"), `GEM
+  remote: https://rubygems.org/
+  specs:
+    fastlane (2.219.0)
+
+PLATFORMS
+  ruby
+
+DEPENDENCIES
+  fastlane
+
+BUNDLED WITH
+   2.4.10
+`)
+	run := s.run()
+
+	if run.ExitCode != 0 {
+		t.Fatalf("exit code = %d, want 0, output:\n%s", run.ExitCode, run.Output)
+	}
+
+	if _, ok := run.lastInvocation("gem install bundler --force --no-document --version 2.4.10"); !ok {
+		t.Errorf("bundler 2.4.10 was not installed, commands:\n%s", strings.Join(run.commandLines(), "\n"))
+	}
+	install, ok := run.lastInvocation("bundle install")
+	if !ok {
+		t.Fatalf("bundle install was not called, commands:\n%s", strings.Join(run.commandLines(), "\n"))
+	}
+	if got := install.Env["BUNDLE_PATH"]; got != filepath.Join(s.dir, "vendor", "bundle") {
+		t.Errorf("bundle install BUNDLE_PATH = %s, want %s", got, filepath.Join(s.dir, "vendor", "bundle"))
+	}
+
+	deliver, ok := run.lastInvocation("bundle exec fastlane deliver")
+	if !ok {
+		t.Fatalf("fastlane was not called with bundle exec, commands:\n%s", strings.Join(run.commandLines(), "\n"))
+	}
+	if got := deliver.Env["BUNDLE_GEMFILE"]; got != filepath.Join(s.dir, "Gemfile") {
+		t.Errorf("deliver BUNDLE_GEMFILE = %s, want %s", got, filepath.Join(s.dir, "Gemfile"))
+	}
+	if got := deliver.Args[0]; got != "_2.4.10_" {
+		t.Errorf("bundle version argument = %s, want _2.4.10_", got)
+	}
+}
+
+func Test_step_missingInput(t *testing.T) {
+	s := newStepTest(t)
+	delete(s.inputs, "bundle_id")
+	run := s.run()
+
+	if run.ExitCode != 1 {
+		t.Fatalf("exit code = %d, want 1, output:\n%s", run.ExitCode, run.Output)
+	}
+	if !strings.Contains(run.Output, "no AppID or BundleID parameter specified") {
+		t.Errorf("output does not contain the input error:\n%s", run.Output)
+	}
+	if len(run.Invocations) != 0 {
+		t.Errorf("tools called before the input validation: %v", run.commandLines())
+	}
+}
+
+func Test_step_apiKeyFromEnv(t *testing.T) {
+	s := newStepTest(t)
+	for _, key := range []string{"itunescon_user", "password", "app_password"} {
+		delete(s.inputs, key)
+	}
+	s.inputs["connection"] = "api_key_env"
+	s.inputs[ascKeyIDEnvKey] = "ABC123DEFG"
+	s.inputs[ascIssuerIDEnvKey] = "69a6de70-03db-47e3-e053-5b8c7c11a4d1"
+	s.inputs[ascKeyContentEnvKey] = base64.StdEncoding.EncodeToString([]byte(testAPIPrivateKey(t)))
+	run := s.run()
+
+	if run.ExitCode != 0 {
+		t.Fatalf("exit code = %d, want 0, output:\n%s", run.ExitCode, run.Output)
+	}
+	if !strings.Contains(run.Output, "API key environment variables found") {
+		t.Errorf("output does not contain the selected authentication source:\n%s", run.Output)
+	}
+
+	deliver, _ := run.lastInvocation("fastlane deliver")
+	if got := argValue(deliver.Args, "--api_key_path"); filepath.Base(got) != "api_key.json" {
+		t.Errorf("--api_key_path = %s, want the generated api_key.json", got)
+	}
+	if got := argValue(deliver.Args, "--username"); got != "" {
+		t.Errorf("--username = %s, want none", got)
+	}
+}
+
+func Test_step_removesTemporaryFiles(t *testing.T) {
+	tests := []struct {
+		name         string
+		setup        func(s *stepTest)
+		interruptAt  string
+		signal       os.Signal
+		wantExitCode int
+	}{
+		{
+			name:         "success",
+			setup:        func(s *stepTest) {},
+			wantExitCode: 0,
+		},
+		{
+			name:         "deliver failure",
+			setup:        func(s *stepTest) { s.respond("fastlane deliver", "[!] Upload failed", 1) },
+			wantExitCode: 1,
+		},
+		{
+			name: "fail() after setup",
+			setup: func(s *stepTest) {
+				if err := os.MkdirAll(filepath.Join(s.dir, "fastlane"), 0700); err != nil {
+					t.Fatal(err)
+				}
+				writeTestFile(t, filepath.Join(s.dir, "fastlane", "Pluginfile"), "gem 'fastlane-plugin-versioning'\n")
+				s.inputs["options"] = "--app_review_information '{"
+			},
+			wantExitCode: 1,
+		},
+		{
+			name:         "SIGTERM during deliver",
+			setup:        func(s *stepTest) { s.hang("fastlane deliver", 2*time.Second) },
+			interruptAt:  "fastlane deliver",
+			signal:       syscall.SIGTERM,
+			wantExitCode: 128 + int(syscall.SIGTERM),
+		},
+		{
+			name:         "SIGINT during deliver",
+			setup:        func(s *stepTest) { s.hang("fastlane deliver", 2*time.Second) },
+			interruptAt:  "fastlane deliver",
+			signal:       syscall.SIGINT,
+			wantExitCode: 128 + int(syscall.SIGINT),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStepTest(t)
+			for _, key := range []string{"itunescon_user", "password", "app_password"} {
+				delete(s.inputs, key)
+			}
+			s.inputs["connection"] = "api_key_env"
+			s.inputs[ascKeyIDEnvKey] = "ABC123DEFG"
+			s.inputs[ascIssuerIDEnvKey] = "69a6de70-03db-47e3-e053-5b8c7c11a4d1"
+			s.inputs[ascKeyContentEnvKey] = base64.StdEncoding.EncodeToString([]byte(testAPIPrivateKey(t)))
+			tt.setup(s)
+
+			run := s.runInterrupted(tt.interruptAt, tt.signal)
+
+			if run.ExitCode != tt.wantExitCode {
+				t.Errorf("exit code = %d, want %d, output:\n%s", run.ExitCode, tt.wantExitCode, run.Output)
+			}
+			// the generated files were created in the Step's TMPDIR, so their removal is checked below
+			tmpDir := filepath.Join(s.dir, "tmp") + string(os.PathSeparator)
+			if deliver, ok := run.lastInvocation("fastlane deliver"); ok && !strings.HasPrefix(argValue(deliver.Args, "--api_key_path"), tmpDir) {
+				t.Errorf("--api_key_path = %s, want a file in %s", argValue(deliver.Args, "--api_key_path"), tmpDir)
+			}
+			if version, ok := run.lastInvocation("fastlane -v"); ok && tt.name == "fail() after setup" && !strings.HasPrefix(version.Env["BUNDLE_GEMFILE"], tmpDir) {
+				t.Errorf("BUNDLE_GEMFILE = %s, want the plugin Gemfile in %s", version.Env["BUNDLE_GEMFILE"], tmpDir)
+			}
+			if left := s.tempFiles(); len(left) > 0 {
+				t.Errorf("temporary files left behind:\n%s", strings.Join(left, "\n"))
+			}
+		})
+	}
+}
+
+func Test_step_timeouts(t *testing.T) {
+	tests := []struct {
+		name         string
+		setup        func(s *stepTest)
+		wantTimedOut string
+	}{
+		{
+			name: "setup timeout",
+			setup: func(s *stepTest) {
+				s.inputs["setup_timeout"] = "1s"
+				s.hang("gem install", time.Minute)
+			},
+			wantTimedOut: "gem install",
+		},
+		{
+			name: "upload timeout",
+			setup: func(s *stepTest) {
+				s.inputs["upload_timeout"] = "1s"
+				s.hang("fastlane deliver", time.Minute)
+			},
+			wantTimedOut: "fastlane deliver",
+		},
+		{
+			name: "processing timeout is added to the upload timeout when submitting for review",
+			setup: func(s *stepTest) {
+				s.inputs["submit_for_review"] = "yes"
+				s.inputs["upload_timeout"] = "1s"
+				s.inputs["processing_timeout"] = "1s"
+				s.hang("fastlane deliver", time.Minute)
+			},
+			wantTimedOut: "fastlane deliver",
+		},
+		{
+			name: "Step timeout",
+			setup: func(s *stepTest) {
+				s.inputs["timeout"] = "2s"
+				s.hang("fastlane deliver", time.Minute)
+			},
+			wantTimedOut: "fastlane deliver",
+		},
+		{
+			name: "command ignoring SIGTERM is killed after the grace period",
+			setup: func(s *stepTest) {
+				s.inputs["upload_timeout"] = "1s"
+				s.inputs["termination_grace_period"] = "1s"
+				s.hangIgnoringSIGTERM("fastlane deliver", time.Minute)
+			},
+			wantTimedOut: "fastlane deliver",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStepTest(t)
+			tt.setup(s)
+
+			start := time.Now()
+			run := s.run()
+
+			if run.ExitCode != timeoutExitCode {
+				t.Errorf("exit code = %d, want %d, output:\n%s", run.ExitCode, timeoutExitCode, run.Output)
+			}
+			if elapsed := time.Since(start); elapsed > 20*time.Second {
+				t.Errorf("the Step ran for %s, want it stopped after the timeout", elapsed)
+			}
+			invocation, ok := run.lastInvocation(tt.wantTimedOut)
+			if !ok {
+				t.Fatalf("%s was not called, output:\n%s", tt.wantTimedOut, run.Output)
+			}
+			if processRunning(invocation.PID) {
+				t.Errorf("%s (%d) is still running after the Step exited", tt.wantTimedOut, invocation.PID)
+				_ = syscall.Kill(invocation.PID, syscall.SIGKILL)
+			}
+			if _, ok := run.lastInvocation("envman add --key " + deployResultsEnvKey); ok && !strings.Contains(run.Outputs[deployResultsEnvKey], `"timed_out":true`) {
+				t.Errorf("%s = %s, want a timed out result", deployResultsEnvKey, run.Outputs[deployResultsEnvKey])
+			}
+		})
+	}
+}
+
+func Test_step_forwardsSignals(t *testing.T) {
+	for _, sig := range []syscall.Signal{syscall.SIGTERM, syscall.SIGINT} {
+		t.Run(sig.String(), func(t *testing.T) {
+			s := newStepTest(t)
+			s.inputs["termination_grace_period"] = "1s"
+			s.hangIgnoringSIGTERM("fastlane deliver", time.Minute)
+
+			run := s.runInterrupted("fastlane deliver", sig)
+
+			if run.ExitCode != 128+int(sig) {
+				t.Errorf("exit code = %d, want %d, output:\n%s", run.ExitCode, 128+int(sig), run.Output)
+			}
+			deliver, _ := run.lastInvocation("fastlane deliver")
+			if processRunning(deliver.PID) {
+				t.Errorf("fastlane deliver (%d) is still running after the Step exited", deliver.PID)
+				_ = syscall.Kill(deliver.PID, syscall.SIGKILL)
+			}
+		})
+	}
+}
+
+func Test_step_uploadHeartbeat(t *testing.T) {
+	s := newStepTest(t)
+	s.inputs["heartbeat_interval"] = "200ms"
+	s.hang("fastlane deliver", 2*time.Second)
+
+	run := s.run()
+
+	if run.ExitCode != 0 {
+		t.Fatalf("exit code = %d, want 0, output:\n%s", run.ExitCode, run.Output)
+	}
+	if !strings.Contains(run.Output, "Still uploading app.ipa (8 B to send), ") {
+		t.Errorf("output = %s, want heartbeat lines", run.Output)
+	}
+}
+
+func Test_step_abortsOnInteractivePrompt(t *testing.T) {
+	s := newStepTest(t)
+	s.responses = append(s.responses, fakeResponse{Command: "fastlane deliver", Output: "Two-factor Authentication (6 digits code) is enabled for account 'user@example.com'", Prompt: "Please enter the 6 digit code: ", Delay: "1m"})
+
+	start := time.Now()
+	run := s.run()
+
+	if run.ExitCode != 1 {
+		t.Errorf("exit code = %d, want 1, output:\n%s", run.ExitCode, run.Output)
+	}
+	if elapsed := time.Since(start); elapsed > 20*time.Second {
+		t.Errorf("the Step ran for %s, want it stopped at the prompt", elapsed)
+	}
+	if !strings.Contains(run.Output, "Apple ID requires 2FA; use an API key or app-specific password") {
+		t.Errorf("output = %s, want the 2FA error", run.Output)
+	}
+	deliver, _ := run.lastInvocation("fastlane deliver")
+	if deliver.Env["CI"] != "true" {
+		t.Errorf("deliver CI = %s, want true", deliver.Env["CI"])
+	}
+	if processRunning(deliver.PID) {
+		t.Errorf("fastlane deliver (%d) is still running after the Step exited", deliver.PID)
+		_ = syscall.Kill(deliver.PID, syscall.SIGKILL)
+	}
+}
+
+func Test_step_uploadBackend(t *testing.T) {
+	tests := []struct {
+		name               string
+		uploadBackend      string
+		itmsParameters     string
+		transporter        bool
+		wantExitCode       int
+		wantForced         bool
+		wantITMSParameters bool
+	}{
+		{name: "auto selects altool", uploadBackend: "auto", transporter: true},
+		{name: "auto selects Transporter for its parameters", uploadBackend: "auto", itmsParameters: "-t DAV", transporter: true, wantForced: true, wantITMSParameters: true},
+		{name: "transporter", uploadBackend: "transporter", itmsParameters: "-t DAV", transporter: true, wantForced: true, wantITMSParameters: true},
+		{name: "transporter not found", uploadBackend: "transporter", wantExitCode: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStepTest(t)
+			s.inputs["upload_backend"] = tt.uploadBackend
+			s.inputs["itms_upload_parameters"] = tt.itmsParameters
+			if tt.transporter {
+				s.respond("xcrun --find iTMSTransporter", "/Applications/Xcode.app/Contents/Developer/usr/bin/iTMSTransporter", 0)
+			}
+
+			run := s.run()
+
+			if run.ExitCode != tt.wantExitCode {
+				t.Fatalf("exit code = %d, want %d, output:\n%s", run.ExitCode, tt.wantExitCode, run.Output)
+			}
+			deliver, ok := run.lastInvocation("fastlane deliver")
+			if tt.wantExitCode != 0 {
+				if ok {
+					t.Errorf("fastlane deliver was called")
+				}
+				return
+			}
+			if got := deliver.Env["ITMSTRANSPORTER_FORCE_ITMS_PACKAGE_UPLOAD"] == "true"; got != tt.wantForced {
+				t.Errorf("ITMSTRANSPORTER_FORCE_ITMS_PACKAGE_UPLOAD set = %v, want %v", got, tt.wantForced)
+			}
+			if got := deliver.Env["DELIVER_ITMSTRANSPORTER_ADDITIONAL_UPLOAD_PARAMETERS"] == tt.itmsParameters && tt.itmsParameters != ""; got != tt.wantITMSParameters {
+				t.Errorf("DELIVER_ITMSTRANSPORTER_ADDITIONAL_UPLOAD_PARAMETERS set = %v, want %v", got, tt.wantITMSParameters)
+			}
+			if !strings.Contains(run.Output, "Upload backend: ") {
+				t.Errorf("output = %s, want the upload backend logged", run.Output)
+			}
+		})
+	}
+}
+
+func Test_step_xcodePath(t *testing.T) {
+	tests := []struct {
+		name         string
+		xcodePath    string
+		wantExitCode int
+	}{
+		{name: "Xcode app", xcodePath: "Xcode-15.app"},
+		{name: "not an Xcode", xcodePath: "Other.app", wantExitCode: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStepTest(t)
+			developerDir := filepath.Join(s.dir, "Xcode-15.app", "Contents", "Developer")
+			if err := os.MkdirAll(filepath.Join(developerDir, "usr", "bin"), 0700); err != nil {
+				t.Fatal(err)
+			}
+			writeTestFile(t, filepath.Join(developerDir, "usr", "bin", "xcodebuild"), "")
+			if err := os.MkdirAll(filepath.Join(s.dir, "Other.app"), 0700); err != nil {
+				t.Fatal(err)
+			}
+			s.inputs["xcode_path"] = filepath.Join(s.dir, tt.xcodePath)
+			s.respond("xcrun altool --version", "altool 8.306.13305 (13305)", 0)
+
+			run := s.run()
+
+			if run.ExitCode != tt.wantExitCode {
+				t.Fatalf("exit code = %d, want %d, output:\n%s", run.ExitCode, tt.wantExitCode, run.Output)
+			}
+			if tt.wantExitCode != 0 {
+				if _, ok := run.lastInvocation("xcodebuild"); ok {
+					t.Errorf("xcodebuild was called with an invalid Xcode path")
+				}
+				return
+			}
+
+			for _, command := range []string{"xcodebuild -version", "xcrun --find altool", "fastlane deliver"} {
+				invocation, ok := run.lastInvocation(command)
+				if !ok {
+					t.Errorf("%s was not called", command)
+					continue
+				}
+				if got := invocation.Env["DEVELOPER_DIR"]; got != developerDir {
+					t.Errorf("%s DEVELOPER_DIR = %s, want %s", command, got, developerDir)
+				}
+			}
+			for _, want := range []string{"Xcode 15.0 (Build version 15A240d)", "Upload tool version: altool 8.306.13305 (13305)"} {
+				if !strings.Contains(run.Output, want) {
+					t.Errorf("output = %s, want %s", run.Output, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_step_withoutXcode(t *testing.T) {
+	s := newStepTest(t)
+	s.respond("xcodebuild -version", "xcode-select: error: tool 'xcodebuild' requires Xcode", 1)
+
+	run := s.run()
+
+	// Apple ID authentication on a host without Xcode and the Transporter app has no way to upload
+	if run.ExitCode != 1 {
+		t.Fatalf("exit code = %d, want 1, output:\n%s", run.ExitCode, run.Output)
+	}
+	if !strings.Contains(run.Output, "no upload tool found") {
+		t.Errorf("output = %s, want the missing upload tool error", run.Output)
+	}
+	for _, want := range []string{"Xcode:", "Upload with altool:", "Upload with the App Store Connect API:", "Metadata and screenshots:"} {
+		if !strings.Contains(run.Output, want) {
+			t.Errorf("output = %s, want the capability %s printed", run.Output, want)
+		}
+	}
+	for _, command := range []string{"xcrun", "fastlane"} {
+		if _, ok := run.lastInvocation(command); ok {
+			t.Errorf("%s was called, commands:\n%s", command, strings.Join(run.commandLines(), "\n"))
+		}
+	}
+}
+
+func Test_step_fakeAppStoreConnect(t *testing.T) {
+	server, err := appstoreconnecttest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	appID := server.AddApp("io.bitrise.Example", "Example")
+
+	s := newStepTest(t)
+	for _, key := range []string{"itunescon_user", "password", "app_password"} {
+		delete(s.inputs, key)
+	}
+	// deliver has nothing to do, the Step doesn't need Ruby and fastlane
+	for _, tool := range []string{"ruby", "gem", "bundle", "fastlane"} {
+		if err := os.Remove(filepath.Join(s.toolsDir, "bin", tool)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestIPA(t, s.inputs["ipa_path"])
+	s.inputs["bundle_id"] = "io.bitrise.Example"
+	s.inputs["skip_app_version_update"] = "yes"
+	s.inputs["upload_backend"] = "api"
+	s.inputs["if_already_uploaded"] = "skip"
+	s.inputs["connection"] = "api_key_env"
+	s.inputs[ascKeyIDEnvKey] = server.KeyID
+	s.inputs[ascIssuerIDEnvKey] = server.IssuerID
+	s.inputs[ascKeyContentEnvKey] = base64.StdEncoding.EncodeToString(server.PrivateKey)
+	s.inputs[fakeAppStoreConnectURLEnvKey] = server.URL + "/"
+
+	run := s.run()
+	if run.ExitCode != 0 {
+		t.Fatalf("first run exit code = %d, want 0, output:\n%s", run.ExitCode, run.Output)
+	}
+	if uploaded := server.UploadedBuilds(); len(uploaded) != 1 || uploaded[0].AppID != appID || uploaded[0].Version != "1.2.3" || uploaded[0].BuildNumber != "42" {
+		t.Fatalf("uploaded builds = %+v, want 1.2.3 (42) of app %s", uploaded, appID)
+	}
+	for _, command := range []string{"ruby", "gem", "bundle", "fastlane"} {
+		if _, ok := run.lastInvocation(command); ok {
+			t.Errorf("%s was called, commands:\n%s", command, strings.Join(run.commandLines(), "\n"))
+		}
+	}
+	if !strings.Contains(run.Output, "Ruby and fastlane are not set up") {
+		t.Errorf("output does not report the skipped setup:\n%s", run.Output)
+	}
+
+	// the re-run finds the uploaded build and skips the upload
+	run = s.run()
+	if run.ExitCode != 0 {
+		t.Fatalf("re-run exit code = %d, want 0, output:\n%s", run.ExitCode, run.Output)
+	}
+	if uploaded := server.UploadedBuilds(); len(uploaded) != 1 {
+		t.Errorf("uploaded builds = %+v, want the build of the first run only", uploaded)
+	}
+	if !strings.Contains(run.Output, "already uploaded") {
+		t.Errorf("re-run output does not report the already uploaded build:\n%s", run.Output)
+	}
+}
@@ -1,38 +1,39 @@
 package main
 
 import (
-	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
-	"github.com/bitrise-io/go-steputils/command/gems"
-	"github.com/bitrise-io/go-steputils/command/rubycommand"
 	"github.com/bitrise-io/go-steputils/stepconf"
-	"github.com/bitrise-io/go-utils/command"
-	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-steputils/tools"
 	"github.com/bitrise-io/go-utils/log"
-	"github.com/bitrise-io/go-utils/pathutil"
 	"github.com/bitrise-io/go-utils/retry"
 	"github.com/bitrise-io/go-xcode/appleauth"
 	"github.com/bitrise-io/go-xcode/devportalservice"
-	"github.com/bitrise-io/go-xcode/utility"
 	"github.com/kballard/go-shellquote"
 )
 
 // Config ...
 type Config struct {
-	IpaPath string `env:"ipa_path"`
-	PkgPath string `env:"pkg_path"`
+	IpaPath        string `env:"ipa_path"`
+	PkgPath        string `env:"pkg_path"`
+	ArtifactSHA256 string `env:"artifact_sha256"`
 
-	BitriseConnection string          `env:"connection,opt[automatic,api_key,apple_id,off]"`
+	BitriseConnection string          `env:"connection,opt[automatic,api_key,apple_id,off,api_key_file,api_key_env,vault]"`
 	ItunesConnectUser string          `env:"itunescon_user"`
 	Password          stepconf.Secret `env:"password"`
 	AppPassword       stepconf.Secret `env:"app_password"`
 	APIKeyPath        stepconf.Secret `env:"api_key_path"`
 	APIIssuer         string          `env:"api_issuer"`
+	APIKeyType        string          `env:"api_key_type,opt[team,individual]"`
+	APIKeyFilePath    string          `env:"api_key_file_path"`
+	VaultAddress      string          `env:"vault_address"`
+	VaultToken        stepconf.Secret `env:"vault_token"`
+	VaultSecretPath   string          `env:"vault_secret_path"`
 
 	AppID                string `env:"app_id"`
 	BundleID             string `env:"bundle_id"`
@@ -44,23 +45,37 @@ type Config struct {
 	TeamName             string `env:"team_name"`
 	Platform             string `env:"platform,opt[ios,osx,appletvos]"`
 	Options              string `env:"options"`
-
-	GemfilePath     string `env:"gemfile_path"`
-	FastlaneVersion string `env:"fastlane_version"`
-	ITMSParameters  string `env:"itms_upload_parameters"`
+	AppsManifestPath     string `env:"apps_manifest_path"`
+
+	GemfilePath       string `env:"gemfile_path"`
+	BundlePath        string `env:"bundle_path"`
+	BundleWithout     string `env:"bundle_without"`
+	BundleMode        string `env:"bundle_install_mode,opt[default,frozen,deployment]"`
+	FastlaneVersion   string `env:"fastlane_version"`
+	FastlaneCacheDir  string `env:"fastlane_cache_dir"`
+	ITMSParameters    string `env:"itms_upload_parameters"`
+	UploadBackend     string `env:"upload_backend,opt[auto,altool,transporter,api]"`
+	IfAlreadyUploaded string `env:"if_already_uploaded,opt[fail,skip,continue_to_submit]"`
+	XcodePath         string `env:"xcode_path"`
+
+	Timeout                string `env:"timeout"`
+	SetupTimeout           string `env:"setup_timeout"`
+	UploadTimeout          string `env:"upload_timeout"`
+	ProcessingTimeout      string `env:"processing_timeout"`
+	TerminationGracePeriod string `env:"termination_grace_period"`
+	HeartbeatInterval      string `env:"heartbeat_interval"`
 
 	VerboseLog bool `env:"verbose_log,opt[yes,no]"`
 
 	// Used to get Bitrise Apple Developer Portal Connection
 	BuildURL      string          `env:"BITRISE_BUILD_URL"`
 	BuildAPIToken stepconf.Secret `env:"BITRISE_BUILD_API_TOKEN"`
-}
 
-const latestStable = "latest-stable"
-const latestPrerelease = "latest"
+	DeployDir string `env:"BITRISE_DEPLOY_DIR"`
+}
 
-func parseAuthSources(bitriseConnection string) ([]appleauth.Source, error) {
-	switch bitriseConnection {
+func parseAuthSources(cfg Config) ([]appleauth.Source, error) {
+	switch cfg.BitriseConnection {
 	case "automatic":
 		return []appleauth.Source{
 			&appleauth.ConnectionAPIKeySource{},
@@ -77,174 +92,112 @@ func parseAuthSources(bitriseConnection string) ([]appleauth.Source, error) {
 			&appleauth.InputAPIKeySource{},
 			&appleauth.InputAppleIDFastlaneSource{},
 		}, nil
+	case "api_key_file":
+		return []appleauth.Source{&fileAPIKeySource{Path: cfg.APIKeyFilePath, KeyType: cfg.APIKeyType}}, nil
+	case "api_key_env":
+		return []appleauth.Source{&envAPIKeySource{KeyType: cfg.APIKeyType}}, nil
+	case "vault":
+		return []appleauth.Source{&vaultAPIKeySource{
+			Address:    cfg.VaultAddress,
+			Token:      string(cfg.VaultToken),
+			SecretPath: cfg.VaultSecretPath,
+			KeyType:    cfg.APIKeyType,
+			client:     retry.NewHTTPClient().StandardClient(),
+		}}, nil
 	default:
-		return nil, fmt.Errorf("invalid connection input: %s", bitriseConnection)
+		return nil, fmt.Errorf("invalid connection input: %s", cfg.BitriseConnection)
 	}
 }
 
-func fail(format string, v ...interface{}) {
-	log.Errorf(format, v...)
-	os.Exit(1)
+// usesBitriseConnection reports whether the connection input mode uses the Bitrise Apple Developer Connection
+func usesBitriseConnection(bitriseConnection string) bool {
+	switch bitriseConnection {
+	case "automatic", "api_key", "apple_id":
+		return true
+	default:
+		return false
+	}
 }
 
-func gemInstallWithRetry(gemName string, version string) error {
-	return retry.Times(2).Try(func(attempt uint) error {
-		if attempt > 0 {
-			log.Warnf("%d attempt failed", attempt+1)
-		}
-
-		versionToInstall := version
-
-		if version == latestStable ||
-			version == latestPrerelease {
-			versionToInstall = ""
-		}
-
-		cmds, err := rubycommand.GemInstall(gemName, versionToInstall, version == latestPrerelease)
-		if err != nil {
-			return fmt.Errorf("failed to create command, error: %s", err)
-		}
-
-		for _, cmd := range cmds {
-			fmt.Println()
-			log.Donef("$ %s", cmd.PrintableCommandArgs())
-			if out, err := cmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
-				return fmt.Errorf("gem install command failed, output: %s, error: %s", out, err)
-			}
-		}
+func fail(format string, v ...interface{}) {
+	failWithCode(1, format, v...)
+}
 
-		return nil
-	})
+func failWithCode(exitCode int, format string, v ...interface{}) {
+	log.Errorf(format, v...)
+	exitStep(exitCode)
 }
 
-func gemVersionFromGemfileLock(gem, gemfileLockPth string) (gems.Version, error) {
-	content, err := fileutil.ReadStringFromFile(gemfileLockPth)
-	if err != nil {
-		return gems.Version{}, err
+// failPhase fails the Step, with the timeout exit code if the phase ran out of time
+func failPhase(deadline *phaseDeadline, format string, v ...interface{}) {
+	if deadline.Exceeded() {
+		failWithCode(timeoutExitCode, format, v...)
 	}
-	return gems.ParseVersionFromBundle(gem, content)
+	fail(format, v...)
 }
 
-func ensureFastlaneVersionAndCreateCmdSlice(forceVersion, gemfilePth string) ([]string, string, error) {
-	if forceVersion != "" {
-		log.Printf("fastlane version defined: %s, installing...", forceVersion)
+// stepTimeouts are the time limits of the Step, zero means no limit
+type stepTimeouts struct {
+	Step        time.Duration
+	Setup       time.Duration
+	Upload      time.Duration
+	Processing  time.Duration
+	GracePeriod time.Duration
+	// Heartbeat is the interval of the heartbeat printed during silent uploads, zero disables it
+	Heartbeat time.Duration
+}
 
-		if err := gemInstallWithRetry("fastlane", forceVersion); err != nil {
-			return nil, "", err
+func (cfg Config) timeouts() (stepTimeouts, error) {
+	var timeouts stepTimeouts
+	for _, input := range []struct {
+		name  string
+		value string
+		field *time.Duration
+	}{
+		{name: "timeout", value: cfg.Timeout, field: &timeouts.Step},
+		{name: "setup_timeout", value: cfg.SetupTimeout, field: &timeouts.Setup},
+		{name: "upload_timeout", value: cfg.UploadTimeout, field: &timeouts.Upload},
+		{name: "processing_timeout", value: cfg.ProcessingTimeout, field: &timeouts.Processing},
+		{name: "termination_grace_period", value: cfg.TerminationGracePeriod, field: &timeouts.GracePeriod},
+		{name: "heartbeat_interval", value: cfg.HeartbeatInterval, field: &timeouts.Heartbeat},
+	} {
+		if input.value == "" || input.value == "0" {
+			continue
 		}
-
-		fastlaneCmdSlice := []string{"fastlane"}
-		if forceVersion != latestStable && forceVersion != latestPrerelease {
-			fastlaneCmdSlice = append(fastlaneCmdSlice, fmt.Sprintf("_%s_", forceVersion))
+		duration, err := time.ParseDuration(input.value)
+		if err != nil {
+			return stepTimeouts{}, fmt.Errorf("invalid %s (%s), use a duration like 30m or 1h30m", input.name, input.value)
 		}
-
-		return fastlaneCmdSlice, "", nil
-	}
-
-	if gemfilePth == "" {
-		log.Printf("no fastlane version nor Gemfile path defined, using system installed fastlane...")
-		return []string{"fastlane"}, "", nil
-	}
-
-	if exist, err := pathutil.IsPathExists(gemfilePth); err != nil {
-		return nil, "", err
-	} else if !exist {
-		log.Printf("Gemfile not exist at: %s and no fastlane version defined, using system installed fastlane...", gemfilePth)
-		return []string{"fastlane"}, "", nil
-	}
-
-	log.Printf("Gemfile exist, checking Fastlane version from gem lockfile")
-
-	bundleInstallCalled := false
-	gemfileDir := filepath.Dir(gemfilePth)
-	gemfileLockPth, err := gems.GemFileLockPth(gemfileDir)
-	if err != nil {
-		if err == gems.ErrGemLockNotFound {
-			log.Printf("gem lockfile not exist at: %s, running 'bundle install' ...", gemfileDir)
-
-			cmd := command.NewWithStandardOuts("bundle", "install").SetStdin(os.Stdin).SetDir(gemfileDir)
-			if err := cmd.Run(); err != nil {
-				return nil, "", err
-			}
-
-			bundleInstallCalled = true
-
-			gemfileLockPth, err = gems.GemFileLockPth(gemfileDir)
-			if err != nil {
-				if err == gems.ErrGemLockNotFound {
-					return nil, "", errors.New("gem lockfile still not exist, even after 'bundle install' was called")
-				}
-				return nil, "", err
-			}
-		} else {
-			return nil, "", err
+		if duration < 0 {
+			return stepTimeouts{}, fmt.Errorf("invalid %s (%s), must not be negative", input.name, input.value)
 		}
+		*input.field = duration
 	}
 
-	fastlane, err := gemVersionFromGemfileLock("fastlane", gemfileLockPth)
-	if err != nil {
-		return nil, "", err
+	if timeouts.GracePeriod == 0 {
+		timeouts.GracePeriod = defaultTerminationGracePeriod
 	}
+	return timeouts, nil
+}
 
-	if fastlane.Found {
-		log.Printf("fastlane version defined in gem lockfile: %s, using bundler to call fastlane commands...", fastlane.Version)
-
-		var bundlerVersion gems.Version
-		if !bundleInstallCalled {
-			content, err := fileutil.ReadStringFromFile(gemfileLockPth)
-			if err != nil {
-				return nil, "", fmt.Errorf("failed to read file (%s) contents, error: %s", gemfileLockPth, err)
-			}
-
-			bundlerVersion, err = gems.ParseBundlerVersion(content)
-			if err != nil {
-				return nil, "", fmt.Errorf("failed to parse bundler version, error: %s", err)
-			}
-
-			fmt.Println()
-			log.Infof("Installing bundler")
-
-			// install bundler with `gem install bundler [-v version]`
-			// in some configurations, the command "bunder _1.2.3_" can return 'Command not found', installing bundler solves this
-			installBundlerCommand := gems.InstallBundlerCommand(bundlerVersion)
-			installBundlerCommand.SetStdout(os.Stdout).SetStderr(os.Stderr)
-			installBundlerCommand.SetDir(gemfileDir)
-
-			fmt.Println()
-			log.Donef("$ %s", installBundlerCommand.PrintableCommandArgs())
-
-			if err := installBundlerCommand.Run(); err != nil {
-				return nil, "", fmt.Errorf("command failed, error: %s", err)
-			}
-
-			// install gem lockfile gems with `bundle [_version_] install ...`
-			fmt.Println()
-			log.Infof("Installing bundle")
-
-			cmd, err := gems.BundleInstallCommand(bundlerVersion)
-			if err != nil {
-				return nil, "", fmt.Errorf("failed to create bundle command model, error: %s", err)
-			}
-			cmd.SetStdout(os.Stdout).SetStderr(os.Stderr)
-			cmd.SetDir(gemfileDir)
-
-			fmt.Println()
-			log.Donef("$ %s", cmd.PrintableCommandArgs())
-
-			if err := cmd.Run(); err != nil {
-				return nil, "", fmt.Errorf("command failed, error: %s", err)
-			}
-		}
-
-		return append(gems.BundleExecPrefix(bundlerVersion), "fastlane"), gemfileDir, nil
+// deliverDeadline returns the phase deadline of a deliver run: when submitting a binary for review,
+// deliver also waits for App Store Connect to process the build, so the processing timeout is added to the upload timeout
+func (timeouts stepTimeouts) deliverDeadline(submitForReview, hasArtifact bool) *phaseDeadline {
+	if !submitForReview || !hasArtifact {
+		return startPhase("Upload", timeouts.Upload)
 	}
-
-	log.Printf("Fastlane version not found in gem lockfile, using system installed Fastlane...")
-
-	return []string{"fastlane"}, "", nil
+	if timeouts.Upload == 0 || timeouts.Processing == 0 {
+		return startPhase("Upload and processing", 0)
+	}
+	return startPhase("Upload and processing", timeouts.Upload+timeouts.Processing)
 }
 
 func (cfg Config) validate() error {
+	if cfg.AppsManifestPath != "" {
+		// artifact and app identifiers are validated per manifest entry
+		return nil
+	}
+
 	if cfg.IpaPath == "" && cfg.PkgPath == "" {
 		return fmt.Errorf("no IpaPath nor PkgPath parameter specified")
 	}
@@ -278,14 +231,24 @@ func handleSessionDataError(err error) {
 }
 
 func main() {
+	exitOnSignal()
+	defer tempFiles.Cleanup()
+
 	var cfg Config
 	if err := stepconf.Parse(&cfg); err != nil {
 		fail("Issue with input: %s", err)
 	}
 
-	stepconf.Print(cfg)
+	stepconf.Print(cfg.withRedactedURLs())
 	log.SetEnableDebugLog(cfg.VerboseLog)
 
+	timeouts, err := cfg.timeouts()
+	if err != nil {
+		fail("Issue with input: %s", err)
+	}
+	childProcesses.SetGracePeriod(timeouts.GracePeriod)
+	exitOnTimeout(timeouts.Step)
+
 	//
 	// Validate inputs
 	if err := cfg.validate(); err != nil {
@@ -298,13 +261,13 @@ func main() {
 		APIIssuer:           cfg.APIIssuer,
 		APIKeyPath:          string(cfg.APIKeyPath),
 	}
-	if err := authInputs.Validate(); err != nil {
+	if err := validateAuthInputs(&authInputs, cfg.APIKeyType); err != nil {
 		fail("Issue with authentication related inputs: %v", err)
 	}
 
 	//
 	// Select and fetch Apple authenication source
-	authSources, err := parseAuthSources(cfg.BitriseConnection)
+	authSources, err := parseAuthSources(cfg)
 	if err != nil {
 		fail("Invalid input: unexpected value for Bitrise Apple Developer Connection (%s)", cfg.BitriseConnection)
 	}
@@ -312,12 +275,12 @@ func main() {
 	var devportalConnectionProvider *devportalservice.BitriseClient
 	if cfg.BuildURL != "" && cfg.BuildAPIToken != "" {
 		devportalConnectionProvider = devportalservice.NewBitriseClient(retry.NewHTTPClient().StandardClient(), cfg.BuildURL, string(cfg.BuildAPIToken))
-	} else {
+	} else if usesBitriseConnection(cfg.BitriseConnection) {
 		fmt.Println()
 		log.Warnf("Connected Apple Developer Portal Account not found. Step is not running on bitrise.io: BITRISE_BUILD_URL and BITRISE_BUILD_API_TOKEN envs are not set")
 	}
 	var conn *devportalservice.AppleDeveloperConnection
-	if cfg.BitriseConnection != "off" && devportalConnectionProvider != nil {
+	if usesBitriseConnection(cfg.BitriseConnection) && devportalConnectionProvider != nil {
 		var err error
 		conn, err = devportalConnectionProvider.GetAppleDeveloperConnection()
 		if err != nil {
@@ -330,37 +293,88 @@ func main() {
 		}
 	}
 
-	authConfig, err := appleauth.Select(conn, authSources, authInputs)
+	// the credentials of the other sources are the fallbacks of deliver, if the first credential is rejected
+	authCandidates, err := selectAuthCandidates(conn, authSources, authInputs)
 	if err != nil {
 		fail("Could not configure Apple Service authentication: %v", err)
 	}
+	authConfig := authCandidates[0].Credentials
 	if authConfig.AppleID != nil && authConfig.AppleID.AppSpecificPassword == "" {
 		log.Warnf("If 2FA enabled Apple ID is used, Application-specific password is required.")
 	}
 
+	targets, err := cfg.deployTargets()
+	if err != nil {
+		fail("Issue with input: %s", err)
+	}
+
 	//
-	// Setup
+	// Host capabilities: Xcode is only required by the upload backends using its tools
 	fmt.Println()
-	log.Infof("Setup")
+	log.Infof("Host capabilities")
 
-	startTime := time.Now()
+	runner := newDefaultRunner()
+	toolVersions := map[string]string{}
 
-	fastlaneCmdSlice, workDir, err := ensureFastlaneVersionAndCreateCmdSlice(cfg.FastlaneVersion, cfg.GemfilePath)
-	if err != nil {
-		fail("Failed to ensure Fastlane version, error: %s", err)
+	if cfg.XcodePath != "" {
+		developerDir, err := resolveDeveloperDir(cfg.XcodePath)
+		if err != nil {
+			fail("Issue with input: %s", err)
+		}
+		// the child processes inherit the selected Xcode, including the upload tools fastlane runs
+		if err := os.Setenv(developerDirEnvKey, developerDir); err != nil {
+			fail("Failed to select the Xcode at %s: %s", cfg.XcodePath, err)
+		}
+		log.Printf("Selected Xcode: %s (%s=%s)", cfg.XcodePath, developerDirEnvKey, developerDir)
+		toolVersions["developer_dir"] = developerDir
+	}
+
+	xcode := detectXcode()
+	if xcode == nil && cfg.XcodePath != "" {
+		fail("Failed to read the version of the Xcode at %s, run xcodebuild -version with %s set to check it", cfg.XcodePath, developerDirEnvKey)
+	}
+	var xcodeMajorVersion int64
+	if xcode != nil {
+		xcodeMajorVersion = xcode.MajorVersion
+		toolVersions["xcode"] = fmt.Sprintf("%s (%s)", xcode.Version, xcode.BuildVersion)
 	}
+	uploadTools := probeUploadTools(runner, xcodeMajorVersion)
+	printCapabilities(hostCapabilities(xcode, uploadTools, probeDeliverTools(runner), authConfig.APIKey != nil))
 
-	versionCmdSlice := append(fastlaneCmdSlice, "-v")
-	versionCmd := command.NewWithStandardOuts(versionCmdSlice[0], versionCmdSlice[1:]...)
+	backend, reason, err := selectUploadBackend(cfg.UploadBackend, uploadTools, cfg.ITMSParameters, authConfig.APIKey != nil)
+	if err != nil {
+		fail("Failed to select the upload backend: %s", err)
+	}
 	fmt.Println()
-	log.Donef(fmt.Sprintf("$ %s", versionCmd.PrintableCommandArgs()))
-	if err := versionCmd.Run(); err != nil {
-		fail("Failed to print Fastlane version, error: %s", err)
+	log.Printf("Upload backend: %s, %s", backend, reason)
+	if cfg.ITMSParameters != "" && backend != uploadBackendTransporter {
+		log.Warnf("The iTMSTransporter upload parameters (%s) are not used by the %s upload backend", cfg.ITMSParameters, backend)
+	}
+	toolVersions["upload_backend"] = string(backend)
+	if version := uploadToolVersion(runner, backend, uploadTools); version != "" {
+		log.Printf("Upload tool version: %s %s", backend, version)
+		toolVersions[string(backend)] = version
 	}
 
-	elapsed := time.Since(startTime)
+	var options []string
+	if cfg.Options != "" {
+		opts, err := shellquote.Split(cfg.Options)
+		if err != nil {
+			fail("Failed to split options (%s), error: %s", cfg.Options, err)
+		}
+		options = opts
+	}
 
-	log.Printf("Setup took %f seconds to complete", elapsed.Seconds())
+	//
+	// Setup
+	var fastlane fastlaneInstallation
+	if cfg.runsDeliver(backend, targets, options) {
+		fastlane = setupFastlane(runner, cfg, timeouts, toolVersions)
+	} else {
+		fmt.Println()
+		log.Infof("Setup")
+		log.Printf("The binary is uploaded with the App Store Connect API and every deliver action is skipped, Ruby and fastlane are not set up")
+	}
 
 	//
 	// Main
@@ -377,144 +391,334 @@ alphanumeric characters.`)
 		fmt.Println()
 	}
 
-	var options []string
-	if cfg.Options != "" {
-		opts, err := shellquote.Split(cfg.Options)
-		if err != nil {
-			fail("Failed to split options (%s), error: %s", cfg.Options, err)
+	if err := os.Unsetenv("FASTLANE_PASSWORD"); err != nil {
+		fail("Could not unset Fastlane password, reason: ", err)
+	}
+
+	var results []deployResult
+	for _, target := range targets {
+		if len(targets) > 1 {
+			fmt.Println()
+			log.Infof("Deploying %s", target.displayName())
 		}
-		options = opts
+
+		result := deployTarget(runner, cfg, timeouts, target, authConfig, fastlane, backend, options, authCandidates[1:]...)
+		if result.Success && result.AuthSource == "" {
+			result.AuthSource = authCandidates[0].Source
+		}
+		results = append(results, result)
 	}
 
-	version, err := utility.GetXcodeVersion()
-	if err != nil {
-		fail("Failed to read Xcode version: %w", err)
+	if err := exportDeployResults(results); err != nil {
+		log.Warnf("Failed to export deploy results: %s", err)
 	}
 
-	envs := []string{}
-	// Xcode 14 and above fastlane uses altool to upload
-	if version.MajorVersion < 14 {
-		envs = append(envs, "ITMSTRANSPORTER_FORCE_ITMS_PACKAGE_UPLOAD=true")
-		if cfg.ITMSParameters != "" {
-			envs = append(envs, "DELIVER_ITMSTRANSPORTER_ADDITIONAL_UPLOAD_PARAMETERS="+cfg.ITMSParameters)
+	if err := writeProvenanceReport(cfg.DeployDir, newProvenanceReport(cfg, toolVersions, results)); err != nil {
+		log.Warnf("Failed to write provenance report: %s", err)
+	}
+
+	if len(targets) > 1 {
+		printDeployResults(results)
+	}
+
+	if failed := failedCount(results); failed > 0 {
+		if cfg.FastlaneVersion != latestPrerelease {
+			log.Warnf(fmt.Sprintf(`If you have issues, use the latest prerelease version of fastlane.
+Set the fastlane version input to "%s" to enable prerelease versions.`, latestPrerelease))
+		}
+		exitCode := 1
+		if timedOutCount(results) > 0 {
+			exitCode = timeoutExitCode
 		}
+		if len(results) > 1 {
+			failWithCode(exitCode, "Deploy failed for %d of %d apps", failed, len(results))
+		}
+		failWithCode(exitCode, "Deploy failed, error: %s", results[0].Error)
 	}
 
-	args := []string{"deliver"}
+	log.Donef("Success")
+	log.Printf("The app (.ipa) was successfully uploaded to [App Store Connect](https://appstoreconnect.apple.com), you should see it in the *Prerelease* section on the app's page!")
+}
+
+// setupFastlane sets up Ruby, fastlane and the fastlane plugins within the setup timeout, and records their versions in toolVersions
+func setupFastlane(runner commandRunner, cfg Config, timeouts stepTimeouts, toolVersions map[string]string) fastlaneInstallation {
+	fmt.Println()
+	log.Infof("Setup")
+
+	startTime := time.Now()
+
+	setup := startPhase("Setup", timeouts.Setup)
+	setupRunner := runner.withDeadline(setup)
+
+	ruby, err := ensureRuby(setupRunner, resolveGemfilePath(cfg.GemfilePath), cfg.FastlaneVersion)
+	if err != nil {
+		failPhase(setup, "Failed to ensure Ruby version, error: %s", err)
+	}
 
-	authParams, err := FastlaneAuthParams(authConfig)
+	installer := fastlaneInstaller{
+		runner: setupRunner,
+		ruby:   ruby,
+		bundle: newBundleConfig(cfg.BundlePath, cfg.BundleWithout, cfg.BundleMode),
+		cache:  newFastlaneCache(cfg.FastlaneCacheDir),
+	}
+	fastlane, err := installer.ensureFastlaneVersionAndCreateCmdSlice(cfg.FastlaneVersion, cfg.GemfilePath)
 	if err != nil {
-		fail("Failed to set up Fastlane authentication paramteres: %v", err)
+		failPhase(setup, "Failed to ensure Fastlane version, error: %s", err)
 	}
-	for envKey, envValue := range authParams.Envs {
-		envs = append(envs, fmt.Sprintf("%s=%s", envKey, envValue))
+
+	fastlane, err = installer.ensureFastlanePlugins(fastlane, resolveGemfilePath(cfg.GemfilePath))
+	if err != nil {
+		failPhase(setup, "Failed to install fastlane plugins, error: %s", err)
 	}
-	for _, arg := range authParams.Args {
-		args = append(args, []string{arg.Key, arg.Value}...)
+
+	versionCmdSlice := append(append([]string{}, fastlane.CmdSlice...), "-v")
+	versionCmd := newCommandSpec(versionCmdSlice[0], versionCmdSlice[1:]...).withEnvs(fastlane.Envs...).withDir(fastlane.WorkDir)
+	fmt.Println()
+	log.Donef(fmt.Sprintf("$ %s", versionCmd))
+	versionOut, err := setupRunner.CombinedOutput(versionCmd)
+	fmt.Println(versionOut)
+	if err != nil {
+		failPhase(setup, "Failed to print Fastlane version, error: %s", err)
 	}
+	logFastlanePlugins(setupRunner, fastlane)
+	toolVersions["fastlane"] = parseFastlaneVersion(versionOut)
+	toolVersions["ruby"] = fmt.Sprintf("%s (%s)", ruby.Version, ruby.Manager)
+	setup.Stop()
 
-	if cfg.AppID != "" {
-		args = append(args, "--app", cfg.AppID)
+	elapsed := time.Since(startTime)
 
-		//warn user if BundleID is also set
-		if cfg.BundleID != "" {
-			log.Warnf("AppID parameter specified, BundleID will be ignored")
-		}
-	} else if cfg.BundleID != "" {
-		args = append(args, "--app_identifier", cfg.BundleID)
+	log.Printf("Setup took %f seconds to complete", elapsed.Seconds())
+	if err := tools.ExportEnvironmentWithEnvman(fastlaneVersionEnvKey, toolVersions["fastlane"]); err != nil {
+		log.Warnf("Failed to export fastlane version: %s", err)
+	}
+	if err := tools.ExportEnvironmentWithEnvman(setupDurationEnvKey, fmt.Sprintf("%.0f", elapsed.Seconds())); err != nil {
+		log.Warnf("Failed to export setup duration: %s", err)
 	}
 
-	if cfg.TeamName != "" {
-		args = append(args, "--team_name", cfg.TeamName)
+	return fastlane
+}
 
-		//warn user if TeamID is also set
-		if cfg.TeamID != "" {
-			log.Warnf("TeamName parameter specified, TeamID will be ignored")
+// deployTarget runs deliver for a single app, using a temporary directory private to this app
+// for the artifact copy and the generated authentication files.
+// deliver is retried once with the first fallback credential if App Store Connect rejects authConfig,
+// the already uploaded lookup and the API upload only use authConfig.
+func deployTarget(runner commandRunner, cfg Config, timeouts stepTimeouts, target appTarget, authConfig appleauth.Credentials, fastlane fastlaneInstallation, backend uploadBackend, options []string, fallbacks ...authCandidate) (result deployResult) {
+	result = deployResult{Name: target.displayName(), AppID: target.AppID, BundleID: target.BundleID}
+	startTime := time.Now()
+	defer func() {
+		result.Duration = time.Since(startTime).Round(time.Second).String()
+	}()
+
+	tmpDir, err := tempFiles.MkdirTemp("deliver")
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer func() {
+		if err := tempFiles.Remove(tmpDir); err != nil {
+			log.Warnf("Failed to remove temporary directory (%s): %s", tmpDir, err)
 		}
-	} else if cfg.TeamID != "" {
-		args = append(args, "--team_id", cfg.TeamID)
+	}()
+
+	authParams, err := FastlaneAuthParams(authConfig, tmpDir)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to set up Fastlane authentication paramteres: %s", err)
+		return result
 	}
 
-	if cfg.IpaPath != "" {
-		tmpIpaPath, err := normalizeArtifactPath(cfg.IpaPath)
+	//warn user if BundleID is also set
+	if target.AppID != "" && target.BundleID != "" {
+		log.Warnf("AppID parameter specified, BundleID will be ignored")
+	}
+
+	//warn user if TeamID is also set
+	if target.TeamName != "" && target.TeamID != "" {
+		log.Warnf("TeamName parameter specified, TeamID will be ignored")
+	}
+
+	var artifact *deliverArtifact
+	if artifactPth, artifactFlag := target.artifact(); artifactPth != "" {
+		localPth, err := fetchArtifact(artifactPth, "."+strings.TrimPrefix(artifactFlag, "--"), tmpDir)
 		if err != nil {
-			log.Warnf("failed to copy the %s to the temporarily dir, error: %s", filepath.Base(cfg.IpaPath), err)
-			tmpIpaPath = cfg.IpaPath
+			result.Error = err.Error()
+			return result
 		}
-		args = append(args, "--ipa", tmpIpaPath)
 
-	} else if cfg.PkgPath != "" {
-		tmpPkgPath, err := normalizeArtifactPath(cfg.PkgPath)
+		provenance, err := inspectArtifact(localPth, artifactPth)
 		if err != nil {
-			log.Warnf("failed to copy the %s to the temporarily dir, error: %s", filepath.Base(cfg.PkgPath), err)
-			tmpPkgPath = cfg.PkgPath
+			result.Error = err.Error()
+			return result
 		}
-		args = append(args, "--pkg", tmpPkgPath)
-	}
+		result.Artifact = &provenance
 
-	if cfg.SkipScreenshots == "yes" {
-		args = append(args, "--skip_screenshots")
-	}
+		if target.SHA256 != "" {
+			if err := verifySHA256(filepath.Base(localPth), target.SHA256, provenance.SHA256); err != nil {
+				result.Error = err.Error()
+				return result
+			}
+			log.Donef("Artifact SHA-256 checksum verified")
+		}
 
-	if cfg.SkipMetadata == "yes" {
-		args = append(args, "--skip_metadata")
+		stagedPth, err := stageArtifact(localPth, tmpDir)
+		if err != nil {
+			log.Warnf("failed to stage %s in the temporary dir, error: %s", filepath.Base(localPth), err)
+			stagedPth = localPth
+		}
+		artifact = &deliverArtifact{Flag: artifactFlag, Path: stagedPth}
+	}
+
+	submitForReview := cfg.SubmitForReview == "yes"
+	if artifact != nil && cfg.checksAlreadyUploaded(authConfig) {
+		app := result.Artifact.App
+		build, err := lookupUploadedBuild(authConfig, target, cfg.Platform, app)
+		switch {
+		case err != nil:
+			log.Warnf("Failed to check whether the build is already uploaded, uploading it: %s", err)
+		case build == nil:
+			log.Printf("Build %s (%s) is not uploaded yet", app.Version, app.BuildNumber)
+		case cfg.IfAlreadyUploaded == alreadyUploadedSkip || cfg.IfAlreadyUploaded == alreadyUploadedContinueToSubmit:
+			log.Warnf("Build %s (%s) is already uploaded (processing state: %s), it is not uploaded again", app.Version, app.BuildNumber, build.Attributes.ProcessingState)
+			artifact.Uploaded = app
+			result.AlreadyUploaded = true
+			if cfg.IfAlreadyUploaded == alreadyUploadedSkip && submitForReview {
+				log.Warnf("The already uploaded build is not submitted for review, set the If already uploaded input to continue_to_submit to submit it")
+				submitForReview = false
+			}
+		default:
+			result.Error = alreadyUploadedError(app, build).Error()
+			return result
+		}
 	}
 
-	if cfg.SkipAppVersionUpdate == "yes" {
-		args = append(args, "--skip_app_version_update")
+	if artifact != nil && artifact.Uploaded == nil && backend == uploadBackendAPI {
+		monitor := newUploadMonitor(os.Stdout, timeouts.Heartbeat, filepath.Base(artifact.Path), result.Artifact.Size)
+		if err := uploadWithAPI(cfg, timeouts, target, authConfig, artifact, result.Artifact.App, monitor); err != nil {
+			result.setRunError(err, monitor)
+			return result
+		}
+		artifact.Uploaded = result.Artifact.App
 	}
 
-	args = append(args, "--force")
-
-	if cfg.SubmitForReview == "yes" {
-		args = append(args, "--submit_for_review")
+	if artifact != nil && artifact.Uploaded != nil && !cfg.hasDeliverActions(submitForReview, options) {
+		log.Printf("Metadata, screenshots, app version update and review submission are skipped, deliver is not run")
+		result.Success = true
+		return result
+	}
+	if len(fastlane.CmdSlice) == 0 {
+		result.Error = "fastlane is not set up, deliver can't run"
+		return result
 	}
 
-	args = append(args, "--platform", cfg.Platform)
+	var monitor *uploadMonitor
+	if artifact != nil && artifact.Uploaded == nil {
+		monitor = newUploadMonitor(os.Stdout, timeouts.Heartbeat, filepath.Base(artifact.Path), result.Artifact.Size)
+	} else {
+		// deliver doesn't upload a binary, its heartbeat reports the deliver run
+		monitor = newUploadMonitor(os.Stdout, timeouts.Heartbeat, "", 0)
+	}
 
-	args = append(args, options...)
+	var deadline *phaseDeadline
+	if artifact != nil && artifact.Uploaded != nil && submitForReview {
+		// the binary is already uploaded, deliver only waits for App Store Connect to process it
+		deadline = startPhase("Processing", timeouts.Processing)
+	} else {
+		deadline = timeouts.deliverDeadline(submitForReview, artifact != nil && artifact.Uploaded == nil)
+	}
+	defer deadline.Stop()
+	monitor.Start()
+	defer monitor.Stop()
+	deliver := func(authParams FastlaneParams, detector *authFailureDetector) error {
+		invocation := newDeliverInvocation(cfg, target, authParams, artifact, backend, submitForReview, options)
+		cmdSlice := append(append([]string{}, fastlane.CmdSlice...), invocation.Args...)
+
+		cmd := newCommandSpec(cmdSlice[0], cmdSlice[1:]...).
+			withEnvs(fastlane.Envs...).
+			withEnvs(invocation.Envs...).
+			withDir(fastlane.WorkDir).
+			withOutput(detector.Writer(monitor.Writer(os.Stdout)), detector.Writer(monitor.Writer(os.Stderr))).
+			nonInteractive()
+		fmt.Println()
+		log.Donef("$ %s", cmd)
 
-	cmdSlice := append(fastlaneCmdSlice, args...)
+		fmt.Println()
+		return runner.withDeadline(deadline).Run(cmd)
+	}
 
-	cmd := command.New(cmdSlice[0], cmdSlice[1:]...)
-	fmt.Println()
-	log.Donef("$ %s", cmd.PrintableCommandArgs())
+	detector := &authFailureDetector{}
+	err = deliver(authParams, detector)
+	if len(fallbacks) > 0 && isAuthFailure(err, detector) {
+		fallback := fallbacks[0]
+		fmt.Println()
+		log.Warnf("Authentication failed (%s), retrying with the %s", authFailureReason(err, detector), fallback.Source)
 
-	cmd.SetStdout(os.Stdout)
-	cmd.SetStderr(os.Stderr)
-	cmd.SetStdin(os.Stdin)
-	if err := os.Unsetenv("FASTLANE_PASSWORD"); err != nil {
-		fail("Could not unset Fastlane password, reason: ", err)
+		// the auth files have fixed names, the fallback's ones get their own directory
+		fallbackDir := filepath.Join(tmpDir, "fallback")
+		if err := os.Mkdir(fallbackDir, 0700); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		fallbackParams, paramsErr := FastlaneAuthParams(fallback.Credentials, fallbackDir)
+		if paramsErr != nil {
+			result.Error = fmt.Sprintf("failed to set up Fastlane authentication parameters: %s", paramsErr)
+			return result
+		}
+		if err = deliver(fallbackParams, &authFailureDetector{}); err == nil {
+			log.Donef("Authenticated with the %s", fallback.Source)
+			result.AuthSource = fallback.Source
+		}
 	}
-	cmd.AppendEnvs(envs...)
-	if workDir != "" {
-		cmd.SetDir(workDir)
+	monitor.Stop()
+	if err != nil {
+		result.setRunError(err, monitor)
+		return result
 	}
 
-	fmt.Println()
+	result.Success = true
+	return result
+}
 
-	if err := cmd.Run(); err != nil {
-		if cfg.FastlaneVersion != latestPrerelease {
-			log.Warnf(fmt.Sprintf(`If you have issues, use the latest prerelease version of fastlane.
-Set the fastlane version input to "%s" to enable prerelease versions.`, latestPrerelease))
-		}
-		fail("Deploy failed, error: %s", err)
+// setRunError records the error of the upload or the deliver run, with the last upload progress if it timed out
+func (result *deployResult) setRunError(err error, monitor *uploadMonitor) {
+	result.Error = err.Error()
+	result.TimedOut = isTimeout(err)
+	if progress := monitor.Progress(); result.TimedOut && progress != "" {
+		result.Error += fmt.Sprintf(" (last reported upload progress: %s)", progress)
 	}
-
-	log.Donef("Success")
-	log.Printf("The app (.ipa) was successfully uploaded to [App Store Connect](https://appstoreconnect.apple.com), you should see it in the *Prerelease* section on the app's page!")
 }
 
-func normalizeArtifactPath(pth string) (string, error) {
-	tmpDir, err := pathutil.NormalizedOSTempDirPath("ipaOrPkg")
+// uploadWithAPI uploads the artifact with the App Store Connect build upload API, within the upload timeout
+func uploadWithAPI(cfg Config, timeouts stepTimeouts, target appTarget, authConfig appleauth.Credentials, artifact *deliverArtifact, app *artifactInfo, monitor *uploadMonitor) error {
+	client, err := newAppStoreConnectClient(authConfig.APIKey)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	tmpPath := filepath.Join(tmpDir, "tmp"+filepath.Ext(pth))
-	if err := command.CopyFile(pth, tmpPath); err != nil {
-		return "", err
+	fmt.Println()
+	deadline := startPhase("Upload", timeouts.Upload)
+	defer deadline.Stop()
+
+	uploader := apiUpload{client: client, target: target, platform: cfg.Platform, out: monitor.Writer(os.Stdout), options: apiUploadOptions}
+	monitor.Start()
+	defer monitor.Stop()
+	return uploader.upload(deadline, artifact.Path, app)
+}
+
+// runsDeliver reports whether deliver is run for any of the targets: a target without an artifact only runs deliver,
+// and the api backend only skips deliver if it has nothing else to do
+func (cfg Config) runsDeliver(backend uploadBackend, targets []appTarget, options []string) bool {
+	if backend != uploadBackendAPI || cfg.hasDeliverActions(cfg.SubmitForReview == "yes", options) {
+		return true
 	}
+	for _, target := range targets {
+		if pth, _ := target.artifact(); pth == "" {
+			return true
+		}
+	}
+	return false
+}
 
-	return tmpPath, nil
+// hasDeliverActions reports whether deliver has anything to do besides uploading the binary
+func (cfg Config) hasDeliverActions(submitForReview bool, options []string) bool {
+	return cfg.SkipMetadata != "yes" || cfg.SkipScreenshots != "yes" || cfg.SkipAppVersionUpdate != "yes" ||
+		submitForReview || len(options) > 0
 }
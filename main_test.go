@@ -1,20 +1,37 @@
 package main
 
 import (
+	"errors"
+	"os"
 	"path"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-xcode/appleauth"
 )
 
 func Test_ensureFastlaneVersionAndCreateCmdSlice(t *testing.T) {
 	gemfilePath := path.Join("testdata", "Gemfile")
 
+	noLockDir := t.TempDir()
+	writeTestFile(t, filepath.Join(noLockDir, "Gemfile"), `gem "fastlane"`)
+
+	noFastlaneDir := t.TempDir()
+	writeTestFile(t, filepath.Join(noFastlaneDir, "Gemfile"), `gem "cocoapods"`)
+	writeTestFile(t, filepath.Join(noFastlaneDir, "Gemfile.lock"), "GEM\n  specs:\n    cocoapods (1.14.3)\n\nBUNDLED WITH\n   2.4.12\n")
+
 	tests := []struct {
 		name         string
 		forceVersion string
 		gemfilePth   string
+		rubyManager  string
+		bundle       bundleConfig
+		results      map[string]fakeResult
 		want         []string
 		want1        string
+		wantCommands []string
 		wantErr      bool
 	}{
 		{
@@ -22,22 +39,387 @@ func Test_ensureFastlaneVersionAndCreateCmdSlice(t *testing.T) {
 			gemfilePth: gemfilePath,
 			want:       []string{"bundle", "_2.4.12_", "exec", "fastlane"},
 			want1:      "testdata",
-			wantErr:    false,
+			wantCommands: []string{
+				"gem install bundler --force --no-document --version 2.4.12",
+				"bundle _2.4.12_ install --jobs 20 --retry 5",
+			},
+			wantErr: false,
+		},
+		{
+			name:        "bundler install with the system Ruby",
+			gemfilePth:  gemfilePath,
+			rubyManager: rubyManagerSystem,
+			want:        []string{"bundle", "_2.4.12_", "exec", "fastlane"},
+			want1:       "testdata",
+			wantCommands: []string{
+				"sudo gem install bundler --force --no-document --version 2.4.12",
+				"sudo BUNDLE_GEMFILE=" + absPath(t, gemfilePath) + " bundle _2.4.12_ install --jobs 20 --retry 5",
+			},
+		},
+		{
+			name:        "bundler install into a bundle path with the system Ruby",
+			gemfilePth:  gemfilePath,
+			rubyManager: rubyManagerSystem,
+			bundle:      bundleConfig{Path: "vendor/bundle"},
+			want:        []string{"bundle", "_2.4.12_", "exec", "fastlane"},
+			want1:       "testdata",
+			wantCommands: []string{
+				"sudo gem install bundler --force --no-document --version 2.4.12",
+				"bundle _2.4.12_ install --jobs 20 --retry 5",
+			},
+		},
+		{
+			name:         "bundle install fails",
+			gemfilePth:   gemfilePath,
+			results:      map[string]fakeResult{"bundle _2.4.12_ install": {err: errors.New("exit status 1")}},
+			wantCommands: []string{"gem install bundler --force --no-document --version 2.4.12", "bundle _2.4.12_ install --jobs 20 --retry 5"},
+			wantErr:      true,
+		},
+		{
+			name:         "missing lockfile",
+			gemfilePth:   filepath.Join(noLockDir, "Gemfile"),
+			wantCommands: []string{"bundle install"},
+			wantErr:      true,
+		},
+		{
+			name:       "missing lockfile in frozen mode",
+			gemfilePth: filepath.Join(noLockDir, "Gemfile"),
+			bundle:     bundleConfig{Mode: bundleModeFrozen},
+			wantErr:    true,
+		},
+		{
+			name:       "fastlane not in lockfile",
+			gemfilePth: filepath.Join(noFastlaneDir, "Gemfile"),
+			want:       []string{"fastlane"},
+		},
+		{
+			name:       "Gemfile does not exist",
+			gemfilePth: filepath.Join(noLockDir, "Gemfile.missing"),
+			want:       []string{"fastlane"},
+		},
+		{
+			name: "no fastlane version nor Gemfile",
+			want: []string{"fastlane"},
+		},
+		{
+			name:         "latest stable",
+			forceVersion: latestStable,
+			gemfilePth:   gemfilePath,
+			want:         []string{"fastlane"},
+			wantCommands: []string{"gem install fastlane --no-document"},
+		},
+		{
+			name:         "latest prerelease",
+			forceVersion: latestPrerelease,
+			want:         []string{"fastlane"},
+			wantCommands: []string{"gem install fastlane --no-document --prerelease"},
+		},
+		{
+			name:         "exact version with rbenv",
+			forceVersion: "2.219.0",
+			rubyManager:  rubyManagerRbenv,
+			want:         []string{"fastlane", "_2.219.0_"},
+			wantCommands: []string{"gem install fastlane --no-document -v 2.219.0", "rbenv rehash"},
+		},
+		{
+			name:         "exact version with the system Ruby",
+			forceVersion: "2.219.0",
+			rubyManager:  rubyManagerSystem,
+			want:         []string{"fastlane", "_2.219.0_"},
+			wantCommands: []string{"sudo gem install fastlane --no-document -v 2.219.0"},
+		},
+		{
+			name:         "gem install fails",
+			forceVersion: "2.219.0",
+			results:      map[string]fakeResult{"gem install": {err: errors.New("exit status 1")}},
+			wantCommands: []string{
+				"gem install fastlane --no-document -v 2.219.0",
+				"gem install fastlane --no-document -v 2.219.0",
+				"gem install fastlane --no-document -v 2.219.0",
+			},
+			wantErr: true,
+		},
+		{
+			name:         "constraint satisfied by an installed version",
+			forceVersion: "~> 2.219",
+			results:      map[string]fakeResult{"gem list fastlane --exact --local": {output: "fastlane (2.220.0, 2.210.0)"}},
+			want:         []string{"fastlane", "_2.220.0_"},
+			wantCommands: []string{"gem list fastlane --exact --local"},
+		},
+		{
+			name:         "constraint resolved remotely",
+			forceVersion: ">= 2.219, < 3",
+			results: map[string]fakeResult{
+				"gem list fastlane --exact --local":  {output: "fastlane (2.210.0)"},
+				"gem list fastlane --exact --remote": {output: "fastlane (3.0.0, 2.225.0, 2.219.0)"},
+			},
+			want: []string{"fastlane", "_2.225.0_"},
+			wantCommands: []string{
+				"gem list fastlane --exact --local",
+				"gem list fastlane --exact --remote --all",
+				"gem install fastlane --no-document -v 2.225.0",
+			},
+		},
+		{
+			name:         "unsatisfiable constraint",
+			forceVersion: "~> 4.0",
+			results:      map[string]fakeResult{"gem list fastlane --exact --remote": {output: "fastlane (3.0.0, 2.225.0)"}},
+			wantCommands: []string{"gem list fastlane --exact --local", "gem list fastlane --exact --remote --all"},
+			wantErr:      true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, got1, err := ensureFastlaneVersionAndCreateCmdSlice(tt.forceVersion, tt.gemfilePth)
+			t.Setenv("BUNDLE_GEMFILE", "")
+
+			runner := newFakeRunner()
+			for commandLine, result := range tt.results {
+				runner.on(commandLine, result.output, result.err)
+			}
+			installer := fastlaneInstaller{
+				runner: runner,
+				ruby:   rubyToolchain{Version: "3.2.2", Manager: tt.rubyManager},
+				bundle: tt.bundle,
+			}
+
+			got, err := installer.ensureFastlaneVersionAndCreateCmdSlice(tt.forceVersion, tt.gemfilePth)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("ensureFastlaneVersionAndCreateCmdSlice() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("ensureFastlaneVersionAndCreateCmdSlice() got = %v, want %v", got, tt.want)
+			if !reflect.DeepEqual(runner.commandLines(), tt.wantCommands) {
+				t.Errorf("ensureFastlaneVersionAndCreateCmdSlice() commands = %q, want %q", runner.commandLines(), tt.wantCommands)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(got.CmdSlice, tt.want) {
+				t.Errorf("ensureFastlaneVersionAndCreateCmdSlice() got = %v, want %v", got.CmdSlice, tt.want)
 			}
-			if got1 != tt.want1 {
-				t.Errorf("ensureFastlaneVersionAndCreateCmdSlice() got1 = %v, want %v", got1, tt.want1)
+			if got.WorkDir != tt.want1 {
+				t.Errorf("ensureFastlaneVersionAndCreateCmdSlice() got1 = %v, want %v", got.WorkDir, tt.want1)
 			}
 		})
 	}
 }
+
+func Test_ensureFastlaneVersionAndCreateCmdSlice_bundleEnvs(t *testing.T) {
+	runner := newFakeRunner()
+	installer := fastlaneInstaller{
+		runner: runner,
+		bundle: bundleConfig{Without: []string{"development"}, Mode: bundleModeDeployment},
+	}
+
+	got, err := installer.ensureFastlaneVersionAndCreateCmdSlice("", path.Join("testdata", "Gemfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantEnvs := []string{
+		"BUNDLE_GEMFILE=" + absPath(t, path.Join("testdata", "Gemfile")),
+		"BUNDLE_WITHOUT=development",
+		"BUNDLE_DEPLOYMENT=true",
+	}
+	if !reflect.DeepEqual(got.Envs, wantEnvs) {
+		t.Errorf("Envs = %v, want %v", got.Envs, wantEnvs)
+	}
+
+	bundleInstall := runner.commands[len(runner.commands)-1]
+	if !reflect.DeepEqual(bundleInstall.Envs, wantEnvs) {
+		t.Errorf("bundle install envs = %v, want %v", bundleInstall.Envs, wantEnvs)
+	}
+	if bundleInstall.Dir != "testdata" {
+		t.Errorf("bundle install dir = %s, want testdata", bundleInstall.Dir)
+	}
+}
+
+func Test_ensureFastlaneVersionAndCreateCmdSlice_cache(t *testing.T) {
+	cache := newFastlaneCache(t.TempDir())
+
+	runner := newFakeRunner().on("ruby -e", "3.2.2-arm64-darwin23", nil)
+	installer := fastlaneInstaller{runner: runner, cache: cache}
+
+	if _, err := installer.ensureFastlaneVersionAndCreateCmdSlice("2.219.0", ""); err != nil {
+		t.Fatal(err)
+	}
+	wantCommands := []string{
+		`ruby -e print RUBY_VERSION, "-", RUBY_PLATFORM`,
+		"gem install fastlane --version 2.219.0 --no-document",
+	}
+	if !reflect.DeepEqual(runner.commandLines(), wantCommands) {
+		t.Errorf("commands on cache miss = %q, want %q", runner.commandLines(), wantCommands)
+	}
+
+	runner.commands = nil
+	got, err := installer.ensureFastlaneVersionAndCreateCmdSlice("2.219.0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantCommands = []string{`ruby -e print RUBY_VERSION, "-", RUBY_PLATFORM`}
+	if !reflect.DeepEqual(runner.commandLines(), wantCommands) {
+		t.Errorf("commands on cache hit = %q, want %q", runner.commandLines(), wantCommands)
+	}
+	if !hasEnv(got.Envs, "GEM_HOME") {
+		t.Errorf("Envs = %v, want GEM_HOME set", got.Envs)
+	}
+}
+
+func Test_deployTarget(t *testing.T) {
+	ipaPth := filepath.Join(t.TempDir(), "app.ipa")
+	writeTestFile(t, ipaPth, "ipa")
+
+	cfg := Config{
+		SkipScreenshots:      "yes",
+		SkipMetadata:         "no",
+		SkipAppVersionUpdate: "no",
+		SubmitForReview:      "yes",
+		Platform:             "ios",
+	}
+	target := appTarget{IpaPath: ipaPth, BundleID: "com.example.app", TeamID: "ABCDE12345"}
+	authConfig := appleauth.Credentials{AppleID: &appleauth.AppleID{Username: "user@example.com", Password: "password"}}
+	fastlane := fastlaneInstallation{
+		CmdSlice: []string{"bundle", "_2.4.12_", "exec", "fastlane"},
+		WorkDir:  "testdata",
+		Envs:     []string{"BUNDLE_GEMFILE=/project/Gemfile"},
+	}
+
+	runner := newFakeRunner()
+	result := deployTarget(runner, cfg, stepTimeouts{}, target, authConfig, fastlane, uploadBackendTransporter, []string{"--verbose"})
+	if !result.Success {
+		t.Fatalf("deployTarget() failed: %s", result.Error)
+	}
+
+	wantCommands := []string{
+		"bundle _2.4.12_ exec fastlane deliver --username user@example.com --app_identifier com.example.app --team_id ABCDE12345 " +
+			"--ipa " + ipaPth + " --skip_screenshots --force --submit_for_review --platform ios --verbose",
+	}
+	if !reflect.DeepEqual(runner.commandLines(), wantCommands) {
+		t.Fatalf("deployTarget() commands = %q, want %q", runner.commandLines(), wantCommands)
+	}
+
+	deliver := runner.commands[0]
+	if deliver.Dir != "testdata" {
+		t.Errorf("deliver dir = %s, want testdata", deliver.Dir)
+	}
+	for _, env := range []string{"BUNDLE_GEMFILE", "ITMSTRANSPORTER_FORCE_ITMS_PACKAGE_UPLOAD", "DELIVER_PASSWORD", "SPACESHIP_SKIP_2FA_UPGRADE"} {
+		if !hasEnv(deliver.Envs, env) {
+			t.Errorf("deliver envs = %v, want %s set", deliver.Envs, env)
+		}
+	}
+}
+
+func Test_deployTarget_failure(t *testing.T) {
+	runner := newFakeRunner().on("fastlane deliver", "", errors.New("exit status 1"))
+	target := appTarget{AppID: "1234567890"}
+	fastlane := fastlaneInstallation{CmdSlice: []string{"fastlane"}}
+
+	result := deployTarget(runner, Config{Platform: "osx"}, stepTimeouts{}, target, appleauth.Credentials{}, fastlane, uploadBackendAltool, nil)
+	if result.Success || result.Error != "exit status 1" {
+		t.Errorf("deployTarget() = %+v, want the deliver error", result)
+	}
+
+	wantCommands := []string{"fastlane deliver --app 1234567890 --force --platform osx"}
+	if !reflect.DeepEqual(runner.commandLines(), wantCommands) {
+		t.Errorf("deployTarget() commands = %q, want %q", runner.commandLines(), wantCommands)
+	}
+}
+
+func Test_deployTarget_timeout(t *testing.T) {
+	runner := newFakeRunner().on("fastlane deliver", "", timeoutError{Phase: "Upload", Timeout: time.Hour})
+	fastlane := fastlaneInstallation{CmdSlice: []string{"fastlane"}}
+
+	result := deployTarget(runner, Config{Platform: "ios"}, stepTimeouts{Upload: time.Hour}, appTarget{AppID: "1234567890"}, appleauth.Credentials{}, fastlane, uploadBackendAltool, nil)
+	if result.Success || !result.TimedOut || result.Error != "Upload timed out after 1h0m0s" {
+		t.Errorf("deployTarget() = %+v, want a timed out result", result)
+	}
+}
+
+func TestConfig_timeouts(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		want    stepTimeouts
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			cfg:  Config{SetupTimeout: "30m", UploadTimeout: "1h", ProcessingTimeout: "1h", TerminationGracePeriod: "10s"},
+			want: stepTimeouts{Setup: 30 * time.Minute, Upload: time.Hour, Processing: time.Hour, GracePeriod: 10 * time.Second},
+		},
+		{
+			name: "no limits",
+			cfg:  Config{Timeout: "0", SetupTimeout: "0"},
+			want: stepTimeouts{GracePeriod: defaultTerminationGracePeriod},
+		},
+		{
+			name: "Step timeout",
+			cfg:  Config{Timeout: "1h30m"},
+			want: stepTimeouts{Step: 90 * time.Minute, GracePeriod: defaultTerminationGracePeriod},
+		},
+		{
+			name:    "missing unit",
+			cfg:     Config{UploadTimeout: "60"},
+			wantErr: true,
+		},
+		{
+			name:    "negative",
+			cfg:     Config{SetupTimeout: "-1m"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.cfg.timeouts()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("timeouts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("timeouts() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_stepTimeouts_deliverDeadline(t *testing.T) {
+	timeouts := stepTimeouts{Upload: time.Hour, Processing: 30 * time.Minute}
+
+	tests := []struct {
+		name            string
+		timeouts        stepTimeouts
+		submitForReview bool
+		hasArtifact     bool
+		wantPhase       string
+		wantTimeout     time.Duration
+	}{
+		{name: "upload", timeouts: timeouts, hasArtifact: true, wantPhase: "Upload", wantTimeout: time.Hour},
+		{name: "metadata only submission", timeouts: timeouts, submitForReview: true, wantPhase: "Upload", wantTimeout: time.Hour},
+		{name: "upload and submission", timeouts: timeouts, submitForReview: true, hasArtifact: true, wantPhase: "Upload and processing", wantTimeout: 90 * time.Minute},
+		{name: "no processing limit", timeouts: stepTimeouts{Upload: time.Hour}, submitForReview: true, hasArtifact: true, wantPhase: "Upload and processing", wantTimeout: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deadline := tt.timeouts.deliverDeadline(tt.submitForReview, tt.hasArtifact)
+			defer deadline.Stop()
+
+			if deadline.Phase != tt.wantPhase || deadline.Timeout != tt.wantTimeout {
+				t.Errorf("deliverDeadline() = %s (%s), want %s (%s)", deadline.Phase, deadline.Timeout, tt.wantPhase, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func writeTestFile(t *testing.T, pth, content string) {
+	t.Helper()
+	if err := os.WriteFile(pth, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func absPath(t *testing.T, pth string) string {
+	t.Helper()
+	absPth, err := filepath.Abs(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return absPth
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bitrise-io/go-steputils/tools"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const deployResultsEnvKey = "DELIVER_APP_RESULTS"
+
+// appTarget is a single app to deliver: an artifact and the App Store Connect app it belongs to
+type appTarget struct {
+	Name         string `json:"name"`
+	IpaPath      string `json:"ipa_path"`
+	PkgPath      string `json:"pkg_path"`
+	SHA256       string `json:"sha256"`
+	AppID        string `json:"app_id"`
+	BundleID     string `json:"bundle_id"`
+	TeamID       string `json:"team_id"`
+	TeamName     string `json:"team_name"`
+	MetadataPath string `json:"metadata_path"`
+}
+
+// appsManifest is the content of the file referenced by the apps_manifest_path input
+//
+//	{
+//	  "apps": [
+//	    {"name": "Brand A", "ipa_path": "./brand_a.ipa", "bundle_id": "com.example.a", "team_id": "ABCDE12345", "metadata_path": "./metadata/brand_a"},
+//	    {"name": "Brand B", "ipa_path": "./brand_b.ipa", "app_id": "1234567890"}
+//	  ]
+//	}
+type appsManifest struct {
+	Apps []appTarget `json:"apps"`
+}
+
+// deployResult is the outcome of delivering a single app, exported as JSON in DELIVER_APP_RESULTS
+type deployResult struct {
+	Name     string `json:"name"`
+	AppID    string `json:"app_id,omitempty"`
+	BundleID string `json:"bundle_id,omitempty"`
+	Success  bool   `json:"success"`
+	Error    string `json:"error,omitempty"`
+	// TimedOut is set when the upload was stopped because of the upload or processing timeout
+	TimedOut bool `json:"timed_out,omitempty"`
+	// AlreadyUploaded is set when the build was found in App Store Connect, and was not uploaded again
+	AlreadyUploaded bool `json:"already_uploaded,omitempty"`
+	// AuthSource is the authentication source deliver succeeded with
+	AuthSource string `json:"auth_source,omitempty"`
+	Duration   string `json:"duration"`
+
+	Artifact *artifactProvenance `json:"artifact,omitempty"`
+}
+
+func (target appTarget) displayName() string {
+	switch {
+	case target.Name != "":
+		return target.Name
+	case target.BundleID != "":
+		return target.BundleID
+	case target.AppID != "":
+		return target.AppID
+	case target.IpaPath != "":
+		return filepath.Base(redactURL(target.IpaPath))
+	default:
+		return filepath.Base(redactURL(target.PkgPath))
+	}
+}
+
+// artifact returns the artifact path and the matching deliver argument
+func (target appTarget) artifact() (string, string) {
+	if target.IpaPath != "" {
+		return target.IpaPath, "--ipa"
+	}
+	return target.PkgPath, "--pkg"
+}
+
+func (target appTarget) validate() error {
+	if target.IpaPath == "" && target.PkgPath == "" {
+		return fmt.Errorf("no ipa_path nor pkg_path specified")
+	}
+
+	if target.AppID == "" && target.BundleID == "" {
+		return fmt.Errorf("no app_id or bundle_id specified")
+	}
+
+	return nil
+}
+
+// deployTargets returns the apps to deliver: the manifest entries if a manifest is provided,
+// otherwise a single app described by the step inputs.
+func (cfg Config) deployTargets() ([]appTarget, error) {
+	if cfg.AppsManifestPath == "" {
+		return []appTarget{{
+			IpaPath:  cfg.IpaPath,
+			PkgPath:  cfg.PkgPath,
+			SHA256:   cfg.ArtifactSHA256,
+			AppID:    cfg.AppID,
+			BundleID: cfg.BundleID,
+			TeamID:   cfg.TeamID,
+			TeamName: cfg.TeamName,
+		}}, nil
+	}
+
+	return parseAppsManifest(cfg.AppsManifestPath)
+}
+
+func parseAppsManifest(pth string) ([]appTarget, error) {
+	content, err := os.ReadFile(pth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read apps manifest: %s", err)
+	}
+
+	var manifest appsManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse apps manifest (%s): %s", pth, err)
+	}
+
+	if len(manifest.Apps) == 0 {
+		return nil, fmt.Errorf("apps manifest (%s) contains no apps", pth)
+	}
+
+	// Relative paths in the manifest are relative to the manifest itself
+	manifestDir := filepath.Dir(pth)
+	for i, app := range manifest.Apps {
+		if err := app.validate(); err != nil {
+			return nil, fmt.Errorf("apps manifest entry #%d (%s): %s", i+1, app.displayName(), err)
+		}
+
+		app.IpaPath = resolveManifestPath(manifestDir, app.IpaPath)
+		app.PkgPath = resolveManifestPath(manifestDir, app.PkgPath)
+		app.MetadataPath = resolveManifestPath(manifestDir, app.MetadataPath)
+		manifest.Apps[i] = app
+	}
+
+	return manifest.Apps, nil
+}
+
+func resolveManifestPath(manifestDir, pth string) string {
+	if pth == "" || filepath.IsAbs(pth) || isRemoteArtifact(pth) {
+		return pth
+	}
+	return filepath.Join(manifestDir, pth)
+}
+
+func failedCount(results []deployResult) int {
+	count := 0
+	for _, result := range results {
+		if !result.Success {
+			count++
+		}
+	}
+	return count
+}
+
+func timedOutCount(results []deployResult) int {
+	count := 0
+	for _, result := range results {
+		if result.TimedOut {
+			count++
+		}
+	}
+	return count
+}
+
+func printDeployResults(results []deployResult) {
+	fmt.Println()
+	log.Infof("Summary")
+
+	for _, result := range results {
+		if result.Success && result.AlreadyUploaded {
+			log.Donef("- %s: already uploaded (%s)", result.Name, result.Duration)
+		} else if result.Success {
+			log.Donef("- %s: uploaded (%s)", result.Name, result.Duration)
+		} else if result.TimedOut {
+			log.Errorf("- %s: timed out (%s): %s", result.Name, result.Duration, result.Error)
+		} else {
+			log.Errorf("- %s: failed (%s): %s", result.Name, result.Duration, result.Error)
+		}
+	}
+}
+
+func exportDeployResults(results []deployResult) error {
+	content, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+
+	return tools.ExportEnvironmentWithEnvman(deployResultsEnvKey, string(content))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_parseAppsManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		want     func(manifestDir string) []appTarget
+		wantErr  bool
+	}{
+		{
+			name:     "relative paths are resolved to the manifest dir",
+			manifest: `{"apps": [{"name": "A", "ipa_path": "a.ipa", "bundle_id": "com.example.a", "team_id": "TEAM", "metadata_path": "metadata/a"}, {"pkg_path": "/abs/b.pkg", "app_id": "123"}]}`,
+			want: func(manifestDir string) []appTarget {
+				return []appTarget{
+					{Name: "A", IpaPath: filepath.Join(manifestDir, "a.ipa"), BundleID: "com.example.a", TeamID: "TEAM", MetadataPath: filepath.Join(manifestDir, "metadata/a")},
+					{PkgPath: "/abs/b.pkg", AppID: "123"},
+				}
+			},
+		},
+		{
+			name:     "missing artifact",
+			manifest: `{"apps": [{"bundle_id": "com.example.a"}]}`,
+			wantErr:  true,
+		},
+		{
+			name:     "missing app identifier",
+			manifest: `{"apps": [{"ipa_path": "a.ipa"}]}`,
+			wantErr:  true,
+		},
+		{
+			name:     "empty manifest",
+			manifest: `{"apps": []}`,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			pth := filepath.Join(dir, "manifest.json")
+			if err := os.WriteFile(pth, []byte(tt.manifest), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := parseAppsManifest(pth)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseAppsManifest() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if want := tt.want(dir); !reflect.DeepEqual(got, want) {
+				t.Errorf("parseAppsManifest() got = %v, want %v", got, want)
+			}
+		})
+	}
+}
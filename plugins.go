@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-io/go-utils/retry"
+)
+
+// fastlanePlugin is a gem listed in a fastlane Pluginfile
+type fastlanePlugin struct {
+	Name         string
+	Requirements []string
+	// Options are the gem options other than version requirements (git:, path:, ...), these can only be installed by bundler
+	Options string
+}
+
+var (
+	pluginGemRegexp         = regexp.MustCompile(`^gem\s*\(?\s*['"]([^'"]+)['"]\s*(.*?)\)?$`)
+	pluginRequirementRegexp = regexp.MustCompile(`^,\s*['"]([^'"]+)['"]\s*`)
+)
+
+// findPluginfile returns the fastlane/Pluginfile next to the Gemfile or in the working directory, if any
+func findPluginfile(gemfilePth string) string {
+	var dirs []string
+	if gemfilePth != "" {
+		dirs = append(dirs, filepath.Dir(gemfilePth))
+	}
+	dirs = append(dirs, ".")
+
+	for _, dir := range dirs {
+		pth := filepath.Join(dir, "fastlane", "Pluginfile")
+		if exist, err := pathutil.IsPathExists(pth); err == nil && exist {
+			return pth
+		}
+	}
+	return ""
+}
+
+// parsePluginfile returns the gems of a Pluginfile, which is a Gemfile fragment, for example:
+// gem 'fastlane-plugin-versioning', '~> 0.5'
+func parsePluginfile(content string) []fastlanePlugin {
+	var plugins []fastlanePlugin
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if i := strings.Index(line, "#"); i != -1 {
+			line = strings.TrimSpace(line[:i])
+		}
+
+		match := pluginGemRegexp.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		plugin := fastlanePlugin{Name: match[1]}
+		rest := match[2]
+		for {
+			requirement := pluginRequirementRegexp.FindStringSubmatch(rest)
+			if requirement == nil {
+				break
+			}
+			plugin.Requirements = append(plugin.Requirements, requirement[1])
+			rest = rest[len(requirement[0]):]
+		}
+		plugin.Options = strings.TrimSpace(strings.TrimPrefix(rest, ","))
+
+		plugins = append(plugins, plugin)
+	}
+	return plugins
+}
+
+// ensureFastlanePlugins makes the plugins of the project's Pluginfile available to fastlane.
+// When fastlane runs with bundler, the Gemfile is expected to load the Pluginfile. Otherwise the plugins are installed
+// next to fastlane, and fastlane is pointed to a generated Gemfile loading the Pluginfile, as fastlane discovers plugins from a Gemfile.
+func (i fastlaneInstaller) ensureFastlanePlugins(fastlane fastlaneInstallation, gemfilePth string) (fastlaneInstallation, error) {
+	pluginfilePth := findPluginfile(gemfilePth)
+	if pluginfilePth == "" {
+		return fastlane, nil
+	}
+
+	if isBundledFastlane(fastlane) {
+		if content, err := os.ReadFile(gemfilePth); err == nil && !strings.Contains(string(content), "Pluginfile") {
+			log.Warnf("Pluginfile found at %s, but the Gemfile (%s) doesn't load it, the plugins will be missing", pluginfilePth, gemfilePth)
+			log.Warnf("Add to the Gemfile: eval_gemfile(%q)", filepath.Join("fastlane", "Pluginfile"))
+		}
+		return fastlane, nil
+	}
+
+	content, err := os.ReadFile(pluginfilePth)
+	if err != nil {
+		return fastlaneInstallation{}, err
+	}
+	plugins := parsePluginfile(string(content))
+	if len(plugins) == 0 {
+		return fastlane, nil
+	}
+
+	fmt.Println()
+	log.Infof("Installing fastlane plugins from %s", pluginfilePth)
+
+	for _, plugin := range plugins {
+		if plugin.Options != "" {
+			return fastlaneInstallation{}, fmt.Errorf("plugin %s (%s) can only be installed by bundler, list fastlane and the Pluginfile in a Gemfile", plugin.Name, plugin.Options)
+		}
+		if err := i.gemInstallPluginWithRetry(plugin, fastlane.Envs); err != nil {
+			return fastlaneInstallation{}, err
+		}
+	}
+
+	// without a pinned fastlane, bundler could load another installed fastlane version than the selected one
+	version, err := i.fastlaneGemVersion(fastlane)
+	if err != nil {
+		return fastlaneInstallation{}, err
+	}
+
+	absPluginfilePth, err := filepath.Abs(pluginfilePth)
+	if err != nil {
+		return fastlaneInstallation{}, err
+	}
+	tmpDir, err := tempFiles.MkdirTemp("fastlane_plugins")
+	if err != nil {
+		return fastlaneInstallation{}, err
+	}
+	pluginGemfilePth := filepath.Join(tmpDir, "Gemfile")
+	pluginGemfile := fmt.Sprintf("source \"https://rubygems.org\"\n\ngem \"fastlane\", \"= %s\"\neval_gemfile(%q)\n", version, absPluginfilePth)
+	if err := os.WriteFile(pluginGemfilePth, []byte(pluginGemfile), 0600); err != nil {
+		return fastlaneInstallation{}, err
+	}
+
+	fastlane.Envs = append(append([]string{}, fastlane.Envs...), "BUNDLE_GEMFILE="+pluginGemfilePth)
+	return fastlane, nil
+}
+
+// fastlaneGemVersion returns the version of the fastlane gem the installation runs: the _version_ argument,
+// or the version printed by fastlane -v if the latest installed fastlane is used
+func (i fastlaneInstaller) fastlaneGemVersion(fastlane fastlaneInstallation) (string, error) {
+	for _, arg := range fastlane.CmdSlice {
+		if len(arg) > 2 && strings.HasPrefix(arg, "_") && strings.HasSuffix(arg, "_") {
+			return strings.Trim(arg, "_"), nil
+		}
+	}
+
+	cmdSlice := append(append([]string{}, fastlane.CmdSlice...), "-v")
+	cmd := newCommandSpec(cmdSlice[0], cmdSlice[1:]...).withEnvs(fastlane.Envs...).withDir(fastlane.WorkDir)
+	out, err := i.runner.CombinedOutput(cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the fastlane version, output: %s, error: %s", out, err)
+	}
+	version := parseFastlaneVersion(out)
+	if version == "" {
+		return "", fmt.Errorf("failed to read the fastlane version from the output: %s", out)
+	}
+	return version, nil
+}
+
+// gemInstallPluginWithRetry installs a plugin, unless a version satisfying its requirements is already installed
+func (i fastlaneInstaller) gemInstallPluginWithRetry(plugin fastlanePlugin, envs []string) error {
+	args := []string{"install", plugin.Name, "--conservative", "--no-document"}
+	for _, requirement := range plugin.Requirements {
+		args = append(args, "--version", requirement)
+	}
+
+	return retry.Times(2).Try(func(attempt uint) error {
+		if attempt > 0 {
+			log.Warnf("%d attempt failed", attempt)
+		}
+
+		// Plugins installed next to a cached fastlane go to the cache's gem home, which never needs sudo
+		cmd := newCommandSpec("gem", args...).withEnvs(envs...)
+		if len(envs) == 0 {
+			cmd = i.gemCommand(nil, "gem", args...)
+		}
+
+		fmt.Println()
+		log.Donef("$ %s", cmd)
+		if out, err := i.runner.CombinedOutput(cmd); err != nil {
+			return fmt.Errorf("gem install command failed, output: %s, error: %s", out, err)
+		}
+
+		return nil
+	})
+}
+
+func isBundledFastlane(fastlane fastlaneInstallation) bool {
+	return len(fastlane.CmdSlice) > 0 && fastlane.CmdSlice[0] == "bundle"
+}
+
+// logFastlanePlugins prints the plugins available to fastlane, as listed by `gem list` in fastlane's environment
+func logFastlanePlugins(runner commandRunner, fastlane fastlaneInstallation) {
+	var cmdSlice []string
+	if isBundledFastlane(fastlane) {
+		// bundle [_version_] exec gem list only lists the gems of the bundle
+		cmdSlice = append(cmdSlice, fastlane.CmdSlice[:len(fastlane.CmdSlice)-1]...)
+	}
+	cmdSlice = append(cmdSlice, "gem", "list", "^fastlane-plugin-")
+
+	cmd := newCommandSpec(cmdSlice[0], cmdSlice[1:]...).withEnvs(fastlane.Envs...).withDir(fastlane.WorkDir)
+	out, err := runner.Output(cmd)
+	if err != nil {
+		log.Debugf("Failed to list fastlane plugins: %s", err)
+		return
+	}
+
+	var plugins []string
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "fastlane-plugin-") {
+			plugins = append(plugins, line)
+		}
+	}
+	if len(plugins) == 0 {
+		return
+	}
+
+	log.Printf("fastlane plugins:")
+	for _, plugin := range plugins {
+		log.Printf("- %s", plugin)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parsePluginfile(t *testing.T) {
+	content := `# Autogenerated by fastlane
+#
+# Ensure this file is checked in to source control!
+
+gem 'fastlane-plugin-versioning'
+gem "fastlane-plugin-firebase_app_distribution", "~> 0.7" # pinned
+gem('fastlane-plugin-sentry', '>= 1.15', '< 2')
+gem 'fastlane-plugin-internal', git: 'https://github.com/example/fastlane-plugin-internal'
+`
+
+	want := []fastlanePlugin{
+		{Name: "fastlane-plugin-versioning"},
+		{Name: "fastlane-plugin-firebase_app_distribution", Requirements: []string{"~> 0.7"}},
+		{Name: "fastlane-plugin-sentry", Requirements: []string{">= 1.15", "< 2"}},
+		{Name: "fastlane-plugin-internal", Options: "git: 'https://github.com/example/fastlane-plugin-internal'"},
+	}
+	if got := parsePluginfile(content); !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePluginfile() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_isBundledFastlane(t *testing.T) {
+	tests := []struct {
+		cmdSlice []string
+		want     bool
+	}{
+		{cmdSlice: []string{"bundle", "_2.4.12_", "exec", "fastlane"}, want: true},
+		{cmdSlice: []string{"fastlane", "_2.219.0_"}, want: false},
+		{cmdSlice: []string{"fastlane"}, want: false},
+	}
+	for _, tt := range tests {
+		if got := isBundledFastlane(fastlaneInstallation{CmdSlice: tt.cmdSlice}); got != tt.want {
+			t.Errorf("isBundledFastlane(%v) = %v, want %v", tt.cmdSlice, got, tt.want)
+		}
+	}
+}
+
+func Test_fastlaneInstaller_ensureFastlanePlugins(t *testing.T) {
+	tests := []struct {
+		name     string
+		cmdSlice []string
+		want     string
+	}{
+		{name: "selected version", cmdSlice: []string{"fastlane", "_2.217.0_"}, want: `gem "fastlane", "= 2.217.0"`},
+		{name: "latest installed version", cmdSlice: []string{"fastlane"}, want: `gem "fastlane", "= 2.219.0"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(dir, "fastlane"), 0700); err != nil {
+				t.Fatal(err)
+			}
+			writeTestFile(t, filepath.Join(dir, "fastlane", "Pluginfile"), "gem 'fastlane-plugin-versioning'\n")
+
+			runner := newFakeRunner().on("fastlane -v", "fastlane installation at path:\n-----------------------------\nfastlane 2.219.0", nil)
+			installer := fastlaneInstaller{runner: runner, ruby: rubyToolchain{Manager: rubyManagerRbenv}}
+			got, err := installer.ensureFastlanePlugins(fastlaneInstallation{CmdSlice: tt.cmdSlice}, filepath.Join(dir, "Gemfile"))
+			if err != nil {
+				t.Fatalf("ensureFastlanePlugins() error = %v", err)
+			}
+
+			var gemfilePth string
+			for _, env := range got.Envs {
+				if strings.HasPrefix(env, "BUNDLE_GEMFILE=") {
+					gemfilePth = strings.TrimPrefix(env, "BUNDLE_GEMFILE=")
+				}
+			}
+			content, err := os.ReadFile(gemfilePth)
+			if err != nil {
+				t.Fatalf("plugin Gemfile: %v", err)
+			}
+			if !strings.Contains(string(content), tt.want+"\n") {
+				t.Errorf("plugin Gemfile = %q, want %s", content, tt.want)
+			}
+		})
+	}
+}
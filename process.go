@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// timeoutExitCode is the exit code of the Step when a timeout is hit, the same as the timeout(1) command's
+const timeoutExitCode = 124
+
+const defaultTerminationGracePeriod = 10 * time.Second
+
+// timeoutError is returned for a command stopped because its phase (setup, upload) timed out
+type timeoutError struct {
+	Phase   string
+	Timeout time.Duration
+}
+
+func (e timeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %s", e.Phase, e.Timeout)
+}
+
+func isTimeout(err error) bool {
+	var timeoutErr timeoutError
+	return errors.As(err, &timeoutErr)
+}
+
+// phaseDeadline limits how long the commands of a phase of the Step can run in total
+type phaseDeadline struct {
+	Phase   string
+	Timeout time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// startPhase starts the clock of a phase, a zero timeout means no limit
+func startPhase(phase string, timeout time.Duration) *phaseDeadline {
+	d := &phaseDeadline{Phase: phase, Timeout: timeout}
+	if timeout > 0 {
+		d.ctx, d.cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		d.ctx, d.cancel = context.WithCancel(context.Background())
+	}
+	return d
+}
+
+// Stop releases the phase's timer, call it when the phase is over
+func (d *phaseDeadline) Stop() {
+	if d != nil {
+		d.cancel()
+	}
+}
+
+// Exceeded reports whether the phase ran out of time
+func (d *phaseDeadline) Exceeded() bool {
+	return d != nil && errors.Is(d.ctx.Err(), context.DeadlineExceeded)
+}
+
+// context is cancelled when the phase runs out of time or stops, for work done in the Step's own process
+func (d *phaseDeadline) context() context.Context {
+	if d == nil {
+		return context.Background()
+	}
+	return d.ctx
+}
+
+func (d *phaseDeadline) done() <-chan struct{} {
+	if d == nil {
+		return nil
+	}
+	return d.ctx.Done()
+}
+
+func (d *phaseDeadline) err() error {
+	return timeoutError{Phase: d.Phase, Timeout: d.Timeout}
+}
+
+// processGroups tracks the running commands of the Step. Each command runs in its own process group, so that
+// stopping it also stops the processes it started (for example the Java process of the iTunes Transporter).
+type processGroups struct {
+	mu          sync.Mutex
+	running     map[int]runningProcess
+	gracePeriod time.Duration
+	// stopping is set once the Step is exiting, no more commands are run from then on
+	stopping bool
+}
+
+type runningProcess struct {
+	name   string
+	exited chan struct{}
+}
+
+var childProcesses = &processGroups{running: map[int]runningProcess{}, gracePeriod: defaultTerminationGracePeriod}
+
+// SetGracePeriod sets how long a stopped command has to exit after SIGTERM, before it is killed
+func (g *processGroups) SetGracePeriod(gracePeriod time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.gracePeriod = gracePeriod
+}
+
+// run runs the command in a new process group, and stops the group when the deadline is reached
+// or when an error is received from abort
+func (g *processGroups) run(cmd *exec.Cmd, deadline *phaseDeadline, abort <-chan error) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+
+	g.mu.Lock()
+	gracePeriod, stopping := g.gracePeriod, g.stopping
+	g.mu.Unlock()
+	if stopping {
+		waitForExit()
+	}
+	// Processes left behind in the group could keep the output pipes open
+	cmd.WaitDelay = gracePeriod
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	pid := cmd.Process.Pid
+	process := runningProcess{name: filepath.Base(cmd.Path), exited: make(chan struct{})}
+	g.mu.Lock()
+	g.running[pid] = process
+	g.mu.Unlock()
+
+	result := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+
+		g.mu.Lock()
+		delete(g.running, pid)
+		g.mu.Unlock()
+		close(process.exited)
+
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		g.mu.Lock()
+		stopping := g.stopping
+		g.mu.Unlock()
+		if stopping {
+			// The command was stopped by terminateAll, the caller must not go on with the command's failure
+			waitForExit()
+		}
+		return err
+	case <-deadline.done():
+		if !deadline.Exceeded() {
+			return <-result
+		}
+		log.Errorf("%s, stopping %s", deadline.err(), process.name)
+		stopProcessGroup(pid, process.exited, gracePeriod)
+		<-result
+		return deadline.err()
+	case err := <-abort:
+		log.Errorf("%s, stopping %s", err, process.name)
+		stopProcessGroup(pid, process.exited, gracePeriod)
+		<-result
+		return err
+	}
+}
+
+// terminateAll stops all running commands
+func (g *processGroups) terminateAll() {
+	g.mu.Lock()
+	g.stopping = true
+	running := make(map[int]runningProcess, len(g.running))
+	for pid, process := range g.running {
+		running[pid] = process
+	}
+	gracePeriod := g.gracePeriod
+	g.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for pid, process := range running {
+		log.Warnf("Stopping %s", process.name)
+
+		wg.Add(1)
+		go func(pid int, process runningProcess) {
+			defer wg.Done()
+			stopProcessGroup(pid, process.exited, gracePeriod)
+		}(pid, process)
+	}
+	wg.Wait()
+}
+
+// stopProcessGroup sends SIGTERM to the process group, and SIGKILL if its leader did not exit within the grace period
+func stopProcessGroup(pid int, exited <-chan struct{}, gracePeriod time.Duration) {
+	if err := syscall.Kill(-pid, syscall.SIGTERM); err != nil {
+		log.Debugf("Failed to send SIGTERM to process group %d: %s", pid, err)
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(gracePeriod):
+		log.Warnf("Process %d did not exit in %s, killing it", pid, gracePeriod)
+	}
+	// Kill the processes of the group which outlived the leader too
+	if err := syscall.Kill(-pid, syscall.SIGKILL); err != nil && !errors.Is(err, syscall.ESRCH) {
+		log.Debugf("Failed to send SIGKILL to process group %d: %s", pid, err)
+	}
+}
+
+var exitMu sync.Mutex
+
+// waitForExit blocks the calling goroutine until exitStep exits the process
+func waitForExit() {
+	exitMu.Lock()
+	select {}
+}
+
+// exitStep stops the running commands, removes the temporary files and exits.
+// Only the first call proceeds: when a signal or the Step timeout stops a command,
+// the main flow failing because of the stopped command does not change the exit code.
+func exitStep(code int) {
+	exitMu.Lock()
+	childProcesses.terminateAll()
+	tempFiles.Cleanup()
+	os.Exit(code)
+}
+
+// exitOnSignal forwards SIGINT and SIGTERM to the running commands, and exits once they stopped
+func exitOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		fmt.Println()
+		log.Warnf("Received %s, stopping the Step", sig)
+
+		exitCode := 1
+		if s, ok := sig.(syscall.Signal); ok {
+			exitCode = 128 + int(s)
+		}
+		exitStep(exitCode)
+	}()
+}
+
+// exitOnTimeout stops the Step when it runs longer than the timeout, a zero timeout means no limit
+func exitOnTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+
+	time.AfterFunc(timeout, func() {
+		fmt.Println()
+		log.Errorf("The Step timed out after %s, stopping it", timeout)
+		exitStep(timeoutExitCode)
+	})
+}
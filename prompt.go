@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// interactivePrompt is a known prompt of fastlane or bundler, which can't be answered on CI
+type interactivePrompt struct {
+	pattern *regexp.Regexp
+	message string
+	// authentication is set for the prompts of a rejected or expired Apple ID session
+	authentication bool
+}
+
+// interactivePrompts match the whole prompt line, as fastlane, spaceship and bundler print it before waiting for input.
+// fastlane's informational output mentions the same things (for example "Two-factor Authentication (6 digits code) is enabled"),
+// so the patterns are anchored to the prompts themselves.
+var interactivePrompts = []interactivePrompt{
+	{
+		pattern:        regexp.MustCompile(`^Please enter the \d+ digit code( you received at .*)?:\s*$|^Please select a trusted phone number to send code to:\s*$`),
+		message:        "Apple ID requires 2FA; use an API key or app-specific password",
+		authentication: true,
+	},
+	{
+		pattern:        regexp.MustCompile(`^Password \(for [^)]*\):\s*$`),
+		message:        "fastlane asked for the Apple ID password; check the Apple ID password input, or use an API key",
+		authentication: true,
+	},
+	{
+		pattern: regexp.MustCompile(`^Multiple .*teams found.*, please enter the number of the team you want to use:\s*$`),
+		message: "fastlane asked to select a team; set the Team ID or the Team name input",
+	},
+	{
+		// bundler asks for the sudo password after explaining that the user isn't allowed to install to the system RubyGems
+		pattern: regexp.MustCompile(`^\s*Password:\s*$`),
+		message: "bundler asked for the sudo password; set the Bundle path input, or use a Ruby version manager",
+	},
+	{
+		// highline's agree, used by fastlane's UI.confirm, appends (y/n) to the question
+		pattern: regexp.MustCompile(`\(y/n\)\s*$`),
+		message: "fastlane asked for a confirmation; add the options skipping it to the Additional options input",
+	},
+}
+
+// promptQuietPeriod is how long the output has to be silent after an unterminated line for the line to be checked as a prompt
+var promptQuietPeriod = 2 * time.Second
+
+// ansiEscapePattern matches the color codes of the fastlane output
+var ansiEscapePattern = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// interactivePromptError is returned for a command stopped because it waited for user input
+type interactivePromptError struct {
+	Prompt         string
+	Message        string
+	Authentication bool
+}
+
+func (e interactivePromptError) Error() string {
+	return fmt.Sprintf("%s (prompt: %q)", e.Message, e.Prompt)
+}
+
+// matchInteractivePrompt returns the error of the known prompt in the output line, if any
+func matchInteractivePrompt(line string) (interactivePromptError, bool) {
+	line = ansiEscapePattern.ReplaceAllString(line, "")
+	for _, prompt := range interactivePrompts {
+		if prompt.pattern.MatchString(line) {
+			return interactivePromptError{Prompt: strings.TrimSpace(line), Message: prompt.message, Authentication: prompt.authentication}, true
+		}
+	}
+	return interactivePromptError{}, false
+}
+
+// promptDetector watches the output of a command for known interactive prompts.
+// A prompt waits for input at the end of an unterminated line, so only the last line of the output is checked,
+// once it is not followed by more output for the promptQuietPeriod.
+type promptDetector struct {
+	once     sync.Once
+	prompted chan error
+}
+
+func newPromptDetector() *promptDetector {
+	return &promptDetector{prompted: make(chan error, 1)}
+}
+
+// Prompted receives the error of the first detected prompt
+func (d *promptDetector) Prompted() <-chan error {
+	if d == nil {
+		return nil
+	}
+	return d.prompted
+}
+
+// Writer returns a writer passing the output to w
+func (d *promptDetector) Writer(w io.Writer) io.Writer {
+	return &promptWriter{detector: d, out: w}
+}
+
+type promptWriter struct {
+	detector *promptDetector
+	out      io.Writer
+
+	mu    sync.Mutex
+	line  []byte
+	timer *time.Timer
+	// writes counts the writes, a check scheduled before the last write is outdated
+	writes int
+}
+
+func (w *promptWriter) Write(p []byte) (int, error) {
+	n, err := w.out.Write(p)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writes++
+	for _, b := range p[:n] {
+		if b == '\n' || b == '\r' {
+			w.line = w.line[:0]
+			continue
+		}
+		// a prompt is a short line, don't keep long lines around
+		if len(w.line) < 1024 {
+			w.line = append(w.line, b)
+		}
+	}
+
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	if len(w.line) > 0 {
+		writes := w.writes
+		w.timer = time.AfterFunc(promptQuietPeriod, func() {
+			w.check(writes)
+		})
+	}
+
+	return n, err
+}
+
+// check matches the unterminated last line of the output, if nothing was written since the check was scheduled
+func (w *promptWriter) check(writes int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if writes != w.writes || len(w.line) == 0 {
+		return
+	}
+	if promptErr, ok := matchInteractivePrompt(string(w.line)); ok {
+		w.detector.once.Do(func() {
+			w.detector.prompted <- promptErr
+		})
+	}
+}
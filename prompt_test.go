@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func Test_matchInteractivePrompt(t *testing.T) {
+	tests := []struct {
+		line        string
+		wantMessage string
+	}{
+		{line: "Please enter the 6 digit code:", wantMessage: "Apple ID requires 2FA; use an API key or app-specific password"},
+		{line: "Please enter the 6 digit code you received at +36 (•••) ••• ••42: ", wantMessage: "Apple ID requires 2FA; use an API key or app-specific password"},
+		{line: "Please select a trusted phone number to send code to:", wantMessage: "Apple ID requires 2FA; use an API key or app-specific password"},
+		{line: "Password (for user@example.com): ", wantMessage: "fastlane asked for the Apple ID password; check the Apple ID password input, or use an API key"},
+		{line: "Multiple App Store Connect teams found, please enter the number of the team you want to use: ", wantMessage: "fastlane asked to select a team; set the Team ID or the Team name input"},
+		{line: "  Password: ", wantMessage: "bundler asked for the sudo password; set the Bundle path input, or use a Ruby version manager"},
+		{line: "[10:55:38]: \x1b[33mDo you want to continue? (y/n)\x1b[0m", wantMessage: "fastlane asked for a confirmation; add the options skipping it to the Additional options input"},
+		// informational output of fastlane and bundler
+		{line: "Two-factor Authentication (6 digits code) is enabled for account 'user@example.com'"},
+		{line: "Two-step Verification (4 digits code) is enabled for account 'user@example.com'"},
+		{line: "More information about Two-factor Authentication: https://support.apple.com/en-us/HT204915"},
+		{line: "(Input `sms` to escape this prompt and select a trusted phone number to send the code as a text message)"},
+		{line: "(You can also set the environment variable `SPACESHIP_2FA_SMS_DEFAULT_PHONE_NUMBER` to automate this)"},
+		{line: "[10:55:38]: Login to App Store Connect (user@example.com)"},
+		{line: "Your user account isn't allowed to install to the system RubyGems."},
+		{line: "[10:55:38]: Successfully uploaded the new binary to App Store Connect"},
+		{line: "[10:55:38]: Using the app-specific password from the environment"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, ok := matchInteractivePrompt(tt.line)
+			if ok != (tt.wantMessage != "") || got.Message != tt.wantMessage {
+				t.Errorf("matchInteractivePrompt() = %q, %v, want %q", got.Message, ok, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func Test_promptDetector(t *testing.T) {
+	quietPeriod := promptQuietPeriod
+	promptQuietPeriod = 20 * time.Millisecond
+	defer func() {
+		promptQuietPeriod = quietPeriod
+	}()
+
+	tests := []struct {
+		name    string
+		chunks  []string
+		wantErr string
+	}{
+		{
+			name:    "prompt written in chunks, without a new line",
+			chunks:  []string{"[10:55:38]: Starting login\n", "Please enter the 6 ", "digit code: "},
+			wantErr: `Apple ID requires 2FA; use an API key or app-specific password (prompt: "Please enter the 6 digit code:")`,
+		},
+		{
+			name:   "completed lines are not prompts",
+			chunks: []string{"Two-factor Authentication (6 digits code) is enabled for account 'user@example.com'\n", "Please enter the 6 digit code:\n"},
+		},
+		{
+			name:   "unterminated line of other output",
+			chunks: []string{"[10:55:38]: Starting login\n", "[10:55:38]: Uploading..."},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			detector := newPromptDetector()
+			w := detector.Writer(&out)
+
+			want := ""
+			for _, chunk := range tt.chunks {
+				if _, err := w.Write([]byte(chunk)); err != nil {
+					t.Fatal(err)
+				}
+				want += chunk
+			}
+			if got := out.String(); got != want {
+				t.Errorf("output = %q, want the output passed through", got)
+			}
+
+			select {
+			case err := <-detector.Prompted():
+				if tt.wantErr == "" || err.Error() != tt.wantErr {
+					t.Errorf("Prompted() = %s, want %q", err, tt.wantErr)
+				}
+			case <-time.After(10 * promptQuietPeriod):
+				if tt.wantErr != "" {
+					t.Errorf("Prompted() received nothing, want %s", tt.wantErr)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-steputils/stepconf"
+	"github.com/bitrise-io/go-steputils/tools"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	provenanceReportFileName  = "deliver_provenance.json"
+	provenanceReportPthEnvKey = "DELIVER_PROVENANCE_REPORT_PATH"
+)
+
+// artifactProvenance identifies the exact binary sent to Apple
+type artifactProvenance struct {
+	Source    string         `json:"source"`
+	SHA256    string         `json:"sha256"`
+	Size      int64          `json:"size"`
+	App       *artifactInfo  `json:"app,omitempty"`
+	Signature *signatureInfo `json:"signature,omitempty"`
+}
+
+// provenanceReport is written to the deploy directory after the Step run, for audit purposes
+type provenanceReport struct {
+	Timestamp string            `json:"timestamp"`
+	Success   bool              `json:"success"`
+	Inputs    map[string]string `json:"inputs"`
+	Tools     map[string]string `json:"tools"`
+	Apps      []deployResult    `json:"apps"`
+}
+
+// inspectArtifact calculates the checksum of the artifact and reads its app and code signature information.
+// Failing to read the app or signature information is not an error, as those are informational only.
+func inspectArtifact(pth, source string) (artifactProvenance, error) {
+	info, err := os.Stat(pth)
+	if err != nil {
+		return artifactProvenance{}, err
+	}
+
+	checksum, err := fileSHA256(pth)
+	if err != nil {
+		return artifactProvenance{}, fmt.Errorf("failed to calculate SHA-256 checksum of %s: %s", pth, err)
+	}
+
+	provenance := artifactProvenance{
+		Source: redactURL(source),
+		SHA256: checksum,
+		Size:   info.Size(),
+	}
+	log.Printf("Artifact SHA-256: %s", checksum)
+
+	if app, err := readArtifactInfo(pth); err != nil {
+		log.Warnf("Failed to read app information from the artifact: %s", err)
+	} else {
+		provenance.App = &app
+	}
+
+	if signature, err := readArtifactSignature(pth); err != nil {
+		log.Warnf("Failed to read code signature of the artifact: %s", err)
+	} else {
+		provenance.Signature = &signature
+		log.Printf("Signed by: %s (Team ID: %s, SHA-1: %s)", signature.CertificateName, signature.TeamID, signature.CertificateSHA1)
+	}
+
+	return provenance, nil
+}
+
+// provenanceInputs returns the non-sensitive Step inputs
+func provenanceInputs(cfg Config) map[string]string {
+	inputs := map[string]string{}
+
+	v := reflect.ValueOf(cfg)
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		key, _, _ := strings.Cut(field.Tag.Get("env"), ",")
+		// Secrets and Bitrise provided environment variables are not Step inputs
+		if key == "" || field.Type == reflect.TypeOf(stepconf.Secret("")) || strings.ToUpper(key) == key {
+			continue
+		}
+		// artifact URLs may be presigned, their tokens are not recorded
+		inputs[key] = redactURL(fmt.Sprint(v.Field(i).Interface()))
+	}
+
+	return inputs
+}
+
+var fastlaneVersionRegexp = regexp.MustCompile(`(?m)^fastlane (\d+\.\d+\.\d+\S*)`)
+
+// parseFastlaneVersion returns the version from the output of `fastlane -v`
+func parseFastlaneVersion(out string) string {
+	if match := fastlaneVersionRegexp.FindStringSubmatch(out); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+func writeProvenanceReport(deployDir string, report provenanceReport) error {
+	if deployDir == "" {
+		return fmt.Errorf("BITRISE_DEPLOY_DIR is not set")
+	}
+
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	pth := filepath.Join(deployDir, provenanceReportFileName)
+	if err := os.WriteFile(pth, content, 0644); err != nil {
+		return err
+	}
+	log.Printf("Provenance report written to: %s", pth)
+
+	return tools.ExportEnvironmentWithEnvman(provenanceReportPthEnvKey, pth)
+}
+
+func newProvenanceReport(cfg Config, toolVersions map[string]string, results []deployResult) provenanceReport {
+	return provenanceReport{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Success:   failedCount(results) == 0,
+		Inputs:    provenanceInputs(cfg),
+		Tools:     toolVersions,
+		Apps:      results,
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// Ruby version managers, and the ways Ruby can be installed without one
+const (
+	rubyManagerSystem   = "system"
+	rubyManagerHomebrew = "homebrew"
+	rubyManagerRbenv    = "rbenv"
+	rubyManagerAsdf     = "asdf"
+	rubyManagerRvm      = "rvm"
+	rubyManagerChruby   = "chruby"
+	rubyManagerUnknown  = "unknown"
+)
+
+// rubyToolchain describes the Ruby used to install and run fastlane
+type rubyToolchain struct {
+	Version string
+	Path    string
+	Manager string
+
+	// Requested version from a .ruby-version or .tool-versions file
+	RequestedVersion string
+	RequestedBy      string
+}
+
+func (ruby rubyToolchain) String() string {
+	return fmt.Sprintf("%s (%s, %s)", ruby.Version, ruby.Manager, ruby.Path)
+}
+
+// ensureRuby detects the active Ruby, switches to (and if needed installs) the version requested by
+// a .ruby-version or .tool-versions file next to the Gemfile, and checks that the Ruby is recent enough
+// for the requested fastlane version.
+// The selected Ruby is activated in the Step's environment, so every subsequent gem, bundle and fastlane call uses it.
+func ensureRuby(runner commandRunner, gemfilePth, fastlaneVersion string) (rubyToolchain, error) {
+	searchDirs := []string{"."}
+	if gemfilePth != "" {
+		searchDirs = append([]string{filepath.Dir(gemfilePth)}, searchDirs...)
+	}
+	requestedVersion, requestedBy := requestedRubyVersion(searchDirs...)
+
+	ruby, err := activeRuby(runner)
+	if err != nil {
+		return rubyToolchain{}, err
+	}
+	ruby.RequestedVersion, ruby.RequestedBy = requestedVersion, requestedBy
+
+	if requestedVersion != "" && !rubyVersionMatches(ruby.Version, requestedVersion) {
+		log.Printf("Ruby %s requested by %s, active Ruby is %s", requestedVersion, requestedBy, ruby.Version)
+
+		manager := availableRubyManager(runner, ruby.Manager)
+		if manager == "" {
+			log.Warnf("No Ruby version manager (rbenv, asdf, rvm, chruby) found, using the active Ruby %s", ruby.Version)
+		} else {
+			if err := activateRuby(runner, manager, requestedVersion); err != nil {
+				return rubyToolchain{}, fmt.Errorf("failed to activate Ruby %s with %s: %s", requestedVersion, manager, err)
+			}
+
+			if ruby, err = activeRuby(runner); err != nil {
+				return rubyToolchain{}, err
+			}
+			ruby.RequestedVersion, ruby.RequestedBy = requestedVersion, requestedBy
+		}
+	}
+
+	log.Printf("Ruby: %s", ruby)
+
+	if fastlaneVersion != "" {
+		if err := checkRubyForFastlane(runner, ruby, fastlaneVersion); err != nil {
+			return rubyToolchain{}, err
+		}
+	}
+
+	return ruby, nil
+}
+
+// requestedRubyVersion returns the Ruby version from the first .ruby-version or .tool-versions file found in dirs
+func requestedRubyVersion(dirs ...string) (string, string) {
+	for _, dir := range dirs {
+		pth := filepath.Join(dir, ".ruby-version")
+		if content, err := os.ReadFile(pth); err == nil {
+			if version := strings.TrimPrefix(strings.TrimSpace(string(content)), "ruby-"); version != "" {
+				return version, pth
+			}
+		}
+
+		pth = filepath.Join(dir, ".tool-versions")
+		if content, err := os.ReadFile(pth); err == nil {
+			scanner := bufio.NewScanner(strings.NewReader(string(content)))
+			for scanner.Scan() {
+				fields := strings.Fields(scanner.Text())
+				if len(fields) >= 2 && fields[0] == "ruby" {
+					return fields[1], pth
+				}
+			}
+		}
+	}
+
+	return "", ""
+}
+
+func activeRuby(runner commandRunner) (rubyToolchain, error) {
+	if _, err := runner.LookPath("ruby"); err != nil {
+		return rubyToolchain{}, fmt.Errorf("Ruby is not installed: %s", err)
+	}
+
+	// RbConfig.ruby is the Ruby executable behind any shims, for example ~/.rbenv/versions/3.2.2/bin/ruby
+	out, err := runner.CombinedOutput(newCommandSpec("ruby", "-e", `print RUBY_VERSION, "\n", RbConfig.ruby`))
+	if err != nil {
+		return rubyToolchain{}, fmt.Errorf("failed to get Ruby version: %s, output: %s", err, out)
+	}
+
+	version, pth, _ := strings.Cut(out, "\n")
+	return rubyToolchain{
+		Version: version,
+		Path:    pth,
+		Manager: rubyManagerFromPath(pth),
+	}, nil
+}
+
+func rubyManagerFromPath(pth string) string {
+	switch {
+	case strings.Contains(pth, "/.rbenv/"):
+		return rubyManagerRbenv
+	case strings.Contains(pth, "/.asdf/"):
+		return rubyManagerAsdf
+	case strings.Contains(pth, "/.rvm/"):
+		return rubyManagerRvm
+	case strings.Contains(pth, "/.rubies/") || strings.HasPrefix(pth, "/opt/rubies/"):
+		return rubyManagerChruby
+	case strings.HasPrefix(pth, "/opt/homebrew/") || strings.HasPrefix(pth, "/usr/local/opt/") || strings.HasPrefix(pth, "/usr/local/Cellar/"):
+		return rubyManagerHomebrew
+	case strings.HasPrefix(pth, "/usr/bin/") || strings.HasPrefix(pth, "/System/"):
+		return rubyManagerSystem
+	default:
+		return rubyManagerUnknown
+	}
+}
+
+// availableRubyManager returns the version manager to use: the one providing the active Ruby if any,
+// otherwise the first one installed
+func availableRubyManager(runner commandRunner, activeManager string) string {
+	candidates := []string{rubyManagerRbenv, rubyManagerAsdf, rubyManagerRvm, rubyManagerChruby}
+	for _, manager := range candidates {
+		if manager == activeManager && isRubyManagerInstalled(runner, manager) {
+			return manager
+		}
+	}
+	for _, manager := range candidates {
+		if isRubyManagerInstalled(runner, manager) {
+			return manager
+		}
+	}
+	return ""
+}
+
+func isRubyManagerInstalled(runner commandRunner, manager string) bool {
+	if manager == rubyManagerChruby {
+		// chruby is a shell function, look for its rubies directories instead
+		return len(chrubyRubiesDirs()) > 0
+	}
+
+	_, err := runner.LookPath(manager)
+	return err == nil
+}
+
+func chrubyRubiesDirs() []string {
+	var dirs []string
+	for _, dir := range []string{filepath.Join(pathutil.UserHomeDir(), ".rubies"), "/opt/rubies"} {
+		if exist, err := pathutil.IsDirExists(dir); err == nil && exist {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// activateRuby installs the Ruby version with the version manager if needed, and activates it in the Step's environment
+func activateRuby(runner commandRunner, manager, version string) error {
+	fmt.Println()
+	log.Infof("Activating Ruby %s with %s", version, manager)
+
+	switch manager {
+	case rubyManagerRbenv:
+		if err := runRubyManagerCommand(runner, "rbenv", "install", "--skip-existing", version); err != nil {
+			return err
+		}
+		return os.Setenv("RBENV_VERSION", version)
+	case rubyManagerAsdf:
+		if err := runRubyManagerCommand(runner, "asdf", "install", "ruby", version); err != nil {
+			return err
+		}
+		return os.Setenv("ASDF_RUBY_VERSION", version)
+	case rubyManagerRvm:
+		if err := runRubyManagerCommand(runner, "rvm", "install", version); err != nil {
+			return err
+		}
+
+		// rvm activates a Ruby by modifying a set of environment variables, take those from `rvm <version> do env`
+		out, err := runner.Output(newCommandSpec("rvm", version, "do", "env"))
+		if err != nil {
+			return fmt.Errorf("failed to get the environment of Ruby %s: %s", version, err)
+		}
+		for _, line := range strings.Split(out, "\n") {
+			key, value, ok := strings.Cut(line, "=")
+			if !ok {
+				continue
+			}
+			switch key {
+			case "PATH", "GEM_HOME", "GEM_PATH", "MY_RUBY_HOME", "RUBY_VERSION", "IRBRC":
+				if err := os.Setenv(key, value); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	case rubyManagerChruby:
+		rubyDir := ""
+		for _, dir := range chrubyRubiesDirs() {
+			if exist, err := pathutil.IsDirExists(filepath.Join(dir, "ruby-"+version)); err == nil && exist {
+				rubyDir = filepath.Join(dir, "ruby-"+version)
+				break
+			}
+		}
+		if rubyDir == "" {
+			if _, err := runner.LookPath("ruby-install"); err != nil {
+				return fmt.Errorf("Ruby %s is not installed and ruby-install is not available", version)
+			}
+			if err := runRubyManagerCommand(runner, "ruby-install", "--no-reinstall", "ruby", version); err != nil {
+				return err
+			}
+			rubyDir = filepath.Join(pathutil.UserHomeDir(), ".rubies", "ruby-"+version)
+		}
+
+		// Same environment as `chruby <version>` sets
+		gemHome := filepath.Join(pathutil.UserHomeDir(), ".gem", "ruby", version)
+		for key, value := range map[string]string{
+			"RUBY_ROOT": rubyDir,
+			"GEM_HOME":  gemHome,
+			"GEM_PATH":  gemHome,
+			"PATH":      strings.Join([]string{filepath.Join(gemHome, "bin"), filepath.Join(rubyDir, "bin"), os.Getenv("PATH")}, string(os.PathListSeparator)),
+		} {
+			if err := os.Setenv(key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported Ruby version manager: %s", manager)
+	}
+}
+
+func runRubyManagerCommand(runner commandRunner, name string, args ...string) error {
+	cmd := newCommandSpec(name, args...)
+	fmt.Println()
+	log.Donef("$ %s", cmd)
+	return runner.Run(cmd)
+}
+
+// rubyVersionMatches reports whether the active Ruby version satisfies the requested one,
+// a requested version can be a prefix, for example 3.2 is satisfied by 3.2.2
+func rubyVersionMatches(active, requested string) bool {
+	requested = strings.TrimPrefix(requested, "ruby-")
+	return active == requested || strings.HasPrefix(active, requested+".")
+}
+
+var requiredRubyVersionRegexp = regexp.MustCompile(`-\s+-\s+"?(>=|>|~>)"?\s*\n\s+-\s+!ruby/object:Gem::Version\s*\n\s+version:\s+'?([0-9][0-9.]*)'?`)
+
+// checkRubyForFastlane fails if the Ruby is older than what the fastlane version requires.
+// The requirement is taken from the gem's specification on RubyGems, the check is skipped if it can't be fetched.
+func checkRubyForFastlane(runner commandRunner, ruby rubyToolchain, fastlaneVersion string) error {
+	args := []string{"specification", "fastlane", "required_ruby_version", "--remote"}
+	switch fastlaneVersion {
+	case latestStable:
+	case latestPrerelease:
+		args = append(args, "--prerelease")
+	default:
+		args = append(args, "--version", fastlaneVersion)
+	}
+
+	out, err := runner.Output(newCommandSpec("gem", args...))
+	if err != nil {
+		log.Debugf("Failed to fetch the Ruby requirement of fastlane %s: %s", fastlaneVersion, err)
+		return nil
+	}
+
+	match := requiredRubyVersionRegexp.FindStringSubmatch(out)
+	if match == nil {
+		return nil
+	}
+
+	minimumVersion := match[2]
+	if compareGemVersions(ruby.Version, minimumVersion) >= 0 {
+		return nil
+	}
+
+	message := fmt.Sprintf("fastlane %s requires Ruby %s %s, but the active Ruby is %s", fastlaneVersion, match[1], minimumVersion, ruby)
+	if ruby.Manager == rubyManagerSystem {
+		return fmt.Errorf("%s. The system Ruby is too old: install a newer Ruby with a version manager (rbenv, asdf, rvm, chruby) and add a .ruby-version file next to the Gemfile, or set an older fastlane version", message)
+	}
+	return fmt.Errorf("%s. Select a newer Ruby in .ruby-version or .tool-versions, or set an older fastlane version", message)
+}
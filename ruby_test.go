@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_requestedRubyVersion(t *testing.T) {
+	rubyVersionDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(rubyVersionDir, ".ruby-version"), []byte("ruby-3.2.2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	toolVersionsDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(toolVersionsDir, ".tool-versions"), []byte("nodejs 20.10.0\nruby 3.3.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	emptyDir := t.TempDir()
+
+	tests := []struct {
+		name        string
+		dirs        []string
+		wantVersion string
+		wantFile    string
+	}{
+		{name: ".ruby-version", dirs: []string{rubyVersionDir}, wantVersion: "3.2.2", wantFile: filepath.Join(rubyVersionDir, ".ruby-version")},
+		{name: ".tool-versions", dirs: []string{toolVersionsDir}, wantVersion: "3.3.0", wantFile: filepath.Join(toolVersionsDir, ".tool-versions")},
+		{name: "first dir wins", dirs: []string{emptyDir, toolVersionsDir, rubyVersionDir}, wantVersion: "3.3.0", wantFile: filepath.Join(toolVersionsDir, ".tool-versions")},
+		{name: "no version file", dirs: []string{emptyDir}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, pth := requestedRubyVersion(tt.dirs...)
+			if version != tt.wantVersion || pth != tt.wantFile {
+				t.Errorf("requestedRubyVersion() = %s, %s, want %s, %s", version, pth, tt.wantVersion, tt.wantFile)
+			}
+		})
+	}
+}
+
+func Test_rubyManagerFromPath(t *testing.T) {
+	tests := []struct {
+		pth  string
+		want string
+	}{
+		{pth: "/Users/vagrant/.rbenv/shims/ruby", want: rubyManagerRbenv},
+		{pth: "/Users/vagrant/.asdf/shims/ruby", want: rubyManagerAsdf},
+		{pth: "/Users/vagrant/.rvm/rubies/ruby-3.2.2/bin/ruby", want: rubyManagerRvm},
+		{pth: "/Users/vagrant/.rubies/ruby-3.2.2/bin/ruby", want: rubyManagerChruby},
+		{pth: "/opt/homebrew/opt/ruby/bin/ruby", want: rubyManagerHomebrew},
+		{pth: "/usr/bin/ruby", want: rubyManagerSystem},
+		{pth: "/some/other/ruby", want: rubyManagerUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pth, func(t *testing.T) {
+			if got := rubyManagerFromPath(tt.pth); got != tt.want {
+				t.Errorf("rubyManagerFromPath() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_rubyVersionMatches(t *testing.T) {
+	tests := []struct {
+		active    string
+		requested string
+		want      bool
+	}{
+		{active: "3.2.2", requested: "3.2.2", want: true},
+		{active: "3.2.2", requested: "3.2", want: true},
+		{active: "3.2.2", requested: "ruby-3.2.2", want: true},
+		{active: "3.2.2", requested: "3.2.1", want: false},
+		{active: "3.20.0", requested: "3.2", want: false},
+	}
+	for _, tt := range tests {
+		if got := rubyVersionMatches(tt.active, tt.requested); got != tt.want {
+			t.Errorf("rubyVersionMatches(%s, %s) = %v, want %v", tt.active, tt.requested, got, tt.want)
+		}
+	}
+}
+
+func Test_requiredRubyVersionRegexp(t *testing.T) {
+	out := `--- !ruby/object:Gem::Requirement
+requirements:
+- - ">="
+  - !ruby/object:Gem::Version
+    version: '2.6'
+`
+	match := requiredRubyVersionRegexp.FindStringSubmatch(out)
+	if match == nil {
+		t.Fatalf("requiredRubyVersionRegexp did not match")
+	}
+	if match[1] != ">=" || match[2] != "2.6" {
+		t.Errorf("requiredRubyVersionRegexp matched %s %s, want >= 2.6", match[1], match[2])
+	}
+}
+
+func Test_activeRuby(t *testing.T) {
+	runner := newFakeRunner().
+		withExecutable("ruby", "/Users/vagrant/.rbenv/shims/ruby").
+		on("ruby -e", "3.2.2\n/Users/vagrant/.rbenv/versions/3.2.2/bin/ruby", nil)
+
+	got, err := activeRuby(runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := rubyToolchain{Version: "3.2.2", Path: "/Users/vagrant/.rbenv/versions/3.2.2/bin/ruby", Manager: rubyManagerRbenv}
+	if got != want {
+		t.Errorf("activeRuby() = %+v, want %+v", got, want)
+	}
+
+	if _, err := activeRuby(newFakeRunner()); err == nil {
+		t.Errorf("activeRuby() error = nil without Ruby installed")
+	}
+}
+
+func Test_checkRubyForFastlane(t *testing.T) {
+	requirement := `--- !ruby/object:Gem::Requirement
+requirements:
+- - ">="
+  - !ruby/object:Gem::Version
+    version: '2.6'
+`
+	runner := newFakeRunner().on("gem specification fastlane required_ruby_version --remote --version 2.219.0", requirement, nil)
+
+	if err := checkRubyForFastlane(runner, rubyToolchain{Version: "3.2.2", Manager: rubyManagerRbenv}, "2.219.0"); err != nil {
+		t.Errorf("checkRubyForFastlane() error = %v for a recent Ruby", err)
+	}
+	if err := checkRubyForFastlane(runner, rubyToolchain{Version: "2.5.9", Manager: rubyManagerSystem}, "2.219.0"); err == nil {
+		t.Errorf("checkRubyForFastlane() error = nil for a too old Ruby")
+	}
+	if err := checkRubyForFastlane(newFakeRunner(), rubyToolchain{Version: "2.5.9", Manager: rubyManagerSystem}, "2.219.0"); err != nil {
+		t.Errorf("checkRubyForFastlane() error = %v, want the check skipped without the requirement", err)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+)
+
+// commandSpec describes an external command to run
+type commandSpec struct {
+	Name string
+	Args []string
+	// Envs are set in addition to the Step's environment
+	Envs  []string
+	Dir   string
+	Stdin io.Reader
+	// Stdout and Stderr are used by commandRunner.Run, the Step's stdout and stderr by default
+	Stdout io.Writer
+	Stderr io.Writer
+	// DetectPrompts makes commandRunner.Run stop the command when it prints a known interactive prompt
+	DetectPrompts bool
+}
+
+func newCommandSpec(name string, args ...string) commandSpec {
+	return commandSpec{Name: name, Args: args}
+}
+
+// withEnvs returns a copy of the command with additional environment variables
+func (c commandSpec) withEnvs(envs ...string) commandSpec {
+	c.Envs = append(append([]string{}, c.Envs...), envs...)
+	return c
+}
+
+func (c commandSpec) withDir(dir string) commandSpec {
+	c.Dir = dir
+	return c
+}
+
+func (c commandSpec) withStdin(stdin io.Reader) commandSpec {
+	c.Stdin = stdin
+	return c
+}
+
+// nonInteractive returns a copy of the command which is stopped with an interactivePromptError, if it waits for user input
+func (c commandSpec) nonInteractive() commandSpec {
+	c.DetectPrompts = true
+	return c
+}
+
+func (c commandSpec) withOutput(stdout, stderr io.Writer) commandSpec {
+	c.Stdout, c.Stderr = stdout, stderr
+	return c
+}
+
+// slice returns the command name followed by its arguments
+func (c commandSpec) slice() []string {
+	return append([]string{c.Name}, c.Args...)
+}
+
+func (c commandSpec) String() string {
+	return command.PrintableCommandArgs(false, c.slice())
+}
+
+// commandRunner runs the external commands (ruby, gem, bundle, fastlane) of the Step,
+// so that the setup and deploy logic can be tested without them
+type commandRunner interface {
+	// Run runs the command with its output streamed to the Step's output
+	Run(cmd commandSpec) error
+	// Output runs the command and returns its trimmed standard output
+	Output(cmd commandSpec) (string, error)
+	// CombinedOutput runs the command and returns its trimmed standard output and error
+	CombinedOutput(cmd commandSpec) (string, error)
+	// LookPath searches for an executable in the PATH
+	LookPath(file string) (string, error)
+	// withDeadline returns a runner which stops its commands when the phase's deadline is reached
+	withDeadline(deadline *phaseDeadline) commandRunner
+}
+
+// defaultRunner runs each command in its own process group, see processGroups
+type defaultRunner struct {
+	deadline *phaseDeadline
+}
+
+func newDefaultRunner() commandRunner {
+	return defaultRunner{}
+}
+
+func (defaultRunner) model(cmd commandSpec) *command.Model {
+	model := command.New(cmd.Name, cmd.Args...).AppendEnvs(cmd.Envs...)
+	if cmd.Dir != "" {
+		model.SetDir(cmd.Dir)
+	}
+	if cmd.Stdin != nil {
+		model.SetStdin(cmd.Stdin)
+	}
+	return model
+}
+
+func (r defaultRunner) Run(cmd commandSpec) error {
+	var stdout, stderr io.Writer = os.Stdout, os.Stderr
+	if cmd.Stdout != nil {
+		stdout = cmd.Stdout
+	}
+	if cmd.Stderr != nil {
+		stderr = cmd.Stderr
+	}
+
+	var detector *promptDetector
+	if cmd.DetectPrompts {
+		detector = newPromptDetector()
+		stdout, stderr = detector.Writer(stdout), detector.Writer(stderr)
+	}
+	return childProcesses.run(r.model(cmd).SetStdout(stdout).SetStderr(stderr).GetCmd(), r.deadline, detector.Prompted())
+}
+
+func (r defaultRunner) Output(cmd commandSpec) (string, error) {
+	var stdout, stderr bytes.Buffer
+	err := childProcesses.run(r.model(cmd).SetStdout(&stdout).SetStderr(&stderr).GetCmd(), r.deadline, nil)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitErr.Stderr = stderr.Bytes()
+	}
+	return strings.TrimSpace(stdout.String()), err
+}
+
+func (r defaultRunner) CombinedOutput(cmd commandSpec) (string, error) {
+	var output bytes.Buffer
+	err := childProcesses.run(r.model(cmd).SetStdout(&output).SetStderr(&output).GetCmd(), r.deadline, nil)
+	return strings.TrimSpace(output.String()), err
+}
+
+func (defaultRunner) LookPath(file string) (string, error) {
+	return exec.LookPath(file)
+}
+
+func (r defaultRunner) withDeadline(deadline *phaseDeadline) commandRunner {
+	r.deadline = deadline
+	return r
+}
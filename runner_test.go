@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// fakeRunner records the commands it is asked to run, and answers them with the configured results
+type fakeRunner struct {
+	commands []commandSpec
+	results  map[string]fakeResult
+	paths    map[string]string
+}
+
+type fakeResult struct {
+	output string
+	err    error
+}
+
+func newFakeRunner() *fakeRunner {
+	return &fakeRunner{
+		results: map[string]fakeResult{},
+		paths:   map[string]string{},
+	}
+}
+
+// on sets the result of the commands starting with the command line, the longest matching command line wins
+func (r *fakeRunner) on(commandLine, output string, err error) *fakeRunner {
+	r.results[commandLine] = fakeResult{output: output, err: err}
+	return r
+}
+
+// withExecutable makes LookPath find the executable
+func (r *fakeRunner) withExecutable(name, pth string) *fakeRunner {
+	r.paths[name] = pth
+	return r
+}
+
+// commandLines returns the recorded commands, with their arguments joined by spaces
+func (r *fakeRunner) commandLines() []string {
+	var lines []string
+	for _, cmd := range r.commands {
+		lines = append(lines, strings.Join(cmd.slice(), " "))
+	}
+	return lines
+}
+
+func (r *fakeRunner) result(cmd commandSpec) fakeResult {
+	r.commands = append(r.commands, cmd)
+
+	commandLine := strings.Join(cmd.slice(), " ")
+	match := ""
+	for prefix := range r.results {
+		if strings.HasPrefix(commandLine, prefix) && len(prefix) > len(match) {
+			match = prefix
+		}
+	}
+	if match == "" {
+		return fakeResult{}
+	}
+	return r.results[match]
+}
+
+func (r *fakeRunner) Run(cmd commandSpec) error {
+	result := r.result(cmd)
+	if cmd.Stdout != nil && result.output != "" {
+		_, _ = fmt.Fprintln(cmd.Stdout, result.output)
+	}
+	return result.err
+}
+
+func (r *fakeRunner) Output(cmd commandSpec) (string, error) {
+	result := r.result(cmd)
+	return result.output, result.err
+}
+
+func (r *fakeRunner) CombinedOutput(cmd commandSpec) (string, error) {
+	result := r.result(cmd)
+	return result.output, result.err
+}
+
+func (r *fakeRunner) withDeadline(*phaseDeadline) commandRunner {
+	return r
+}
+
+func (r *fakeRunner) LookPath(file string) (string, error) {
+	if pth, ok := r.paths[file]; ok {
+		return pth, nil
+	}
+	return "", fmt.Errorf("exec: %q: %w", file, exec.ErrNotFound)
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/command/gems"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-io/go-utils/retry"
+)
+
+const latestStable = "latest-stable"
+const latestPrerelease = "latest"
+
+// fastlaneInstallation is the fastlane selected (and installed if needed) during setup, and how to invoke it
+type fastlaneInstallation struct {
+	CmdSlice []string
+	WorkDir  string
+	Envs     []string
+}
+
+// fastlaneInstaller selects the fastlane to use, and installs it with its dependencies if needed.
+// Every external command is run by the runner.
+type fastlaneInstaller struct {
+	runner commandRunner
+	ruby   rubyToolchain
+	bundle bundleConfig
+	cache  *fastlaneCache
+}
+
+// gemCommand returns a gem or bundle command modifying the installed gems, these need sudo for the system Ruby.
+// sudo resets the environment, so the environment variables are passed as its arguments too.
+func (i fastlaneInstaller) gemCommand(envs []string, name string, args ...string) commandSpec {
+	if i.ruby.Manager != rubyManagerSystem {
+		return newCommandSpec(name, args...).withEnvs(envs...)
+	}
+
+	sudoArgs := append(append(append([]string{}, envs...), name), args...)
+	return newCommandSpec("sudo", sudoArgs...).withEnvs(envs...)
+}
+
+func (i fastlaneInstaller) gemInstallWithRetry(gemName string, version string) error {
+	return retry.Times(2).Try(func(attempt uint) error {
+		if attempt > 0 {
+			log.Warnf("%d attempt failed", attempt+1)
+		}
+
+		args := []string{"install", gemName, "--no-document"}
+		if version == latestPrerelease {
+			args = append(args, "--prerelease")
+		}
+		if version != latestStable && version != latestPrerelease {
+			args = append(args, "-v", version)
+		}
+
+		cmds := []commandSpec{i.gemCommand(nil, "gem", args...)}
+		// Version managers using shims have to pick up the new executables
+		switch i.ruby.Manager {
+		case rubyManagerRbenv:
+			cmds = append(cmds, newCommandSpec("rbenv", "rehash"))
+		case rubyManagerAsdf:
+			cmds = append(cmds, newCommandSpec("asdf", "reshim", "ruby"))
+		}
+
+		for _, cmd := range cmds {
+			fmt.Println()
+			log.Donef("$ %s", cmd)
+			if out, err := i.runner.CombinedOutput(cmd); err != nil {
+				return fmt.Errorf("gem install command failed, output: %s, error: %s", out, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// gemInstallToCacheWithRetry installs an exact gem version into a cache entry,
+// the gem home is owned by the current user, so sudo is never needed
+func (i fastlaneInstaller) gemInstallToCacheWithRetry(gemName, version string, entry cacheEntry) error {
+	return retry.Times(2).Try(func(attempt uint) error {
+		if attempt > 0 {
+			log.Warnf("%d attempt failed", attempt)
+		}
+
+		cmd := newCommandSpec("gem", "install", gemName, "--version", version, "--no-document").withEnvs(entry.gemHomeEnvs()...)
+
+		fmt.Println()
+		log.Donef("$ %s", cmd)
+		if out, err := i.runner.CombinedOutput(cmd); err != nil {
+			return fmt.Errorf("gem install command failed, output: %s, error: %s", out, err)
+		}
+
+		return nil
+	})
+}
+
+func gemVersionFromGemfileLock(gem, gemfileLockPth string) (gems.Version, error) {
+	content, err := fileutil.ReadStringFromFile(gemfileLockPth)
+	if err != nil {
+		return gems.Version{}, err
+	}
+	return gems.ParseVersionFromBundle(gem, content)
+}
+
+func (i fastlaneInstaller) ensureFastlaneVersionAndCreateCmdSlice(forceVersion, gemfilePth string) (fastlaneInstallation, error) {
+	if forceVersion != "" {
+		if isGemRequirement(forceVersion) {
+			version, installed, err := resolveGemRequirement(i.runner, "fastlane", forceVersion)
+			if err != nil {
+				return fastlaneInstallation{}, fmt.Errorf("failed to resolve fastlane version (%s): %s", forceVersion, err)
+			}
+			if installed {
+				return fastlaneInstallation{CmdSlice: []string{"fastlane", fmt.Sprintf("_%s_", version)}}, nil
+			}
+			forceVersion = version
+		}
+
+		fastlaneCmdSlice := []string{"fastlane"}
+		if forceVersion != latestStable && forceVersion != latestPrerelease {
+			fastlaneCmdSlice = append(fastlaneCmdSlice, fmt.Sprintf("_%s_", forceVersion))
+		}
+
+		if i.cache != nil {
+			if forceVersion == latestStable || forceVersion == latestPrerelease {
+				log.Printf("fastlane version (%s) is not pinned, not using the fastlane cache", forceVersion)
+			} else {
+				return i.ensureCachedFastlaneGem(forceVersion, fastlaneCmdSlice)
+			}
+		}
+
+		log.Printf("fastlane version defined: %s, installing...", forceVersion)
+
+		if err := i.gemInstallWithRetry("fastlane", forceVersion); err != nil {
+			return fastlaneInstallation{}, err
+		}
+
+		return fastlaneInstallation{CmdSlice: fastlaneCmdSlice}, nil
+	}
+
+	gemfilePth = resolveGemfilePath(gemfilePth)
+	if gemfilePth == "" {
+		log.Printf("no fastlane version nor Gemfile path defined, using system installed fastlane...")
+		return fastlaneInstallation{CmdSlice: []string{"fastlane"}}, nil
+	}
+
+	if exist, err := pathutil.IsPathExists(gemfilePth); err != nil {
+		return fastlaneInstallation{}, err
+	} else if !exist {
+		log.Printf("Gemfile not exist at: %s and no fastlane version defined, using system installed fastlane...", gemfilePth)
+		return fastlaneInstallation{CmdSlice: []string{"fastlane"}}, nil
+	}
+
+	log.Printf("Gemfile exist, checking Fastlane version from gem lockfile")
+
+	bundleInstallCalled := false
+	gemfileDir := filepath.Dir(gemfilePth)
+	gemfileLockPth := gemfileLockPath(gemfilePth)
+	envs := i.bundle.envs(gemfilePth)
+
+	if exist, err := pathutil.IsPathExists(gemfileLockPth); err != nil {
+		return fastlaneInstallation{}, err
+	} else if !exist {
+		if i.bundle.requiresLockfile() {
+			return fastlaneInstallation{}, fmt.Errorf("gem lockfile not exist at: %s, it is required in %s mode", gemfileLockPth, i.bundle.Mode)
+		}
+
+		log.Printf("gem lockfile not exist at: %s, running 'bundle install' ...", gemfileLockPth)
+
+		cmd := newCommandSpec("bundle", "install").withDir(gemfileDir).withEnvs(envs...).nonInteractive()
+		if err := i.runner.Run(cmd); err != nil {
+			return fastlaneInstallation{}, err
+		}
+
+		bundleInstallCalled = true
+
+		if exist, err := pathutil.IsPathExists(gemfileLockPth); err != nil {
+			return fastlaneInstallation{}, err
+		} else if !exist {
+			return fastlaneInstallation{}, errors.New("gem lockfile still not exist, even after 'bundle install' was called")
+		}
+	}
+
+	fastlane, err := gemVersionFromGemfileLock("fastlane", gemfileLockPth)
+	if err != nil {
+		return fastlaneInstallation{}, err
+	}
+
+	if fastlane.Found {
+		log.Printf("fastlane version defined in gem lockfile: %s, using bundler to call fastlane commands...", fastlane.Version)
+
+		var bundlerVersion gems.Version
+		if !bundleInstallCalled {
+			content, err := fileutil.ReadStringFromFile(gemfileLockPth)
+			if err != nil {
+				return fastlaneInstallation{}, fmt.Errorf("failed to read file (%s) contents, error: %s", gemfileLockPth, err)
+			}
+
+			bundlerVersion, err = gems.ParseBundlerVersion(content)
+			if err != nil {
+				return fastlaneInstallation{}, fmt.Errorf("failed to parse bundler version, error: %s", err)
+			}
+
+			fmt.Println()
+			log.Infof("Installing bundler")
+
+			// install bundler with `gem install bundler [-v version]`
+			// in some configurations, the command "bunder _1.2.3_" can return 'Command not found', installing bundler solves this
+			installBundlerArgs := []string{"install", "bundler", "--force", "--no-document"}
+			if bundlerVersion.Found {
+				installBundlerArgs = append(installBundlerArgs, "--version", bundlerVersion.Version)
+			}
+			installBundlerCommand := i.gemCommand(nil, "gem", installBundlerArgs...).withDir(gemfileDir)
+
+			fmt.Println()
+			log.Donef("$ %s", installBundlerCommand)
+
+			if err := i.runner.Run(installBundlerCommand); err != nil {
+				return fastlaneInstallation{}, fmt.Errorf("command failed, error: %s", err)
+			}
+
+			var entry *cacheEntry
+			if i.cache != nil {
+				if i.bundle.Path != "" {
+					log.Printf("Bundle path is set, not using the fastlane cache for the bundle")
+				} else if entry, err = i.bundleCacheEntry(gemfileDir, content); err != nil {
+					log.Warnf("Not using the fastlane cache: %s", err)
+				} else {
+					envs = append(envs, entry.bundlePathEnvs()...)
+				}
+			}
+
+			if entry != nil && entry.restore() && i.bundleCheck(bundlerVersion, gemfileDir, envs) {
+				log.Printf("Bundle restored from cache, skipping 'bundle install'")
+			} else {
+				// install gem lockfile gems with `bundle [_version_] install ...`
+				fmt.Println()
+				log.Infof("Installing bundle")
+
+				bundleInstallArgs := append(bundleVersionArgs(bundlerVersion), "install", "--jobs", "20", "--retry", "5")
+				cmd := newCommandSpec("bundle", bundleInstallArgs...).withEnvs(envs...)
+				if !hasEnv(envs, "BUNDLE_PATH") {
+					// The bundle is installed into the Ruby's gems
+					cmd = i.gemCommand(envs, "bundle", bundleInstallArgs...)
+				}
+				cmd = cmd.withDir(gemfileDir)
+
+				fmt.Println()
+				log.Donef("$ %s", cmd)
+
+				if err := i.runner.Run(cmd); err != nil {
+					return fastlaneInstallation{}, fmt.Errorf("command failed, error: %s", err)
+				}
+
+				if entry != nil {
+					if err := entry.save(); err != nil {
+						log.Warnf("Failed to save bundle to the fastlane cache: %s", err)
+					}
+				}
+			}
+		}
+
+		return fastlaneInstallation{
+			CmdSlice: append(gems.BundleExecPrefix(bundlerVersion), "fastlane"),
+			WorkDir:  gemfileDir,
+			Envs:     envs,
+		}, nil
+	}
+
+	log.Printf("Fastlane version not found in gem lockfile, using system installed Fastlane...")
+
+	return fastlaneInstallation{CmdSlice: []string{"fastlane"}}, nil
+}
+
+// ensureCachedFastlaneGem installs an exact fastlane version into a cached gem home, or restores it from the cache
+func (i fastlaneInstaller) ensureCachedFastlaneGem(version string, fastlaneCmdSlice []string) (fastlaneInstallation, error) {
+	ruby, err := rubyVersion(i.runner, "")
+	if err != nil {
+		return fastlaneInstallation{}, err
+	}
+
+	entry, err := i.cache.entry("gem", "ruby="+ruby, "fastlane="+version)
+	if err != nil {
+		return fastlaneInstallation{}, err
+	}
+
+	if !entry.restore() {
+		log.Printf("fastlane version defined: %s, installing...", version)
+
+		if err := i.gemInstallToCacheWithRetry("fastlane", version, entry); err != nil {
+			return fastlaneInstallation{}, err
+		}
+
+		if err := entry.save(); err != nil {
+			log.Warnf("Failed to save fastlane to the cache: %s", err)
+		}
+	}
+
+	return fastlaneInstallation{CmdSlice: fastlaneCmdSlice, Envs: entry.gemHomeEnvs()}, nil
+}
+
+func (i fastlaneInstaller) bundleCacheEntry(gemfileDir, gemfileLockContent string) (*cacheEntry, error) {
+	ruby, err := rubyVersion(i.runner, gemfileDir)
+	if err != nil {
+		return nil, err
+	}
+
+	fastlane, err := gems.ParseVersionFromBundle("fastlane", gemfileLockContent)
+	if err != nil {
+		return nil, err
+	}
+
+	lockHash := sha256.Sum256([]byte(gemfileLockContent))
+	entry, err := i.cache.entry("bundle", "ruby="+ruby, "fastlane="+fastlane.Version, "lock="+hex.EncodeToString(lockHash[:]), "without="+strings.Join(i.bundle.Without, ":"))
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// bundleCheck reports whether all the gems of the bundle are installed
+func (i fastlaneInstaller) bundleCheck(bundlerVersion gems.Version, gemfileDir string, envs []string) bool {
+	cmd := newCommandSpec("bundle", append(bundleVersionArgs(bundlerVersion), "check")...).withDir(gemfileDir).withEnvs(envs...)
+	if out, err := i.runner.CombinedOutput(cmd); err != nil {
+		log.Warnf("Cached bundle is incomplete: %s", out)
+		return false
+	}
+	return true
+}
+
+// bundleVersionArgs returns the `_version_` argument selecting the bundler version of the gem lockfile
+func bundleVersionArgs(bundlerVersion gems.Version) []string {
+	if bundlerVersion.Found {
+		return []string{"_" + bundlerVersion.Version + "_"}
+	}
+	return nil
+}
+
+func hasEnv(envs []string, key string) bool {
+	for _, env := range envs {
+		if strings.HasPrefix(env, key+"=") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// tempFileManager creates the Step's temporary files and directories inside a single private (0700) directory,
+// and removes all of them when the Step exits: on success, on fail() and when the Step is interrupted.
+// Generated credentials (API key JSON) and artifact copies are written here.
+type tempFileManager struct {
+	mu    sync.Mutex
+	root  string
+	paths []string
+}
+
+var tempFiles = &tempFileManager{}
+
+// MkdirTemp creates a new private directory, removed at the latest when the Step exits
+func (m *tempFileManager) MkdirTemp(prefix string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.root == "" {
+		root, err := os.MkdirTemp("", "deliver-")
+		if err != nil {
+			return "", fmt.Errorf("failed to create temporary directory: %s", err)
+		}
+		// MkdirTemp creates 0700 directories, make sure a umask or an existing directory did not change it
+		if err := os.Chmod(root, 0700); err != nil {
+			return "", err
+		}
+		m.root = root
+	}
+
+	dir, err := os.MkdirTemp(m.root, prefix)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary directory: %s", err)
+	}
+	m.paths = append(m.paths, dir)
+	return dir, nil
+}
+
+// Remove removes a directory created by MkdirTemp before the Step exits
+func (m *tempFileManager) Remove(pth string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, p := range m.paths {
+		if p == pth {
+			m.paths = append(m.paths[:i], m.paths[i+1:]...)
+			break
+		}
+	}
+	return os.RemoveAll(pth)
+}
+
+// Cleanup removes everything created by the manager, it is safe to call multiple times
+func (m *tempFileManager) Cleanup() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.paths) - 1; i >= 0; i-- {
+		if err := os.RemoveAll(m.paths[i]); err != nil {
+			log.Warnf("Failed to remove temporary directory (%s): %s", m.paths[i], err)
+		}
+	}
+	m.paths = nil
+
+	if m.root != "" {
+		if err := os.RemoveAll(m.root); err != nil {
+			log.Warnf("Failed to remove temporary directory (%s): %s", m.root, err)
+		}
+		m.root = ""
+	}
+}
+
+// writeSecretFile writes a file only the current user can read, failing if the file already exists
+func writeSecretFile(pth string, content []byte) error {
+	f, err := os.OpenFile(pth, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		_ = f.Close()
+		_ = os.Remove(pth)
+		return err
+	}
+	return f.Close()
+}
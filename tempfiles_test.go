@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_tempFileManager(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	m := &tempFileManager{}
+
+	first, err := m.MkdirTemp("deliver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := m.MkdirTemp("fastlane_plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Dir(first)
+	if filepath.Dir(second) != root {
+		t.Errorf("directories are not created in the same private directory: %s, %s", first, second)
+	}
+	for _, dir := range []string{root, first, second} {
+		if info, err := os.Stat(dir); err != nil {
+			t.Fatal(err)
+		} else if perm := info.Mode().Perm(); perm != 0700 {
+			t.Errorf("%s permissions = %o, want 0700", dir, perm)
+		}
+	}
+
+	secretPth := filepath.Join(first, "api_key.json")
+	if err := writeSecretFile(secretPth, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(secretPth); err != nil {
+		t.Fatal(err)
+	} else if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("secret file permissions = %o, want 0600", perm)
+	}
+	if err := writeSecretFile(secretPth, []byte("other")); err == nil {
+		t.Errorf("writeSecretFile() overwrote an existing file")
+	}
+
+	if err := m.Remove(first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Errorf("%s exists after Remove()", first)
+	}
+
+	m.Cleanup()
+	m.Cleanup()
+	if _, err := os.Stat(root); !os.IsNotExist(err) {
+		t.Errorf("%s exists after Cleanup()", root)
+	}
+
+	// the manager can be used again after a cleanup
+	dir, err := m.MkdirTemp("deliver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(dir) == root {
+		t.Errorf("MkdirTemp() after Cleanup() reused the removed directory")
+	}
+	m.Cleanup()
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// uploadBackend is the tool uploading the binary to App Store Connect
+type uploadBackend string
+
+const (
+	uploadBackendAuto        uploadBackend = "auto"
+	uploadBackendAltool      uploadBackend = "altool"
+	uploadBackendTransporter uploadBackend = "transporter"
+	uploadBackendAPI         uploadBackend = "api"
+)
+
+// transporterAppPath is where fastlane looks for the iTMSTransporter of the Transporter app
+var transporterAppPath = "/Applications/Transporter.app/Contents/itms/bin/iTMSTransporter"
+
+// uploadTools are the upload tools available on the host
+type uploadTools struct {
+	// XcodeMajorVersion is 0 if Xcode is not available
+	XcodeMajorVersion int64
+	// AltoolPath and TransporterPath are empty if the tool is not available
+	AltoolPath      string
+	TransporterPath string
+}
+
+// probeUploadTools looks for altool in the selected Xcode, and for iTMSTransporter in the Transporter app or in the selected Xcode.
+// Without Xcode (xcodeMajorVersion is 0) only the Transporter app is looked for.
+func probeUploadTools(runner commandRunner, xcodeMajorVersion int64) uploadTools {
+	tools := uploadTools{XcodeMajorVersion: xcodeMajorVersion}
+
+	if xcodeMajorVersion > 0 {
+		if out, err := runner.Output(newCommandSpec("xcrun", "--find", "altool")); err == nil && out != "" {
+			tools.AltoolPath = out
+		} else {
+			log.Debugf("altool not found: %s", err)
+		}
+	}
+
+	if _, err := os.Stat(transporterAppPath); err == nil {
+		tools.TransporterPath = transporterAppPath
+	} else if xcodeMajorVersion == 0 {
+		log.Debugf("iTMSTransporter not found: %s", err)
+	} else if out, err := runner.Output(newCommandSpec("xcrun", "--find", "iTMSTransporter")); err == nil && out != "" {
+		tools.TransporterPath = out
+	} else {
+		log.Debugf("iTMSTransporter not found: %s", err)
+	}
+
+	return tools
+}
+
+// hasXcode reports whether Xcode is available on the host
+func (tools uploadTools) hasXcode() bool {
+	return tools.XcodeMajorVersion > 0
+}
+
+// hasTransporter reports whether fastlane can upload with iTMSTransporter: from the Transporter app, or bundled in Xcode 13 and older
+func (tools uploadTools) hasTransporter() bool {
+	return tools.TransporterPath != "" || (tools.hasXcode() && tools.XcodeMajorVersion < 14)
+}
+
+// selectUploadBackend returns the backend for the upload_backend input and the reason of the choice.
+// fastlane uploads with altool on Xcode 14 and later, unless iTMSTransporter is forced.
+// The api backend uploads without fastlane's upload tools, it requires authenticating with an API key.
+func selectUploadBackend(input string, tools uploadTools, itmsParameters string, hasAPIKey bool) (uploadBackend, string, error) {
+	switch uploadBackend(input) {
+	case uploadBackendAuto:
+		switch {
+		case itmsParameters != "" && tools.TransporterPath != "":
+			return uploadBackendTransporter, fmt.Sprintf("the iTMSTransporter upload parameters are set, and iTMSTransporter is available (%s)", tools.TransporterPath), nil
+		case tools.hasXcode() && tools.XcodeMajorVersion < 14:
+			return uploadBackendTransporter, fmt.Sprintf("fastlane uploads with iTMSTransporter on Xcode %d", tools.XcodeMajorVersion), nil
+		case tools.AltoolPath != "":
+			return uploadBackendAltool, fmt.Sprintf("altool is available (%s)", tools.AltoolPath), nil
+		case tools.TransporterPath != "":
+			return uploadBackendTransporter, fmt.Sprintf("altool is not available, iTMSTransporter is (%s)", tools.TransporterPath), nil
+		case hasAPIKey:
+			return uploadBackendAPI, "neither altool nor iTMSTransporter is available, and an API key is used", nil
+		default:
+			return "", "", fmt.Errorf("no upload tool found: altool (Xcode 14 and later) or iTMSTransporter (Transporter app) is required, or an API key for the api upload backend")
+		}
+	case uploadBackendAltool:
+		if !tools.hasXcode() {
+			return "", "", fmt.Errorf("altool upload requires Xcode 14 or later, Xcode is not available on this host")
+		}
+		if tools.XcodeMajorVersion < 14 {
+			return "", "", fmt.Errorf("altool upload requires Xcode 14 or later, the selected Xcode is %d", tools.XcodeMajorVersion)
+		}
+		if tools.AltoolPath == "" {
+			return "", "", fmt.Errorf("altool not found in the selected Xcode")
+		}
+		return uploadBackendAltool, "selected by the upload_backend input", nil
+	case uploadBackendTransporter:
+		if !tools.hasTransporter() {
+			return "", "", fmt.Errorf("iTMSTransporter not found, install the Transporter app (%s)", transporterAppPath)
+		}
+		return uploadBackendTransporter, "selected by the upload_backend input", nil
+	case uploadBackendAPI:
+		if !hasAPIKey {
+			return "", "", fmt.Errorf("the api upload backend requires an App Store Connect API key, Apple ID authentication is used")
+		}
+		return uploadBackendAPI, "selected by the upload_backend input", nil
+	default:
+		return "", "", fmt.Errorf("invalid upload backend: %s", input)
+	}
+}
+
+// uploadEnvs returns the fastlane environment variables selecting the backend, with the iTMSTransporter upload parameters
+func (backend uploadBackend) uploadEnvs(itmsParameters string) []string {
+	if backend != uploadBackendTransporter {
+		return nil
+	}
+
+	envs := []string{"ITMSTRANSPORTER_FORCE_ITMS_PACKAGE_UPLOAD=true"}
+	if itmsParameters != "" {
+		envs = append(envs, "DELIVER_ITMSTRANSPORTER_ADDITIONAL_UPLOAD_PARAMETERS="+itmsParameters)
+	}
+	return envs
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_probeUploadTools(t *testing.T) {
+	transporterApp := filepath.Join(t.TempDir(), "iTMSTransporter")
+	writeTestFile(t, transporterApp, "")
+
+	tests := []struct {
+		name              string
+		runner            *fakeRunner
+		xcodeMajorVersion int64
+		transporterApp    string
+		want              uploadTools
+	}{
+		{
+			name:              "altool in Xcode",
+			xcodeMajorVersion: 15,
+			runner:            newFakeRunner().on("xcrun --find altool", "/Xcode.app/usr/bin/altool", nil).on("xcrun --find iTMSTransporter", "", errors.New("exit status 1")),
+			want:              uploadTools{XcodeMajorVersion: 15, AltoolPath: "/Xcode.app/usr/bin/altool"},
+		},
+		{
+			name:              "Transporter app",
+			runner:            newFakeRunner().on("xcrun --find", "", errors.New("exit status 1")),
+			xcodeMajorVersion: 15,
+			transporterApp:    transporterApp,
+			want:              uploadTools{XcodeMajorVersion: 15, TransporterPath: transporterApp},
+		},
+		{
+			name:              "iTMSTransporter in Xcode",
+			xcodeMajorVersion: 15,
+			runner:            newFakeRunner().on("xcrun --find altool", "", errors.New("exit status 1")).on("xcrun --find iTMSTransporter", "/Xcode.app/itms/bin/iTMSTransporter", nil),
+			want:              uploadTools{XcodeMajorVersion: 15, TransporterPath: "/Xcode.app/itms/bin/iTMSTransporter"},
+		},
+		{
+			name:           "Transporter app without Xcode",
+			runner:         newFakeRunner(),
+			transporterApp: transporterApp,
+			want:           uploadTools{TransporterPath: transporterApp},
+		},
+		{
+			name:   "without Xcode",
+			runner: newFakeRunner(),
+			want:   uploadTools{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := transporterAppPath
+			defer func() { transporterAppPath = original }()
+			transporterAppPath = filepath.Join(t.TempDir(), "missing")
+			if tt.transporterApp != "" {
+				transporterAppPath = tt.transporterApp
+			}
+
+			if got := probeUploadTools(tt.runner, tt.xcodeMajorVersion); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("probeUploadTools() = %+v, want %+v", got, tt.want)
+			}
+			if tt.xcodeMajorVersion == 0 && len(tt.runner.commandLines()) != 0 {
+				t.Errorf("probeUploadTools() commands = %q, want none without Xcode", tt.runner.commandLines())
+			}
+		})
+	}
+}
+
+func Test_selectUploadBackend(t *testing.T) {
+	altool := uploadTools{XcodeMajorVersion: 15, AltoolPath: "/Xcode.app/usr/bin/altool"}
+	transporter := uploadTools{XcodeMajorVersion: 15, TransporterPath: "/Applications/Transporter.app/Contents/itms/bin/iTMSTransporter"}
+	both := uploadTools{XcodeMajorVersion: 15, AltoolPath: altool.AltoolPath, TransporterPath: transporter.TransporterPath}
+
+	tests := []struct {
+		name           string
+		input          string
+		tools          uploadTools
+		itmsParameters string
+		hasAPIKey      bool
+		want           uploadBackend
+		wantErr        bool
+	}{
+		{name: "auto prefers altool", input: "auto", tools: both, want: uploadBackendAltool},
+		{name: "auto uses Transporter for its parameters", input: "auto", tools: both, itmsParameters: "-t DAV", want: uploadBackendTransporter},
+		{name: "auto ignores the parameters without Transporter", input: "auto", tools: altool, itmsParameters: "-t DAV", want: uploadBackendAltool},
+		{name: "auto falls back to Transporter", input: "auto", tools: transporter, want: uploadBackendTransporter},
+		{name: "auto on Xcode 13", input: "auto", tools: uploadTools{XcodeMajorVersion: 13}, want: uploadBackendTransporter},
+		{name: "auto without upload tools", input: "auto", tools: uploadTools{XcodeMajorVersion: 15}, wantErr: true},
+		{name: "auto falls back to the API", input: "auto", tools: uploadTools{XcodeMajorVersion: 15}, hasAPIKey: true, want: uploadBackendAPI},
+		{name: "auto prefers the upload tools to the API", input: "auto", tools: altool, hasAPIKey: true, want: uploadBackendAltool},
+		{name: "altool", input: "altool", tools: both, want: uploadBackendAltool},
+		{name: "altool not found", input: "altool", tools: transporter, wantErr: true},
+		{name: "altool on Xcode 13", input: "altool", tools: uploadTools{XcodeMajorVersion: 13, AltoolPath: altool.AltoolPath}, wantErr: true},
+		{name: "transporter", input: "transporter", tools: both, want: uploadBackendTransporter},
+		{name: "transporter not found", input: "transporter", tools: altool, wantErr: true},
+		{name: "auto without Xcode uses the Transporter app", input: "auto", tools: uploadTools{TransporterPath: transporter.TransporterPath}, want: uploadBackendTransporter},
+		{name: "auto without Xcode uses the API", input: "auto", hasAPIKey: true, want: uploadBackendAPI},
+		{name: "altool without Xcode", input: "altool", hasAPIKey: true, wantErr: true},
+		{name: "transporter without Xcode", input: "transporter", wantErr: true},
+		{name: "api", input: "api", tools: both, hasAPIKey: true, want: uploadBackendAPI},
+		{name: "api without upload tools", input: "api", hasAPIKey: true, want: uploadBackendAPI},
+		{name: "api with Apple ID", input: "api", tools: both, wantErr: true},
+		{name: "invalid", input: "ftp", tools: both, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason, err := selectUploadBackend(tt.input, tt.tools, tt.itmsParameters, tt.hasAPIKey)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectUploadBackend() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("selectUploadBackend() = %s, want %s", got, tt.want)
+			}
+			if err == nil && reason == "" {
+				t.Errorf("selectUploadBackend() returned no reason")
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// xar is the archive format of macOS installer packages (.pkg)
+// see: https://github.com/mackyle/xar/wiki/xarformat
+const xarMagic = 0x78617221 // "xar!"
+
+type xarFile struct {
+	Name  string    `xml:"name"`
+	Type  string    `xml:"type"`
+	Data  xarData   `xml:"data"`
+	Files []xarFile `xml:"file"`
+}
+
+type xarData struct {
+	Offset   int64 `xml:"offset"`
+	Length   int64 `xml:"length"`
+	Encoding struct {
+		Style string `xml:"style,attr"`
+	} `xml:"encoding"`
+}
+
+type xarTOC struct {
+	Signature struct {
+		Certificates []string `xml:"KeyInfo>X509Data>X509Certificate"`
+	} `xml:"toc>signature"`
+	Files []xarFile `xml:"toc>file"`
+}
+
+type xarArchive struct {
+	f          *os.File
+	heapOffset int64
+	toc        xarTOC
+}
+
+func openXar(pth string) (*xarArchive, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return nil, err
+	}
+
+	archive, err := readXar(f)
+	if err != nil {
+		if cerr := f.Close(); cerr != nil {
+			return nil, fmt.Errorf("%s, and failed to close file: %s", err, cerr)
+		}
+		return nil, err
+	}
+	return archive, nil
+}
+
+func readXar(f *os.File) (*xarArchive, error) {
+	var header struct {
+		Magic             uint32
+		HeaderSize        uint16
+		Version           uint16
+		TOCCompressed     uint64
+		TOCUncompressed   uint64
+		ChecksumAlgorithm uint32
+	}
+	if err := binary.Read(f, binary.BigEndian, &header); err != nil {
+		return nil, fmt.Errorf("failed to read xar header: %s", err)
+	}
+	if header.Magic != xarMagic {
+		return nil, errors.New("not a xar archive")
+	}
+
+	if _, err := f.Seek(int64(header.HeaderSize), io.SeekStart); err != nil {
+		return nil, err
+	}
+	zr, err := zlib.NewReader(io.LimitReader(f, int64(header.TOCCompressed)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read xar table of contents: %s", err)
+	}
+
+	var toc xarTOC
+	if err := xml.NewDecoder(zr).Decode(&toc); err != nil {
+		return nil, fmt.Errorf("failed to parse xar table of contents: %s", err)
+	}
+
+	return &xarArchive{
+		f:          f,
+		heapOffset: int64(header.HeaderSize) + int64(header.TOCCompressed),
+		toc:        toc,
+	}, nil
+}
+
+func (a *xarArchive) Close() error {
+	return a.f.Close()
+}
+
+// findFile returns the first file (depth first) with the given name
+func (a *xarArchive) findFile(name string) (xarFile, bool) {
+	var find func(files []xarFile) (xarFile, bool)
+	find = func(files []xarFile) (xarFile, bool) {
+		for _, file := range files {
+			if file.Name == name && file.Type == "file" {
+				return file, true
+			}
+			if found, ok := find(file.Files); ok {
+				return found, true
+			}
+		}
+		return xarFile{}, false
+	}
+	return find(a.toc.Files)
+}
+
+func (a *xarArchive) readFile(file xarFile, maxSize int64) ([]byte, error) {
+	if file.Data.Length > maxSize {
+		return nil, fmt.Errorf("%s is too large (%d bytes)", file.Name, file.Data.Length)
+	}
+
+	var r io.Reader = io.NewSectionReader(a.f, a.heapOffset+file.Data.Offset, file.Data.Length)
+	switch {
+	case strings.Contains(file.Data.Encoding.Style, "gzip"):
+		// xar stores zlib streams with the x-gzip encoding style
+		zr, err := zlib.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		r = zr
+	case strings.Contains(file.Data.Encoding.Style, "bzip2"):
+		r = bzip2.NewReader(r)
+	}
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, io.LimitReader(r, maxSize)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
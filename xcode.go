@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-xcode/models"
+	"github.com/bitrise-io/go-xcode/utility"
+)
+
+// developerDirEnvKey selects the Xcode of xcodebuild, xcrun and the tools fastlane runs, instead of the one selected by xcode-select
+const developerDirEnvKey = "DEVELOPER_DIR"
+
+// resolveDeveloperDir returns the developer directory of the Xcode at xcodePath,
+// which is either the Xcode app (/Applications/Xcode-15.app) or its Contents/Developer directory
+func resolveDeveloperDir(xcodePath string) (string, error) {
+	pth, err := filepath.Abs(xcodePath)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(pth)
+	if err != nil {
+		return "", fmt.Errorf("Xcode not found at %s: %w", xcodePath, err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not an Xcode app or developer directory", xcodePath)
+	}
+
+	if strings.HasSuffix(pth, ".app") {
+		pth = filepath.Join(pth, "Contents", "Developer")
+	}
+	if _, err := os.Stat(filepath.Join(pth, "usr", "bin", "xcodebuild")); err != nil {
+		return "", fmt.Errorf("%s is not an Xcode app or developer directory: usr/bin/xcodebuild not found in %s", xcodePath, pth)
+	}
+	return pth, nil
+}
+
+// detectXcode returns the version of the selected Xcode, or nil if Xcode is not available.
+// Xcode is optional: only the altool and the Xcode bundled iTMSTransporter uploads need it.
+func detectXcode() *models.XcodebuildVersionModel {
+	version, err := utility.GetXcodeVersion()
+	if err != nil {
+		log.Debugf("Xcode not found: %s", err)
+		return nil
+	}
+	return &version
+}
+
+var toolVersionRegexp = regexp.MustCompile(`(\d+(?:\.\d+)+)(?:\s+\((\w+)\))?`)
+
+// parseToolVersion returns the version reported by altool --version or iTMSTransporter -version,
+// for example 3.3.0 (1234) from "iTMSTransporter, version 3.3.0 (1234)"
+func parseToolVersion(out string) string {
+	for _, line := range strings.Split(out, "\n") {
+		if !strings.Contains(strings.ToLower(line), "version") && !strings.HasPrefix(strings.TrimSpace(line), "altool") {
+			continue
+		}
+		if match := toolVersionRegexp.FindStringSubmatch(line); match != nil {
+			if match[2] != "" {
+				return fmt.Sprintf("%s (%s)", match[1], match[2])
+			}
+			return match[1]
+		}
+	}
+	return ""
+}
+
+// uploadToolVersion returns the version of the tool the backend uploads with, or an empty string if it is not known
+func uploadToolVersion(runner commandRunner, backend uploadBackend, tools uploadTools) string {
+	var cmd commandSpec
+	switch {
+	case backend == uploadBackendAltool:
+		cmd = newCommandSpec("xcrun", "altool", "--version")
+	case backend == uploadBackendTransporter && tools.TransporterPath != "":
+		cmd = newCommandSpec(tools.TransporterPath, "-version")
+	default:
+		return ""
+	}
+
+	out, err := runner.CombinedOutput(cmd)
+	if err != nil {
+		log.Debugf("Failed to read the upload tool version (%s): %s", cmd, err)
+		return ""
+	}
+	return parseToolVersion(out)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_resolveDeveloperDir(t *testing.T) {
+	dir := t.TempDir()
+	xcodeApp := filepath.Join(dir, "Xcode-15.app")
+	developerDir := filepath.Join(xcodeApp, "Contents", "Developer")
+	if err := os.MkdirAll(filepath.Join(developerDir, "usr", "bin"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(developerDir, "usr", "bin", "xcodebuild"), "")
+	notXcode := filepath.Join(dir, "Other.app")
+	if err := os.MkdirAll(notXcode, 0700); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "Xcode.xip")
+	writeTestFile(t, file, "")
+
+	tests := []struct {
+		name      string
+		xcodePath string
+		want      string
+		wantErr   bool
+	}{
+		{name: "Xcode app", xcodePath: xcodeApp, want: developerDir},
+		{name: "developer directory", xcodePath: developerDir, want: developerDir},
+		{name: "missing", xcodePath: filepath.Join(dir, "Xcode-14.app"), wantErr: true},
+		{name: "not an Xcode", xcodePath: notXcode, wantErr: true},
+		{name: "file", xcodePath: file, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveDeveloperDir(tt.xcodePath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveDeveloperDir() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveDeveloperDir() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseToolVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want string
+	}{
+		{name: "iTMSTransporter", out: "[2024-01-10 10:00:00 CET] <main> INFO: Configuring logging...\niTMSTransporter, version 3.3.0 (1234)", want: "3.3.0 (1234)"},
+		{name: "altool", out: "altool 8.306.13305 (13305)", want: "8.306.13305 (13305)"},
+		{name: "version without build", out: "iTMSTransporter, version 2.3.0", want: "2.3.0"},
+		{name: "no version", out: "xcrun: error: unable to find utility \"altool\"", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseToolVersion(tt.out); got != tt.want {
+				t.Errorf("parseToolVersion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_uploadToolVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		runner  *fakeRunner
+		backend uploadBackend
+		tools   uploadTools
+		want    string
+	}{
+		{
+			name:    "altool",
+			runner:  newFakeRunner().on("xcrun altool --version", "altool 8.306.13305 (13305)", nil),
+			backend: uploadBackendAltool,
+			want:    "8.306.13305 (13305)",
+		},
+		{
+			name:    "Transporter app",
+			runner:  newFakeRunner().on("/Applications/Transporter.app/Contents/itms/bin/iTMSTransporter -version", "iTMSTransporter, version 3.3.0 (1234)", nil),
+			backend: uploadBackendTransporter,
+			tools:   uploadTools{TransporterPath: "/Applications/Transporter.app/Contents/itms/bin/iTMSTransporter"},
+			want:    "3.3.0 (1234)",
+		},
+		{
+			name:    "failure",
+			runner:  newFakeRunner().on("xcrun altool --version", "", errors.New("exit status 1")),
+			backend: uploadBackendAltool,
+			want:    "",
+		},
+		{
+			name:    "api",
+			runner:  newFakeRunner(),
+			backend: uploadBackendAPI,
+			want:    "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := uploadToolVersion(tt.runner, tt.backend, tt.tools); got != tt.want {
+				t.Errorf("uploadToolVersion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}